@@ -2,79 +2,52 @@
 package main
 
 import (
+	"context"
+	"flag"
 	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"p2pfs/internal/config"
+	"p2pfs/internal/control"
 	"p2pfs/internal/gui"
-	"p2pfs/internal/peer"
-	"time"
+	"p2pfs/internal/node"
 )
 
 func main() {
-	// 🛠 Configuración inicial
-	port := "8001"
-	localIP := peer.GetLocalIP()
-	fmt.Println("Esta máquina tiene IP:", localIP)
+	opts := config.RegisterFlags(flag.CommandLine)
+	flag.Parse()
 
-	// Crear nodo sin ID asignado aún
-	self := &peer.Peer{
-		ID:    0,
-		IP:    localIP,
-		Port:  port,
-		Peers: []peer.PeerInfo{},
+	cfg, err := config.Load(opts)
+	if err != nil {
+		fmt.Println("❌", err)
+		os.Exit(2)
 	}
 
-	// 🔊 Listeners y tareas de red
-	go peer.ListenForBroadcasts(self, func() []peer.PeerInfo {
-		return self.Peers
-	})
-	go peer.BroadcastHello(self)
-	go self.StartListener()
-	go self.RetryWorker(10 * time.Second)
-	go self.MonitorPeersAndSync(5 * time.Second)
-
-	// ⏱️ Esperar ID o asignarlo
-	time.Sleep(5 * time.Second)
-	if self.ID == 0 {
-		fmt.Println("⚠️  No se recibió ASSIGN_ID. Asignando ID=1 como nodo inicial.")
-		self.ID = 1
-		self.LastIDAssigned = time.Now()
+	// Una señal cierra la GUI y detiene el nodo igual que cerrar la ventana
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
 
-		// 👤 Agregar el propio nodo como peer visible
-		self.Peers = append(self.Peers, peer.PeerInfo{
-			ID:   self.ID,
-			IP:   self.IP,
-			Port: self.Port,
-		})
-
-		// 🔈 Anunciar el nodo
-		newNode := peer.NodeAnnouncement{
-			Type: "NEW_NODE",
-			IP:   self.IP,
-			Port: self.Port,
-			ID:   self.ID,
-		}
-		peer.BroadcastNewNode(newNode)
-	}
+	// 🛠 Arrancar red, descubrimiento y tareas de fondo
+	n := node.Start(ctx, cfg)
+	api := control.NewAPI(n)
 
-	// ✅ Asegurar inclusión propia si ya fue asignado por otro
-	alreadyPresent := false
-	for _, p := range self.Peers {
-		if p.IP == self.IP && p.Port == self.Port {
-			alreadyPresent = true
-			break
-		}
-	}
-	if !alreadyPresent {
-		self.Peers = append(self.Peers, peer.PeerInfo{
-			ID:   self.ID,
-			IP:   self.IP,
-			Port: self.Port,
-		})
+	// 🎛️ La CLI puede manejar también el nodo de la GUI
+	servers, err := control.Serve(cfg, api)
+	if err != nil {
+		fmt.Println("⚠️ API de control no disponible:", err)
 	}
 
 	// 🖼️ Lanzar GUI con información válida
 	fmt.Println("🟢 Lanzando GUI...")
-	gui.StartGUI(self.ID, func() []peer.PeerInfo {
-		return self.Peers
-	}, self)
-}
+	gui.StartGUI(ctx, api)
 
+	// Ventana cerrada: terminar lo que está a medias antes de salir. Desde
+	// aquí otra señal vuelve a terminar el proceso sin esperar.
+	stop()
+	for _, srv := range servers {
+		srv.Close()
+	}
+	n.Shutdown()
+}
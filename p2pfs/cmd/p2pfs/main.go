@@ -0,0 +1,885 @@
+// main.go - Cliente de línea de comandos para un nodo P2PFS en ejecución
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"p2pfs/internal/bandwidth"
+	"p2pfs/internal/config"
+	"p2pfs/internal/control"
+	"p2pfs/internal/fs"
+	"p2pfs/internal/jobs"
+	logger "p2pfs/internal/log"
+	"p2pfs/internal/peer"
+	"p2pfs/internal/snapshot"
+	"p2pfs/internal/versions"
+
+	"gopkg.in/yaml.v3"
+)
+
+const usage = `Uso: p2pfs [-config archivo] [-socket ruta|http://host:puerto] [-token archivo] [-json] <comando> [argumentos]
+
+Comandos:
+  status                  estado del nodo local
+  peers                   peers conocidos y su disponibilidad
+  ls [nodo] [ruta]        árbol compartido de un nodo (local por defecto)
+  get nodo:ruta           descarga un archivo remoto a la misma carpeta local
+  put ruta [nodo...]      envía un archivo a los nodos indicados o a todos
+  rm ruta                 elimina un archivo y propaga el borrado
+  sync [nodo]             sincroniza ahora con un nodo o con todos
+  export ruta [zip]       guarda un archivo o carpeta local en un ZIP
+  snapshot export carpeta [zip]
+                          guarda la carpeta, sus versiones y un manifiesto
+  snapshot import zip [carpeta]
+                          siembra la carpeta (y el estado) desde un snapshot
+  versions ruta           versiones guardadas de un archivo
+  restore ruta versión    recupera una versión y la replica
+  trash list [carpeta]    lo borrado por otros nodos que sigue en la papelera
+  trash restore carpeta id
+                          recupera un elemento de la papelera y lo replica
+  changes                 cambios locales en carpetas receive-only
+  revert carpeta          impone la copia local (send-only) o descarta
+                          los cambios locales (receive-only)
+  repair                  repone ahora las copias que falten en las
+                          carpetas con factor de replicación
+  transfers [-f]          transferencias en curso; -f sigue su avance
+  transfers cancel ID     cancela una transferencia en curso
+  config                  configuración efectiva del nodo (YAML)
+  retry list [--dead]     muestra la cola de reintentos
+  retry show ID           muestra un trabajo y su último error
+  retry flush             reintenta ahora los trabajos pendientes
+  retry cancel ID         quita un trabajo de la cola
+  retry requeue [ID]      vuelve a intentar un trabajo fallido (o todos)
+  log tail [-n N] [-f]    últimas operaciones del registro local
+
+Un nodo es un ID numérico, "local" o una dirección host:puerto. Las rutas
+dentro de un nodo empiezan por el nombre de la carpeta compartida
+(p. ej. shared/notas.txt o fotos/2024/a.jpg).
+Sin -socket se usa el socket de control de la configuración del nodo
+(-config, P2PFS_CONFIG, P2PFS_DATA_DIR...), y sin -token el archivo de
+token de esa misma configuración (control_token), que el nodo crea al
+arrancar y solo puede leer su usuario.
+`
+
+var (
+	client  *control.Client
+	jsonOut bool
+)
+
+func main() {
+	configFile := flag.String("config", "", "archivo de configuración del nodo")
+	socket := flag.String("socket", "", "socket Unix de control del nodo o URL http:// de su API local")
+	tokenFile := flag.String("token", "", "archivo con el token de la API de control del nodo")
+	flag.BoolVar(&jsonOut, "json", false, "imprimir las respuestas en JSON")
+	flag.Usage = func() { fmt.Fprint(os.Stderr, usage) }
+	flag.Parse()
+
+	args := flag.Args()
+	if len(args) == 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+	if *socket == "" || *tokenFile == "" {
+		// Misma configuración que el nodo: archivo y entorno
+		fset := flag.NewFlagSet("config", flag.ContinueOnError)
+		opts := config.RegisterFlags(fset)
+		if *configFile != "" {
+			fset.Parse([]string{"-config", *configFile})
+		}
+		cfg, err := config.Load(opts)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "❌", err)
+			os.Exit(2)
+		}
+		if *socket == "" {
+			*socket = cfg.ControlSocket
+		}
+		if *tokenFile == "" {
+			*tokenFile = cfg.ControlToken
+		}
+	}
+	token, err := control.LoadToken(*tokenFile)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "❌ No se pudo leer el token de la API de control (¿está en ejecución el nodo?):", err)
+		os.Exit(2)
+	}
+	client = control.NewClient(*socket, token)
+
+	switch args[0] {
+	case "status":
+		err = cmdStatus()
+	case "peers":
+		err = cmdPeers()
+	case "ls":
+		err = cmdList(args[1:])
+	case "get":
+		err = cmdGet(args[1:])
+	case "put":
+		err = cmdPut(args[1:])
+	case "rm":
+		err = cmdRemove(args[1:])
+	case "sync":
+		err = cmdSync(args[1:])
+	case "export":
+		err = cmdExport(args[1:])
+	case "snapshot":
+		err = cmdSnapshot(args[1:])
+	case "versions":
+		err = cmdVersions(args[1:])
+	case "restore":
+		err = cmdRestore(args[1:])
+	case "trash":
+		err = cmdTrash(args[1:])
+	case "changes":
+		err = cmdChanges()
+	case "revert":
+		err = cmdRevert(args[1:])
+	case "repair":
+		err = cmdRepair()
+	case "transfers":
+		err = cmdTransfers(args[1:])
+	case "config":
+		err = cmdConfig()
+	case "retry":
+		err = cmdRetry(args[1:])
+	case "log":
+		err = cmdLog(args[1:])
+	case "help", "-h", "--help":
+		fmt.Print(usage)
+	default:
+		fmt.Fprintf(os.Stderr, "comando desconocido: %s\n\n", args[0])
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "❌", err)
+		os.Exit(1)
+	}
+}
+
+// printJSON imprime v tal cual si se pidió -json; devuelve true en ese caso
+func printJSON(v interface{}) bool {
+	if !jsonOut {
+		return false
+	}
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	enc.Encode(v)
+	return true
+}
+
+func cmdStatus() error {
+	var st control.Status
+	if err := client.Get("/status", nil, &st); err != nil {
+		return err
+	}
+	if printJSON(st) {
+		return nil
+	}
+	fmt.Printf("ID:          %d\n", st.ID)
+	fmt.Printf("Dirección:   %s\n", st.Addr)
+	fmt.Printf("Direcciones: %s\n", strings.Join(st.Addrs, ", "))
+	fmt.Printf("Descubrim.:  %s\n", st.Discovery)
+	fmt.Printf("Peers:       %d (%d en línea)\n", st.Peers, st.Online)
+	fmt.Printf("Pendientes:  %d (%d fallidos)\n", st.PendingTasks, st.DeadTasks)
+	fmt.Printf("En curso:    %d (%d en espera)\n", st.Transfers, st.Queued)
+	fmt.Printf("Límites:     subida %s, bajada %s\n", st.Upload, st.Download)
+	fmt.Printf("Activo:      %s\n", st.Uptime)
+	return nil
+}
+
+func cmdPeers() error {
+	var peers []control.PeerStatus
+	if err := client.Get("/peers", nil, &peers); err != nil {
+		return err
+	}
+	if printJSON(peers) {
+		return nil
+	}
+	tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "ID\tDIRECCIÓN\tESTADO\tVISTO")
+	for _, p := range peers {
+		status := "offline"
+		if p.Self {
+			status = "local"
+		} else if p.Online {
+			status = "online"
+		}
+		seen := "-"
+		if !p.LastSeen.IsZero() {
+			seen = p.LastSeen.Format("2006-01-02 15:04:05")
+		}
+		fmt.Fprintf(tw, "%d\t%s\t%s\t%s\n", p.ID, p.Addr, status, seen)
+	}
+	return tw.Flush()
+}
+
+// isNodeRef distingue en "ls x" si x es un nodo o una ruta local
+func isNodeRef(s string) bool {
+	if s == "local" || s == "self" {
+		return true
+	}
+	if _, err := strconv.Atoi(s); err == nil {
+		return true
+	}
+	// host:puerto o [ipv6]:puerto; una IPv6 sin corchetes no lo es
+	host, port, err := net.SplitHostPort(s)
+	if err != nil || host == "" {
+		return false
+	}
+	_, err = strconv.Atoi(port)
+	return err == nil
+}
+
+func cmdList(args []string) error {
+	q := url.Values{}
+	switch {
+	case len(args) >= 2:
+		q.Set("node", args[0])
+		q.Set("path", args[1])
+	case len(args) == 1 && isNodeRef(args[0]):
+		q.Set("node", args[0])
+	case len(args) == 1:
+		q.Set("path", args[0])
+	}
+
+	var tree fs.FileNode
+	if err := client.Get("/tree", q, &tree); err != nil {
+		return err
+	}
+	if printJSON(tree) {
+		return nil
+	}
+	printTree(tree, "")
+	return nil
+}
+
+func printTree(node fs.FileNode, indent string) {
+	switch {
+	case node.IsDir:
+		fmt.Printf("%s📁 %s/\n", indent, node.Name)
+	case node.Link != "":
+		fmt.Printf("%s🔗 %s -> %s\n", indent, node.Name, node.Link)
+	default:
+		fmt.Printf("%s📄 %s\t%s\n", indent, node.Name, node.ModTime.Format("2006-01-02 15:04"))
+	}
+	for _, child := range node.Children {
+		printTree(child, indent+"  ")
+	}
+}
+
+// splitNodePath separa "nodo:ruta"; el nodo puede ser un ID, "local",
+// host:puerto o [ipv6]:puerto
+func splitNodePath(s string) (string, string, error) {
+	if strings.HasPrefix(s, "[") {
+		end := strings.Index(s, "]:")
+		if end < 0 {
+			return "", "", fmt.Errorf("nodo IPv6 no válido en %q", s)
+		}
+		rest := s[end+2:]
+		i := strings.Index(rest, ":")
+		if i < 0 {
+			return "", "", fmt.Errorf("falta la ruta en %q", s)
+		}
+		if node := s[:end+2+i]; isNodeRef(node) {
+			return node, rest[i+1:], nil
+		}
+		return "", "", fmt.Errorf("nodo IPv6 no válido en %q", s)
+	}
+
+	i := strings.Index(s, ":")
+	if i < 0 {
+		return "", "", fmt.Errorf("se esperaba nodo:ruta, se recibió %q", s)
+	}
+	node, rest := s[:i], s[i+1:]
+	if isNodeRef(node) {
+		return node, rest, nil
+	}
+	// host:puerto:ruta; una IPv6 tiene que ir entre corchetes
+	j := strings.Index(rest, ":")
+	if j < 0 || !isNodeRef(s[:i+1+j]) {
+		return "", "", fmt.Errorf("nodo no válido en %q (una IPv6 va entre corchetes: [ipv6]:puerto)", s)
+	}
+	return s[:i+1+j], rest[j+1:], nil
+}
+
+func cmdGet(args []string) error {
+	if len(args) != 1 {
+		return fmt.Errorf("uso: p2pfs get nodo:ruta")
+	}
+	node, path, err := splitNodePath(args[0])
+	if err != nil {
+		return err
+	}
+
+	var res control.TransferResult
+	if err := client.Post("/get", control.Args{Node: node, Path: path}, &res); err != nil {
+		return err
+	}
+	if printJSON(res) {
+		return nil
+	}
+	if !res.OK {
+		return fmt.Errorf("%s: %s", res.Node, res.Error)
+	}
+	fmt.Printf("✅ %s descargado desde %s\n", path, res.Node)
+	return nil
+}
+
+func cmdPut(args []string) error {
+	if len(args) < 1 {
+		return fmt.Errorf("uso: p2pfs put ruta [nodo...]")
+	}
+	path := args[0]
+	// Una ruta que existe desde aquí se envía tal cual; si no, el nodo la
+	// busca como "carpeta/ruta" en sus carpetas compartidas
+	if _, err := os.Stat(path); err == nil {
+		path, _ = filepath.Abs(path)
+	}
+
+	var results []control.TransferResult
+	if err := client.Post("/put", control.Args{Path: path, Nodes: args[1:]}, &results); err != nil {
+		return err
+	}
+	if printJSON(results) {
+		return nil
+	}
+	return reportResults(results, "📤 enviado a")
+}
+
+func cmdRemove(args []string) error {
+	if len(args) != 1 {
+		return fmt.Errorf("uso: p2pfs rm ruta")
+	}
+	var failed []control.TransferResult
+	if err := client.Post("/rm", control.Args{Path: args[0]}, &failed); err != nil {
+		return err
+	}
+	if printJSON(failed) {
+		return nil
+	}
+	fmt.Printf("🗑️ %s eliminado\n", args[0])
+	return reportResults(failed, "")
+}
+
+// reportResults imprime un resultado por nodo y falla si alguno falló
+func reportResults(results []control.TransferResult, okMsg string) error {
+	if len(results) == 0 && okMsg != "" {
+		fmt.Println("⚠️ No hay peers destino en línea")
+		return nil
+	}
+	failed := 0
+	for _, r := range results {
+		if r.OK {
+			fmt.Println(okMsg, r.Node)
+			continue
+		}
+		failed++
+		fmt.Printf("⚠️ %s: %s\n", r.Node, r.Error)
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d nodo(s) fallaron", failed)
+	}
+	return nil
+}
+
+// cmdConfig imprime la configuración en YAML, lista para usar como archivo
+func cmdConfig() error {
+	var cfg config.Config
+	if err := client.Get("/config", nil, &cfg); err != nil {
+		return err
+	}
+	if printJSON(cfg) {
+		return nil
+	}
+	if cfg.File != "" {
+		fmt.Println("# leída de", cfg.File)
+	}
+	return yaml.NewEncoder(os.Stdout).Encode(cfg)
+}
+
+func cmdSync(args []string) error {
+	var a control.Args
+	if len(args) > 0 {
+		a.Node = args[0]
+	}
+	var started []string
+	if err := client.Post("/sync", a, &started); err != nil {
+		return err
+	}
+	if printJSON(started) {
+		return nil
+	}
+	if len(started) == 0 {
+		fmt.Println("⚠️ No hay peers en línea para sincronizar")
+	}
+	for _, addr := range started {
+		fmt.Println("🔁 Sincronización iniciada con", addr)
+	}
+	return nil
+}
+
+func cmdExport(args []string) error {
+	if len(args) < 1 || len(args) > 2 {
+		return fmt.Errorf("uso: p2pfs export ruta [archivo.zip]")
+	}
+	target := path.Base(args[0]) + ".zip"
+	if len(args) == 2 {
+		target = args[1]
+	}
+	f, err := os.Create(target)
+	if err != nil {
+		return err
+	}
+	if err := client.Download("/export", url.Values{"path": {args[0]}}, f); err != nil {
+		f.Close()
+		os.Remove(target)
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	fmt.Printf("📦 %s exportado a %s\n", args[0], target)
+	return nil
+}
+
+func cmdSnapshot(args []string) error {
+	switch {
+	case len(args) >= 2 && len(args) <= 3 && args[0] == "export":
+		target := args[1] + ".snapshot.zip"
+		if len(args) == 3 {
+			target = args[2]
+		}
+		f, err := os.Create(target)
+		if err != nil {
+			return err
+		}
+		if err := client.Download("/snapshot", url.Values{"share": {args[1]}}, f); err != nil {
+			f.Close()
+			os.Remove(target)
+			return err
+		}
+		if err := f.Close(); err != nil {
+			return err
+		}
+		fmt.Printf("📦 Snapshot de %s guardado en %s\n", args[1], target)
+		return nil
+
+	case len(args) >= 2 && len(args) <= 3 && args[0] == "import":
+		// El nodo abre el archivo: necesita la ruta absoluta
+		file, err := filepath.Abs(args[1])
+		if err != nil {
+			return err
+		}
+		a := control.Args{File: file}
+		if len(args) == 3 {
+			a.Share = args[2]
+		}
+		var res snapshot.Result
+		if err := client.Post("/snapshot/import", a, &res); err != nil {
+			return err
+		}
+		if printJSON(res) {
+			return nil
+		}
+		fmt.Printf("📥 Snapshot importado en %s: %d archivo(s) escritos, %d sin cambios, %d carpeta(s), %d versión(es)\n",
+			res.Share, res.Files, res.Skipped, res.Dirs, res.Versions)
+		return nil
+	}
+	return fmt.Errorf("uso: p2pfs snapshot export carpeta [archivo.zip] | snapshot import archivo.zip [carpeta]")
+}
+
+func cmdVersions(args []string) error {
+	if len(args) != 1 {
+		return fmt.Errorf("uso: p2pfs versions ruta")
+	}
+	var list []versions.Version
+	if err := client.Get("/versions", url.Values{"path": {args[0]}}, &list); err != nil {
+		return err
+	}
+	if printJSON(list) {
+		return nil
+	}
+	if len(list) == 0 {
+		fmt.Println("Sin versiones guardadas")
+		return nil
+	}
+	tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "VERSIÓN\tFECHA\tTAMAÑO")
+	for _, v := range list {
+		fmt.Fprintf(tw, "%s\t%s\t%d\n", v.ID, v.Time.Format("2006-01-02 15:04:05"), v.Size)
+	}
+	return tw.Flush()
+}
+
+func cmdRestore(args []string) error {
+	if len(args) != 2 {
+		return fmt.Errorf("uso: p2pfs restore ruta versión")
+	}
+	var results []control.TransferResult
+	if err := client.Post("/restore", control.Args{Path: args[0], Version: args[1]}, &results); err != nil {
+		return err
+	}
+	if printJSON(results) {
+		return nil
+	}
+	fmt.Printf("♻️ %s restaurado a la versión %s\n", args[0], args[1])
+	return reportResults(results, "📤 enviado a")
+}
+
+func cmdTrash(args []string) error {
+	switch {
+	case len(args) >= 1 && len(args) <= 2 && args[0] == "list":
+		query := url.Values{}
+		if len(args) == 2 {
+			query.Set("share", args[1])
+		}
+		var items []control.TrashItem
+		if err := client.Get("/trash", query, &items); err != nil {
+			return err
+		}
+		if printJSON(items) {
+			return nil
+		}
+		if len(items) == 0 {
+			fmt.Println("🗑️ La papelera está vacía")
+			return nil
+		}
+		tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(tw, "CARPETA\tID\tRUTA\tTAMAÑO\tBORRADO POR")
+		for _, item := range items {
+			name := item.Path
+			if item.IsDir {
+				name += "/"
+			}
+			fmt.Fprintf(tw, "%s\t%s\t%s\t%d\t%s\n", item.Share, item.ID, name, item.Size, item.From)
+		}
+		return tw.Flush()
+
+	case len(args) == 3 && args[0] == "restore":
+		var results []control.TransferResult
+		if err := client.Post("/trash/restore", control.Args{Share: args[1], ID: args[2]}, &results); err != nil {
+			return err
+		}
+		if printJSON(results) {
+			return nil
+		}
+		fmt.Printf("♻️ Elemento %s recuperado de la papelera de %s\n", args[2], args[1])
+		return reportResults(results, "📤 enviado a")
+	}
+	return fmt.Errorf("uso: p2pfs trash list [carpeta] | trash restore carpeta id")
+}
+
+func cmdChanges() error {
+	var changes []peer.LocalChange
+	if err := client.Get("/changes", nil, &changes); err != nil {
+		return err
+	}
+	if printJSON(changes) {
+		return nil
+	}
+	if len(changes) == 0 {
+		fmt.Println("✅ Sin cambios locales")
+		return nil
+	}
+	tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "CARPETA\tRUTA\tCAMBIO")
+	for _, c := range changes {
+		fmt.Fprintf(tw, "%s\t%s\t%s\n", c.Share, c.Path, c.Kind)
+	}
+	return tw.Flush()
+}
+
+func cmdRevert(args []string) error {
+	if len(args) != 1 {
+		return fmt.Errorf("uso: p2pfs revert carpeta")
+	}
+	var res control.RevertResult
+	if err := client.Post("/revert", control.Args{Share: args[0]}, &res); err != nil {
+		return err
+	}
+	if printJSON(res) {
+		return nil
+	}
+	fmt.Printf("↩️ %s: %d archivo(s) corregidos\n", res.Share, res.Fixed)
+	if res.Error != "" {
+		return fmt.Errorf("%s", res.Error)
+	}
+	return nil
+}
+
+func cmdRepair() error {
+	var results []peer.RepairResult
+	if err := client.Post("/repair", control.Args{}, &results); err != nil {
+		return err
+	}
+	if printJSON(results) {
+		return nil
+	}
+	if len(results) == 0 {
+		fmt.Println("✅ Ninguna carpeta tiene factor de replicación")
+		return nil
+	}
+	for _, res := range results {
+		fmt.Printf("🩹 %s: %d archivo(s) revisados, %d con copias de menos, %d copia(s) enviadas\n", res.Share, res.Files, res.Missing, res.Sent)
+		for _, e := range res.Errors {
+			fmt.Printf("   ⚠️ %s\n", e)
+		}
+	}
+	return nil
+}
+
+func cmdTransfers(args []string) error {
+	if len(args) > 0 && args[0] == "cancel" {
+		if len(args) != 2 {
+			return fmt.Errorf("uso: p2pfs transfers cancel ID")
+		}
+		var res map[string]int
+		if err := client.Post("/transfers/cancel", control.Args{ID: args[1]}, &res); err != nil {
+			return err
+		}
+		if printJSON(res) {
+			return nil
+		}
+		fmt.Printf("🚫 Transferencia %s cancelada\n", args[1])
+		return nil
+	}
+	fset := flag.NewFlagSet("transfers", flag.ContinueOnError)
+	follow := fset.Bool("f", false, "seguir mostrando el avance de las transferencias")
+	if err := fset.Parse(args); err != nil {
+		return err
+	}
+
+	var transfers []peer.Transfer
+	if err := client.Get("/transfers", nil, &transfers); err != nil {
+		return err
+	}
+	if *follow {
+		for _, t := range transfers {
+			printTransfer(peer.TransferEvent{Type: peer.TransferProgress, Transfer: t})
+		}
+		return client.Stream("/transfers/events", nil, func(dec *json.Decoder) error {
+			var ev peer.TransferEvent
+			if err := dec.Decode(&ev); err != nil {
+				return err
+			}
+			printTransfer(ev)
+			return nil
+		})
+	}
+	if printJSON(transfers) {
+		return nil
+	}
+	if len(transfers) == 0 {
+		fmt.Println("✅ Sin transferencias en curso")
+		return nil
+	}
+	tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "ID\tTIPO\tARCHIVO\tPEER\tAVANCE\tDURACIÓN")
+	for _, t := range transfers {
+		fmt.Fprintf(tw, "%d\t%s\t%s\t%s\t%s\t%s\n", t.ID, t.Direction, t.FileName, t.Peer, peer.DescribeProgress(t),
+			time.Since(t.StartedAt).Round(time.Second))
+	}
+	return tw.Flush()
+}
+
+func printTransfer(ev peer.TransferEvent) {
+	if printJSON(ev) {
+		return
+	}
+	t := ev.Transfer
+	switch ev.Type {
+	case peer.TransferStarted:
+		fmt.Printf("▶️  #%d %s %s (%s)\n", t.ID, t.Direction, t.FileName, t.Peer)
+	case peer.TransferProgress:
+		fmt.Printf("📊 #%d %s: %s\n", t.ID, t.FileName, peer.DescribeProgress(t))
+	case peer.TransferFinished:
+		switch t.State {
+		case peer.TransferDone:
+			fmt.Printf("✅ #%d %s: %s en %s\n", t.ID, t.FileName, bandwidth.FormatBytes(t.Bytes), time.Since(t.StartedAt).Round(time.Second))
+		case peer.TransferCancelled:
+			fmt.Printf("🚫 #%d %s cancelada\n", t.ID, t.FileName)
+		default:
+			fmt.Printf("❌ #%d %s: %s\n", t.ID, t.FileName, t.Error)
+		}
+	}
+}
+
+func cmdRetry(args []string) error {
+	usage := fmt.Errorf("uso: p2pfs retry list [--dead] | show ID | flush | cancel ID | requeue [ID]")
+	if len(args) < 1 {
+		return usage
+	}
+	switch args[0] {
+	case "list":
+		fset := flag.NewFlagSet("retry list", flag.ContinueOnError)
+		dead := fset.Bool("dead", false, "solo los trabajos fallidos definitivamente")
+		if err := fset.Parse(args[1:]); err != nil {
+			return err
+		}
+		q := url.Values{}
+		if *dead {
+			q.Set("status", jobs.Dead)
+		}
+		var list []jobs.Job
+		if err := client.Get("/retry", q, &list); err != nil {
+			return err
+		}
+		if printJSON(list) {
+			return nil
+		}
+		if len(list) == 0 {
+			fmt.Println("✅ Sin trabajos pendientes")
+			return nil
+		}
+		tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(tw, "ID\tTIPO\tARCHIVO\tDESTINO\tESTADO\tINTENTOS\tPRÓXIMO")
+		for _, j := range list {
+			next := "-"
+			if j.Status == jobs.Pending {
+				next = time.Until(j.NextRun).Round(time.Second).String()
+				if j.NextRun.Before(time.Now()) {
+					next = "ya"
+				}
+			}
+			fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%d\t%s\n", j.ID, j.Type, j.FileName, j.To, j.Status, j.Attempts, next)
+		}
+		return tw.Flush()
+
+	case "show":
+		if len(args) != 2 {
+			return usage
+		}
+		var j jobs.Job
+		if err := client.Get("/retry", url.Values{"id": {args[1]}}, &j); err != nil {
+			return err
+		}
+		if printJSON(j) {
+			return nil
+		}
+		fmt.Printf("ID:          %s\n", j.ID)
+		fmt.Printf("Tipo:        %s\n", j.Type)
+		if j.FileName != "" {
+			fmt.Printf("Archivo:     %s\n", j.FileName)
+		}
+		fmt.Printf("Destino:     %s\n", j.To)
+		fmt.Printf("Estado:      %s\n", j.Status)
+		fmt.Printf("Intentos:    %d\n", j.Attempts)
+		fmt.Printf("Encolado:    %s\n", j.Created.Format("2006-01-02 15:04:05"))
+		if j.Status == jobs.Pending {
+			fmt.Printf("Próximo:     %s\n", j.NextRun.Format("2006-01-02 15:04:05"))
+		}
+		if j.LastError != "" {
+			fmt.Printf("Último error: %s\n", j.LastError)
+		}
+		return nil
+
+	case "flush":
+		var res map[string]int
+		if err := client.Post("/retry/flush", control.Args{}, &res); err != nil {
+			return err
+		}
+		if printJSON(res) {
+			return nil
+		}
+		fmt.Printf("🔁 Reintento completado, quedan %d trabajo(s) pendientes\n", res["remaining"])
+		return nil
+
+	case "cancel":
+		if len(args) != 2 {
+			return usage
+		}
+		var j jobs.Job
+		if err := client.Post("/retry/cancel", control.Args{ID: args[1]}, &j); err != nil {
+			return err
+		}
+		if printJSON(j) {
+			return nil
+		}
+		fmt.Printf("🚫 Cancelado: %s %s → %s\n", j.Type, j.FileName, j.To)
+		return nil
+
+	case "requeue":
+		if len(args) > 2 {
+			return usage
+		}
+		var a control.Args
+		if len(args) == 2 {
+			a.ID = args[1]
+		}
+		var res map[string]int
+		if err := client.Post("/retry/requeue", a, &res); err != nil {
+			return err
+		}
+		if printJSON(res) {
+			return nil
+		}
+		fmt.Printf("🔁 %d trabajo(s) reencolados\n", res["requeued"])
+		return nil
+	}
+	return fmt.Errorf("subcomando desconocido: retry %s", args[0])
+}
+
+func cmdLog(args []string) error {
+	if len(args) < 1 || args[0] != "tail" {
+		return fmt.Errorf("uso: p2pfs log tail [-n N] [-f]")
+	}
+	fset := flag.NewFlagSet("log tail", flag.ContinueOnError)
+	n := fset.Int("n", 20, "número de operaciones")
+	follow := fset.Bool("f", false, "seguir mostrando operaciones nuevas")
+	if err := fset.Parse(args[1:]); err != nil {
+		return err
+	}
+
+	var ops []logger.Operation
+	if err := client.Get("/log", url.Values{"n": {strconv.Itoa(*n)}}, &ops); err != nil {
+		return err
+	}
+	for _, op := range ops {
+		printOperation(op)
+	}
+	if !*follow {
+		return nil
+	}
+
+	// Seguir el registro: comparar el total con la consulta anterior
+	var all []logger.Operation
+	if err := client.Get("/log", nil, &all); err != nil {
+		return err
+	}
+	seen := len(all)
+	for {
+		time.Sleep(time.Second)
+		if err := client.Get("/log", nil, &all); err != nil {
+			return err
+		}
+		if len(all) < seen {
+			seen = 0
+		}
+		for _, op := range all[seen:] {
+			printOperation(op)
+		}
+		seen = len(all)
+	}
+}
+
+func printOperation(op logger.Operation) {
+	if printJSON(op) {
+		return
+	}
+	fmt.Printf("%s  %-18s %-24s %s  %s\n",
+		time.Unix(op.Timestamp, 0).Format("2006-01-02 15:04:05"),
+		op.Type, op.FileName, op.From, op.Message)
+}
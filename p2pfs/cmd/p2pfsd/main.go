@@ -0,0 +1,61 @@
+// main.go - Nodo P2PFS sin interfaz gráfica, para servidores y contenedores
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"p2pfs/internal/config"
+	"p2pfs/internal/control"
+	"p2pfs/internal/node"
+)
+
+func main() {
+	opts := config.RegisterFlags(flag.CommandLine)
+	flag.Parse()
+
+	cfg, err := config.Load(opts)
+	if err != nil {
+		fmt.Println("❌", err)
+		os.Exit(2)
+	}
+
+	// Registrar las señales antes de arrancar para no perder un SIGTERM
+	// temprano: la primera detiene el nodo (también a medio arrancar) y,
+	// mientras se esperan las transferencias, otra sale sin esperar
+	ctx, cancel := context.WithCancel(context.Background())
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, syscall.SIGINT, syscall.SIGTERM)
+	go func() {
+		s := <-sig
+		fmt.Printf("🛑 Señal %v recibida, deteniendo...\n", s)
+		cancel()
+		<-sig
+		fmt.Println("⚠️ Segunda señal: saliendo sin esperar")
+		os.Exit(1)
+	}()
+
+	// 🛠 Arrancar red, descubrimiento y tareas de fondo
+	n := node.Start(ctx, cfg)
+
+	var servers []*control.Server
+	if ctx.Err() == nil {
+		// 🎛️ Estado y acciones accesibles para otros procesos locales
+		servers, err = control.Serve(cfg, control.NewAPI(n))
+		if err != nil {
+			fmt.Println("❌ No se pudo abrir la API de control:", err)
+			os.Exit(1)
+		}
+		fmt.Printf("🟢 Nodo %d en ejecución sin GUI (%s)\n", n.Self.GetID(), n.Self.Addr())
+	}
+
+	<-ctx.Done()
+	for _, srv := range servers {
+		srv.Close()
+	}
+	n.Shutdown()
+}
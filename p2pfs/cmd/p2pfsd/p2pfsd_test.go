@@ -0,0 +1,277 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+
+	"p2pfs/internal/control"
+)
+
+// Arnés de varios nodos: cada nodo es un p2pfsd aparte (la configuración de
+// un nodo vive en variables de paquete) con su propio data_dir y puertos de
+// loopback, y se maneja por su socket de control como haría la CLI. Con
+// go test -race los nodos también se compilan con -race y cualquier carrera
+// hace que terminen con error.
+
+// testNode es un nodo lanzado por el arnés
+type testNode struct {
+	name   string
+	dir    string
+	port   string
+	id     int
+	cmd    *exec.Cmd
+	out    *syncBuffer
+	client *control.Client
+	done   chan error
+}
+
+// syncBuffer guarda la salida de un nodo; la escriben stdout y stderr a la vez
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+// buildNode compila p2pfsd en un directorio temporal
+func buildNode(t *testing.T) string {
+	t.Helper()
+	goTool, err := exec.LookPath("go")
+	if err != nil {
+		t.Skip("no se encuentra la herramienta go:", err)
+	}
+	bin := filepath.Join(t.TempDir(), "p2pfsd")
+	args := []string{"build", "-o", bin}
+	if raceEnabled {
+		args = append(args, "-race")
+	}
+	out, err := exec.Command(goTool, append(args, ".")...).CombinedOutput()
+	if err != nil {
+		t.Fatalf("go build: %v\n%s", err, out)
+	}
+	return bin
+}
+
+// freePort devuelve un puerto libre de loopback para network ("tcp" o "udp")
+func freePort(t *testing.T, network string) string {
+	t.Helper()
+	var addr net.Addr
+	if network == "udp" {
+		conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		addr = conn.LocalAddr()
+		conn.Close()
+	} else {
+		ln, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		addr = ln.Addr()
+		ln.Close()
+	}
+	_, port, _ := net.SplitHostPort(addr.String())
+	return port
+}
+
+// startNode lanza un nodo que se une a join (si no está vacío) y espera a
+// que tenga ID
+func startNode(t *testing.T, bin, root, name, join string) *testNode {
+	t.Helper()
+	n := &testNode{
+		name: name,
+		dir:  filepath.Join(root, name),
+		port: freePort(t, "tcp"),
+		out:  &syncBuffer{},
+		done: make(chan error, 1),
+	}
+	if err := os.MkdirAll(filepath.Join(n.dir, "docs"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := fmt.Sprintf(`port: %q
+data_dir: %q
+discovery_port: %q
+control_socket: ctl.sock
+id_timeout: 1s
+announce_interval: 1s
+sync_interval: 1s
+shutdown_timeout: 10s
+shares:
+  - {name: docs, path: docs}
+`, n.port, n.dir, freePort(t, "udp"))
+	if join != "" {
+		cfg += fmt.Sprintf("join: [%q]\n", join)
+	}
+	cfgFile := filepath.Join(n.dir, "p2pfs.yaml")
+	if err := os.WriteFile(cfgFile, []byte(cfg), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	n.cmd = exec.Command(bin, "-config", cfgFile)
+	n.cmd.Stdout = n.out
+	n.cmd.Stderr = n.out
+	if err := n.cmd.Start(); err != nil {
+		t.Fatal(err)
+	}
+	go func() { n.done <- n.cmd.Wait() }()
+	t.Cleanup(func() {
+		if n.cmd.ProcessState == nil {
+			n.cmd.Process.Kill()
+		}
+	})
+
+	// El nodo crea su token al abrir el socket de control
+	var token string
+	n.waitFor(t, "el token de control", func() bool {
+		var err error
+		token, err = control.LoadToken(filepath.Join(n.dir, "state", "control.token"))
+		return err == nil
+	})
+	n.client = control.NewClient(filepath.Join(n.dir, "ctl.sock"), token)
+	n.waitFor(t, "ID asignado", func() bool {
+		var st control.Status
+		if n.client.Get("/status", nil, &st) != nil {
+			return false
+		}
+		n.id = st.ID
+		return n.id != 0
+	})
+	return n
+}
+
+// waitFor espera a que cond se cumpla; si no, falla mostrando la salida del nodo
+func (n *testNode) waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(60 * time.Second)
+	for !cond() {
+		select {
+		case err := <-n.done:
+			t.Fatalf("%s terminó esperando %s: %v\n%s", n.name, what, err, n.out)
+		default:
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("%s: tiempo agotado esperando %s\n%s", n.name, what, n.out)
+		}
+		time.Sleep(200 * time.Millisecond)
+	}
+}
+
+// stop detiene el nodo con SIGTERM y comprueba que sale sin error (con
+// -race, una carrera detectada hace que salga con código 66)
+func (n *testNode) stop(t *testing.T) {
+	t.Helper()
+	n.cmd.Process.Signal(syscall.SIGTERM)
+	select {
+	case err := <-n.done:
+		if err != nil {
+			t.Errorf("%s terminó con error: %v\n%s", n.name, err, n.out)
+		}
+	case <-time.After(30 * time.Second):
+		n.cmd.Process.Kill()
+		t.Errorf("%s no se detuvo a tiempo\n%s", n.name, n.out)
+	}
+}
+
+// onlinePeers cuenta los demás nodos que n ve en línea
+func (n *testNode) onlinePeers() int {
+	var peers []control.PeerStatus
+	if err := n.client.Get("/peers", nil, &peers); err != nil {
+		return 0
+	}
+	online := 0
+	for _, p := range peers {
+		if !p.Self && p.Online {
+			online++
+		}
+	}
+	return online
+}
+
+// hasFile indica si n tiene docs/name con el contenido want
+func (n *testNode) hasFile(name string, want []byte) bool {
+	got, err := os.ReadFile(filepath.Join(n.dir, "docs", name))
+	return err == nil && bytes.Equal(got, want)
+}
+
+// Tres nodos en loopback: se unen a través del primero, un archivo llega
+// por sincronización y otro por envío directo, y todos se detienen limpios
+func TestNodesSync(t *testing.T) {
+	if testing.Short() {
+		t.Skip("arranca varios nodos")
+	}
+	bin := buildNode(t)
+	root := t.TempDir()
+
+	n1 := startNode(t, bin, root, "n1", "")
+	seed := net.JoinHostPort("127.0.0.1", n1.port)
+	n2 := startNode(t, bin, root, "n2", seed)
+	n3 := startNode(t, bin, root, "n3", seed)
+	nodes := []*testNode{n1, n2, n3}
+
+	for _, n := range nodes {
+		n.waitFor(t, "los otros dos nodos", func() bool { return n.onlinePeers() >= 2 })
+	}
+
+	// Un archivo nuevo en n1 llega a n2 al pedirle que sincronice
+	synced := []byte("sincronizado desde n1\n")
+	if err := os.WriteFile(filepath.Join(n1.dir, "docs", "sync.txt"), synced, 0644); err != nil {
+		t.Fatal(err)
+	}
+	n2.waitFor(t, "docs/sync.txt", func() bool {
+		if n2.hasFile("sync.txt", synced) {
+			return true
+		}
+		n2.client.Post("/sync", control.Args{Node: strconv.Itoa(n1.id)}, nil)
+		return false
+	})
+
+	// Un envío explícito de n1 a n3; la API solo acepta peers registrados
+	sent := []byte("enviado a n3\n")
+	if err := os.WriteFile(filepath.Join(n1.dir, "docs", "put.txt"), sent, 0644); err != nil {
+		t.Fatal(err)
+	}
+	var results []control.TransferResult
+	err := n1.client.Post("/put", control.Args{
+		Path:  "docs/put.txt",
+		Nodes: []string{strconv.Itoa(n3.id)},
+	}, &results)
+	if err != nil {
+		t.Fatal("put:", err)
+	}
+	for _, res := range results {
+		if !res.OK {
+			t.Fatalf("put a %s: %s", res.Node, res.Error)
+		}
+	}
+	n3.waitFor(t, "docs/put.txt", func() bool { return n3.hasFile("put.txt", sent) })
+
+	for _, n := range nodes {
+		n.stop(t)
+	}
+	for _, n := range nodes {
+		if _, err := os.Stat(filepath.Join(n.dir, "state", "state.json")); err != nil {
+			t.Errorf("%s no guardó su estado al detenerse: %v", n.name, err)
+		}
+	}
+}
@@ -0,0 +1,7 @@
+//go:build race
+
+package main
+
+// raceEnabled indica si los tests se compilaron con -race; los nodos del
+// arnés se compilan igual
+const raceEnabled = true
@@ -12,5 +12,15 @@ GOOS=windows GOARCH=amd64 go build -o build/$APP_NAME.exe cmd/main.go
 echo "📦 Compilando para macOS AMD64..."
 GOOS=darwin GOARCH=amd64 go build -o build/$APP_NAME-macos cmd/main.go
 
+echo "📦 Compilando daemon sin GUI (p2pfsd)..."
+GOOS=linux GOARCH=amd64 go build -o build/${APP_NAME}d-linux ./cmd/p2pfsd
+GOOS=windows GOARCH=amd64 go build -o build/${APP_NAME}d.exe ./cmd/p2pfsd
+GOOS=darwin GOARCH=amd64 go build -o build/${APP_NAME}d-macos ./cmd/p2pfsd
+
+echo "📦 Compilando cliente de línea de comandos (p2pfs-cli)..."
+GOOS=linux GOARCH=amd64 go build -o build/$APP_NAME-cli-linux ./cmd/p2pfs
+GOOS=windows GOARCH=amd64 go build -o build/$APP_NAME-cli.exe ./cmd/p2pfs
+GOOS=darwin GOARCH=amd64 go build -o build/$APP_NAME-cli-macos ./cmd/p2pfs
+
 echo "✅ Compilación completada. Binarios disponibles en ./build/"
 
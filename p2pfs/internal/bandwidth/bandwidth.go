@@ -0,0 +1,308 @@
+// Package bandwidth limita la velocidad de subida y bajada de las
+// transferencias, en total y por peer. Los límites globales pueden cambiar
+// según la hora con reglas (p. ej. menos ancho de banda en horario
+// laboral). Un límite 0 significa sin límite.
+package bandwidth
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// chunk es el máximo que se lee o escribe entre esperas, para que el
+// límite se note de forma regular y no a golpes
+const chunk = 32 << 10
+
+// Rule fija los límites globales en una franja horaria. From y To son
+// minutos desde medianoche; si To es menor que From la franja cruza la
+// medianoche. Sin Days se aplica todos los días.
+type Rule struct {
+	Days     []time.Weekday
+	From, To int
+	Upload   int64
+	Download int64
+}
+
+// active indica si la regla se aplica en t
+func (r Rule) active(t time.Time) bool {
+	if len(r.Days) > 0 {
+		found := false
+		for _, d := range r.Days {
+			found = found || d == t.Weekday()
+		}
+		if !found {
+			return false
+		}
+	}
+	m := t.Hour()*60 + t.Minute()
+	if r.From <= r.To {
+		return m >= r.From && m < r.To
+	}
+	return m >= r.From || m < r.To
+}
+
+// Config son los límites en bytes por segundo
+type Config struct {
+	Upload       int64
+	Download     int64
+	PeerUpload   int64
+	PeerDownload int64
+	// Rules sustituyen a Upload y Download mientras están activas; gana la primera
+	Rules []Rule
+}
+
+var (
+	mu      sync.RWMutex
+	current Config
+
+	upload   = newLimiter(func() int64 { up, _ := Rates(time.Now()); return up })
+	download = newLimiter(func() int64 { _, down := Rates(time.Now()); return down })
+
+	peersMu       sync.Mutex
+	peerUploads   = make(map[string]*limiter)
+	peerDownloads = make(map[string]*limiter)
+)
+
+// Configure fija los límites
+func Configure(c Config) {
+	mu.Lock()
+	current = c
+	mu.Unlock()
+}
+
+// Rates devuelve los límites globales de subida y bajada vigentes en t
+func Rates(t time.Time) (up, down int64) {
+	mu.RLock()
+	defer mu.RUnlock()
+	for _, r := range current.Rules {
+		if r.active(t) {
+			return r.Upload, r.Download
+		}
+	}
+	return current.Upload, current.Download
+}
+
+func peerRates() (up, down int64) {
+	mu.RLock()
+	defer mu.RUnlock()
+	return current.PeerUpload, current.PeerDownload
+}
+
+// peerLimiters devuelve los limitadores de subida y bajada del peer host
+func peerLimiters(host string) (*limiter, *limiter) {
+	peersMu.Lock()
+	defer peersMu.Unlock()
+	up, ok := peerUploads[host]
+	if !ok {
+		up = newLimiter(func() int64 { r, _ := peerRates(); return r })
+		peerUploads[host] = up
+	}
+	down, ok := peerDownloads[host]
+	if !ok {
+		down = newLimiter(func() int64 { _, r := peerRates(); return r })
+		peerDownloads[host] = down
+	}
+	return up, down
+}
+
+// limiter es un cubo de fichas con capacidad para un segundo de tráfico
+type limiter struct {
+	mu     sync.Mutex
+	rate   func() int64
+	tokens float64
+	last   time.Time
+}
+
+func newLimiter(rate func() int64) *limiter {
+	return &limiter{rate: rate}
+}
+
+// wait reserva n bytes y espera lo necesario para no superar el límite;
+// deja de esperar si se cancela ctx
+func (l *limiter) wait(ctx context.Context, n int) error {
+	l.mu.Lock()
+	rate := l.rate()
+	if rate <= 0 {
+		l.last = time.Time{}
+		l.mu.Unlock()
+		return ctx.Err()
+	}
+	now := time.Now()
+	burst := float64(rate)
+	if l.last.IsZero() {
+		l.tokens = burst
+	} else {
+		l.tokens += now.Sub(l.last).Seconds() * float64(rate)
+		if l.tokens > burst {
+			l.tokens = burst
+		}
+	}
+	l.last = now
+	l.tokens -= float64(n)
+	deficit := -l.tokens
+	l.mu.Unlock()
+
+	if deficit <= 0 {
+		return ctx.Err()
+	}
+	t := time.NewTimer(time.Duration(deficit / float64(rate) * float64(time.Second)))
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+type writer struct {
+	ctx   context.Context
+	w     io.Writer
+	limit []*limiter
+}
+
+func (w *writer) Write(p []byte) (int, error) {
+	written := 0
+	for len(p) > 0 {
+		n := len(p)
+		if n > chunk {
+			n = chunk
+		}
+		for _, l := range w.limit {
+			if err := l.wait(w.ctx, n); err != nil {
+				return written, err
+			}
+		}
+		m, err := w.w.Write(p[:n])
+		written += m
+		if err != nil {
+			return written, err
+		}
+		p = p[n:]
+	}
+	return written, nil
+}
+
+type reader struct {
+	ctx   context.Context
+	r     io.Reader
+	limit []*limiter
+}
+
+func (r *reader) Read(p []byte) (int, error) {
+	if len(p) > chunk {
+		p = p[:chunk]
+	}
+	n, err := r.r.Read(p)
+	if n > 0 {
+		for _, l := range r.limit {
+			if werr := l.wait(r.ctx, n); werr != nil {
+				return n, werr
+			}
+		}
+	}
+	return n, err
+}
+
+// Writer limita lo que se escribe en w hacia el peer host; al cancelar ctx
+// la escritura deja de esperar y falla
+func Writer(ctx context.Context, w io.Writer, host string) io.Writer {
+	up, _ := peerLimiters(host)
+	return &writer{ctx: ctx, w: w, limit: []*limiter{upload, up}}
+}
+
+// Reader limita lo que se lee de r desde el peer host; al cancelar ctx la
+// lectura deja de esperar y falla
+func Reader(ctx context.Context, r io.Reader, host string) io.Reader {
+	_, down := peerLimiters(host)
+	return &reader{ctx: ctx, r: r, limit: []*limiter{download, down}}
+}
+
+// ParseRate interpreta un límite como "512KiB", "2MB", "1.5M" o "100000"
+// (bytes por segundo); vacío o "0" es sin límite
+func ParseRate(s string) (int64, error) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return 0, nil
+	}
+	units := []struct {
+		suffix string
+		factor float64
+	}{
+		{"kib", 1 << 10}, {"mib", 1 << 20}, {"gib", 1 << 30},
+		{"kb", 1e3}, {"mb", 1e6}, {"gb", 1e9},
+		{"k", 1 << 10}, {"m", 1 << 20}, {"g", 1 << 30},
+		{"b", 1},
+	}
+	num, factor := strings.ToLower(s), 1.0
+	for _, u := range units {
+		if strings.HasSuffix(num, u.suffix) {
+			num, factor = strings.TrimSpace(strings.TrimSuffix(num, u.suffix)), u.factor
+			break
+		}
+	}
+	v, err := strconv.ParseFloat(num, 64)
+	if err != nil || v < 0 {
+		return 0, fmt.Errorf("límite no válido %q", s)
+	}
+	return int64(v * factor), nil
+}
+
+// ParseClock interpreta una hora "HH:MM" como minutos desde medianoche
+func ParseClock(s string) (int, error) {
+	t, err := time.Parse("15:04", strings.TrimSpace(s))
+	if err != nil {
+		return 0, fmt.Errorf("hora no válida %q", s)
+	}
+	return t.Hour()*60 + t.Minute(), nil
+}
+
+// ParseDay interpreta un día de la semana en inglés o español (abreviado o no)
+func ParseDay(s string) (time.Weekday, error) {
+	days := map[string]time.Weekday{
+		"sun": time.Sunday, "mon": time.Monday, "tue": time.Tuesday, "wed": time.Wednesday,
+		"thu": time.Thursday, "fri": time.Friday, "sat": time.Saturday,
+		"dom": time.Sunday, "lun": time.Monday, "mar": time.Tuesday, "mie": time.Wednesday,
+		"mié": time.Wednesday, "jue": time.Thursday, "vie": time.Friday, "sab": time.Saturday,
+		"sáb": time.Saturday,
+	}
+	key := strings.ToLower(strings.TrimSpace(s))
+	if len([]rune(key)) > 3 {
+		key = string([]rune(key)[:3])
+	}
+	if d, ok := days[key]; ok {
+		return d, nil
+	}
+	return 0, fmt.Errorf("día no válido %q", s)
+}
+
+// FormatBytes muestra una cantidad de bytes en unidades legibles
+func FormatBytes(n int64) string {
+	switch {
+	case n >= 1<<30:
+		return fmt.Sprintf("%.1f GiB", float64(n)/(1<<30))
+	case n >= 1<<20:
+		return fmt.Sprintf("%.1f MiB", float64(n)/(1<<20))
+	case n >= 1<<10:
+		return fmt.Sprintf("%.1f KiB", float64(n)/(1<<10))
+	}
+	return fmt.Sprintf("%d B", n)
+}
+
+// FormatRate muestra un límite en unidades legibles
+func FormatRate(rate int64) string {
+	switch {
+	case rate <= 0:
+		return "sin límite"
+	case rate >= 1<<20:
+		return fmt.Sprintf("%.1f MiB/s", float64(rate)/(1<<20))
+	case rate >= 1<<10:
+		return fmt.Sprintf("%.1f KiB/s", float64(rate)/(1<<10))
+	}
+	return fmt.Sprintf("%d B/s", rate)
+}
@@ -0,0 +1,158 @@
+// Package compress comprime el contenido de las transferencias entre nodos.
+// Cada nodo anuncia en sus mensajes los algoritmos que sabe descomprimir
+// (Accept) y el emisor elige el primero de los suyos que el otro acepte;
+// con un nodo que no anuncia nada se envía sin comprimir. Los archivos
+// pequeños, los formatos ya comprimidos y los que apenas se reducen se
+// envían tal cual.
+package compress
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"path/filepath"
+	"strings"
+)
+
+// Algoritmos soportados; None es el contenido sin comprimir
+const (
+	None    = ""
+	Gzip    = "gzip"
+	Deflate = "deflate"
+)
+
+// Supported son los algoritmos que este nodo sabe descomprimir, por orden
+// de preferencia
+var Supported = []string{Gzip, Deflate}
+
+// Enabled son los algoritmos que este nodo usa al enviar; vacío desactiva
+// la compresión
+var Enabled = Supported
+
+// MinSize es el tamaño por debajo del cual no compensa comprimir
+const MinSize = 1024
+
+// sampleSize es lo que se prueba a comprimir para decidir si compensa
+const sampleSize = 64 << 10
+
+// minSaving es la reducción mínima de la muestra para comprimir el archivo
+const minSaving = 0.1
+
+// MaxSize es lo máximo que se descomprime de un mensaje, declare lo que
+// declare el remitente; 0 es sin límite
+var MaxSize int64 = 1 << 30
+
+// compressed son extensiones de formatos que ya van comprimidos
+var compressed = map[string]bool{
+	".zip": true, ".gz": true, ".tgz": true, ".bz2": true, ".xz": true, ".zst": true,
+	".7z": true, ".rar": true, ".lz4": true, ".br": true,
+	".jpg": true, ".jpeg": true, ".png": true, ".gif": true, ".webp": true, ".heic": true, ".avif": true,
+	".mp3": true, ".m4a": true, ".aac": true, ".ogg": true, ".opus": true, ".flac": true,
+	".mp4": true, ".m4v": true, ".mkv": true, ".mov": true, ".avi": true, ".webm": true,
+	".pdf": true, ".docx": true, ".xlsx": true, ".pptx": true, ".odt": true, ".ods": true,
+	".epub": true, ".jar": true, ".apk": true, ".deb": true, ".rpm": true,
+}
+
+// Configure fija los algoritmos de envío según la opción compression:
+// auto (todos), gzip, deflate u off
+func Configure(mode string) error {
+	switch mode {
+	case "", "auto":
+		Enabled = Supported
+	case "off":
+		Enabled = nil
+	case Gzip, Deflate:
+		Enabled = []string{mode}
+	default:
+		return fmt.Errorf("compresión desconocida %q", mode)
+	}
+	return nil
+}
+
+// Negotiate elige el algoritmo con el que enviar a un nodo que acepta
+// accept; None si no hay ninguno en común
+func Negotiate(accept []string) string {
+	for _, e := range Enabled {
+		for _, a := range accept {
+			if a == e {
+				return e
+			}
+		}
+	}
+	return None
+}
+
+// Worth indica si compensa comprimir el archivo name con contenido data
+func Worth(name string, data []byte) bool {
+	if len(data) < MinSize || compressed[strings.ToLower(filepath.Ext(name))] {
+		return false
+	}
+	sample := data
+	if len(sample) > sampleSize {
+		sample = sample[:sampleSize]
+	}
+	var buf bytes.Buffer
+	w, _ := flate.NewWriter(&buf, flate.BestSpeed)
+	w.Write(sample)
+	w.Close()
+	return float64(buf.Len()) <= float64(len(sample))*(1-minSaving)
+}
+
+// Encode comprime data con el algoritmo enc
+func Encode(enc string, data []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	var w io.WriteCloser
+	switch enc {
+	case None:
+		return data, nil
+	case Gzip:
+		w = gzip.NewWriter(&buf)
+	case Deflate:
+		w, _ = flate.NewWriter(&buf, flate.DefaultCompression)
+	default:
+		return nil, fmt.Errorf("compresión desconocida %q", enc)
+	}
+	if _, err := w.Write(data); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// Decode descomprime data, comprimido con el algoritmo enc. Falla si el
+// resultado pasaría de limit bytes (0 es sin límite), para que un mensaje
+// pequeño no pueda agotar la memoria.
+func Decode(enc string, data []byte, limit int64) ([]byte, error) {
+	var r io.ReadCloser
+	switch enc {
+	case None:
+		return data, nil
+	case Gzip:
+		gz, err := gzip.NewReader(bytes.NewReader(data))
+		if err != nil {
+			return nil, fmt.Errorf("gzip: %v", err)
+		}
+		r = gz
+	case Deflate:
+		r = flate.NewReader(bytes.NewReader(data))
+	default:
+		return nil, fmt.Errorf("compresión desconocida %q", enc)
+	}
+	defer r.Close()
+	var src io.Reader = r
+	if limit > 0 {
+		src = io.LimitReader(r, limit+1)
+	}
+	out, err := io.ReadAll(src)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", enc, err)
+	}
+	if limit > 0 && int64(len(out)) > limit {
+		return nil, fmt.Errorf("%s: el contenido descomprimido supera %d bytes", enc, limit)
+	}
+	return out, nil
+}
@@ -0,0 +1,550 @@
+// Package config reúne la configuración de un nodo: valores por defecto,
+// archivo YAML, variables de entorno y banderas, en ese orden de prioridad.
+package config
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+
+	"p2pfs/internal/bandwidth"
+	"p2pfs/internal/ignore"
+	"p2pfs/internal/placement"
+)
+
+// DefaultFile se carga si existe y no se indicó otro con --config o P2PFS_CONFIG
+const DefaultFile = "config/p2pfs.yaml"
+
+// Config es la configuración completa de un nodo. Cada campo se puede fijar
+// en el archivo (clave yaml), con la variable P2PFS_<CLAVE> o con la bandera
+// indicada; la etiqueta env lista nombres antiguos que se siguen aceptando.
+type Config struct {
+	Port    string `yaml:"port" json:"port" flag:"port" usage:"puerto TCP del nodo"`
+	DataDir string `yaml:"data_dir" json:"data_dir" flag:"data-dir" usage:"directorio base para las rutas relativas (carpeta compartida, estado, logs)"`
+
+	SharedDir     string `yaml:"shared_dir" json:"shared_dir" flag:"shared" usage:"carpeta compartida"`
+	OplogFile     string `yaml:"oplog_file" json:"oplog_file" flag:"oplog" usage:"registro de operaciones"`
+	StateFile     string `yaml:"state_file" json:"state_file" flag:"state" usage:"archivo de estado persistente"`
+	JobsFile      string `yaml:"jobs_file" json:"jobs_file" flag:"jobs" usage:"cola persistente de reintentos"`
+	PeersFile     string `yaml:"peers_file" json:"peers_file" flag:"peers" env:"PEERS_FILE" usage:"archivo de peers conocidos"`
+	SeedsFile     string `yaml:"seeds_file" json:"seeds_file" flag:"seeds" env:"SEEDS_FILE" usage:"archivo JSON con la lista de nodos semilla"`
+	ControlSocket string `yaml:"control_socket" json:"control_socket" flag:"control" usage:"socket Unix de control local (vacío para desactivarlo)"`
+	ControlHTTP   string `yaml:"control_http" json:"control_http,omitempty" flag:"control-http" usage:"dirección de loopback host:puerto para la API HTTP (desactivada por defecto)"`
+	ControlToken  string `yaml:"control_token" json:"control_token" flag:"control-token" usage:"archivo con el token que exige la API de control (se crea si no existe)"`
+
+	Joins              []string `yaml:"join" json:"join,omitempty" flag:"join" usage:"nodo semilla host:puerto al que unirse (se puede repetir)"`
+	Discovery          string   `yaml:"discovery" json:"discovery" flag:"discovery" env:"DISCOVERY" usage:"descubrimiento: broadcast, multicast, multicast6, mdns, mdns6 (separados por comas)"`
+	DiscoveryInterface string   `yaml:"discovery_interface" json:"discovery_interface,omitempty" flag:"discovery-iface" env:"DISCOVERY_IFACE" usage:"interfaz de red para multicast/mDNS"`
+	DiscoveryPort      string   `yaml:"discovery_port" json:"discovery_port" flag:"discovery-port" env:"DISCOVERY_PORT" usage:"puerto UDP de los anuncios"`
+
+	AnnounceInterval time.Duration `yaml:"announce_interval" json:"announce_interval" flag:"announce-interval" usage:"intervalo entre HELLO mientras no hay ID"`
+	IDTimeout        time.Duration `yaml:"id_timeout" json:"id_timeout" flag:"id-timeout" usage:"espera por un ID antes de asumir ID=1"`
+	SyncInterval     time.Duration `yaml:"sync_interval" json:"sync_interval" flag:"sync-interval" usage:"intervalo de verificación de peers y sincronización"`
+	RetryInterval    time.Duration `yaml:"retry_interval" json:"retry_interval" flag:"retry-interval" usage:"intervalo entre comprobaciones de la cola de reintentos"`
+	PersistInterval  time.Duration `yaml:"persist_interval" json:"persist_interval" flag:"persist-interval" usage:"intervalo de guardado de la lista de peers"`
+	SeedRefresh      time.Duration `yaml:"seed_refresh" json:"seed_refresh" flag:"seed-refresh" env:"SEED_REFRESH" usage:"intervalo para volver a contactar a las semillas"`
+	PeerExpiry       time.Duration `yaml:"peer_expiry" json:"peer_expiry" flag:"peer-expiry" env:"PEER_EXPIRY" usage:"tiempo sin respuesta tras el que se olvida un peer"`
+	RepairInterval   time.Duration `yaml:"repair_interval" json:"repair_interval" flag:"repair-interval" usage:"intervalo entre comprobaciones del factor de replicación"`
+	RepairAfter      time.Duration `yaml:"repair_after" json:"repair_after" flag:"repair-after" usage:"tiempo sin respuesta tras el que se reponen las copias de un nodo"`
+
+	MaxRetries      int           `yaml:"max_retries" json:"max_retries" flag:"max-retries" usage:"intentos por envío antes de encolarlo"`
+	RetryBackoff    time.Duration `yaml:"retry_backoff" json:"retry_backoff" flag:"retry-backoff" usage:"espera antes del primer reintento de un trabajo encolado; se duplica en cada fallo"`
+	RetryMaxWait    time.Duration `yaml:"retry_max_wait" json:"retry_max_wait" flag:"retry-max-wait" usage:"espera máxima entre reintentos de un trabajo encolado"`
+	RetryAttempts   int           `yaml:"retry_attempts" json:"retry_attempts" flag:"retry-attempts" usage:"reintentos de un trabajo encolado antes de darlo por fallido"`
+	DialTimeout     time.Duration `yaml:"dial_timeout" json:"dial_timeout" flag:"dial-timeout" usage:"tiempo máximo para conectar con un peer"`
+	RequestTimeout  time.Duration `yaml:"request_timeout" json:"request_timeout" flag:"request-timeout" env:"REQUEST_TIMEOUT" usage:"tiempo máximo de espera de una respuesta"`
+	ShutdownTimeout time.Duration `yaml:"shutdown_timeout" json:"shutdown_timeout" flag:"shutdown-timeout" usage:"espera máxima a que terminen las transferencias al detener el nodo"`
+	Compression     string        `yaml:"compression" json:"compression" flag:"compression" env:"COMPRESSION" usage:"compresión de las transferencias: auto, gzip, deflate u off"`
+	MaxDecompressed string        `yaml:"max_decompressed" json:"max_decompressed" flag:"max-decompressed" usage:"tamaño máximo que se acepta descomprimir de un mensaje, p. ej. 1GiB (0 = sin límite)"`
+
+	UploadLimit       string `yaml:"upload_limit" json:"upload_limit,omitempty" flag:"upload-limit" env:"UPLOAD_LIMIT" usage:"límite de subida total por segundo, p. ej. 512KiB o 2MB (vacío = sin límite)"`
+	DownloadLimit     string `yaml:"download_limit" json:"download_limit,omitempty" flag:"download-limit" env:"DOWNLOAD_LIMIT" usage:"límite de bajada total por segundo (vacío = sin límite)"`
+	PeerUploadLimit   string `yaml:"peer_upload_limit" json:"peer_upload_limit,omitempty" flag:"peer-upload-limit" usage:"límite de subida por segundo hacia cada peer (vacío = sin límite)"`
+	PeerDownloadLimit string `yaml:"peer_download_limit" json:"peer_download_limit,omitempty" flag:"peer-download-limit" usage:"límite de bajada por segundo desde cada peer (vacío = sin límite)"`
+	MaxTransfers      int    `yaml:"max_transfers" json:"max_transfers" flag:"max-transfers" usage:"transferencias simultáneas como máximo"`
+	MaxPeerTransfers  int    `yaml:"max_peer_transfers" json:"max_peer_transfers" flag:"max-peer-transfers" usage:"transferencias simultáneas como máximo con un mismo peer"`
+
+	// BandwidthRules cambian los límites totales en franjas horarias; solo
+	// se configuran en el archivo
+	BandwidthRules []BandwidthRule `yaml:"bandwidth_rules" json:"bandwidth_rules,omitempty"`
+
+	// Shares son las carpetas compartidas con nombre; solo se configuran en
+	// el archivo. Si no hay ninguna se comparte shared_dir como "shared".
+	Shares []Share `yaml:"shares" json:"shares"`
+
+	// File es el archivo del que se leyó la configuración, si hubo alguno
+	File string `yaml:"-" json:"file,omitempty"`
+}
+
+// Share es una carpeta compartida con nombre
+type Share struct {
+	Name string `yaml:"name" json:"name"`
+	Path string `yaml:"path" json:"path"`
+	// Peers limita la carpeta a estos nodos (ID, host o host:puerto); vacío = todos
+	Peers []string `yaml:"peers,omitempty" json:"peers,omitempty"`
+	// Mode: send-receive (por defecto), send-only, receive-only o archive
+	Mode string `yaml:"mode,omitempty" json:"mode,omitempty"`
+	// Ignore son patrones con sintaxis de .gitignore que se suman a los
+	// archivos .p2pfsignore de la carpeta
+	Ignore []string `yaml:"ignore,omitempty" json:"ignore,omitempty"`
+	// Only limita este nodo a esas subcarpetas (rutas relativas); vacío = todo
+	Only []string `yaml:"only,omitempty" json:"only,omitempty"`
+	// Versions es la política de versiones; sin ella se usa DefaultVersions
+	Versions *Versions `yaml:"versions,omitempty" json:"versions,omitempty"`
+	// TrashDays es cuántos días se conserva en la papelera lo que borran
+	// otros nodos; sin él se usa DefaultTrashDays y 0 la desactiva
+	TrashDays *int `yaml:"trash_days,omitempty" json:"trash_days,omitempty"`
+	// Symlinks replica los enlaces simbólicos como enlaces (solo los que
+	// apuntan dentro de la carpeta); sin él se sigue el enlace y se copia
+	// el contenido
+	Symlinks bool `yaml:"symlinks,omitempty" json:"symlinks,omitempty"`
+	// EmptyDirs crea también las carpetas vacías de los demás nodos
+	EmptyDirs bool `yaml:"empty_dirs,omitempty" json:"empty_dirs,omitempty"`
+	// Replicas es en cuántos nodos debe estar cada archivo; cada nodo solo
+	// descarga los que le asigna Placement y un reparador repone las copias
+	// de los nodos perdidos. 0 = todos los nodos guardan todo.
+	Replicas int `yaml:"replicas,omitempty" json:"replicas,omitempty"`
+	// Placement elige los nodos de cada archivo: hash (por defecto) o space
+	Placement string `yaml:"placement,omitempty" json:"placement,omitempty"`
+}
+
+// BandwidthRule sustituye upload_limit y download_limit entre From y To
+// (HH:MM; si To es anterior a From la franja cruza la medianoche) los días
+// indicados, o todos si no se indica ninguno. Gana la primera regla activa.
+type BandwidthRule struct {
+	Days     []string `yaml:"days,omitempty" json:"days,omitempty"`
+	From     string   `yaml:"from" json:"from"`
+	To       string   `yaml:"to" json:"to"`
+	Upload   string   `yaml:"upload,omitempty" json:"upload,omitempty"`
+	Download string   `yaml:"download,omitempty" json:"download,omitempty"`
+}
+
+// Bandwidth traduce los límites de ancho de banda; la configuración debe
+// estar validada
+func (c *Config) Bandwidth() bandwidth.Config {
+	bc := bandwidth.Config{}
+	bc.Upload, _ = bandwidth.ParseRate(c.UploadLimit)
+	bc.Download, _ = bandwidth.ParseRate(c.DownloadLimit)
+	bc.PeerUpload, _ = bandwidth.ParseRate(c.PeerUploadLimit)
+	bc.PeerDownload, _ = bandwidth.ParseRate(c.PeerDownloadLimit)
+	for _, r := range c.BandwidthRules {
+		rule := bandwidth.Rule{}
+		rule.From, _ = bandwidth.ParseClock(r.From)
+		rule.To, _ = bandwidth.ParseClock(r.To)
+		rule.Upload, _ = bandwidth.ParseRate(r.Upload)
+		rule.Download, _ = bandwidth.ParseRate(r.Download)
+		for _, d := range r.Days {
+			if day, err := bandwidth.ParseDay(d); err == nil {
+				rule.Days = append(rule.Days, day)
+			}
+		}
+		bc.Rules = append(bc.Rules, rule)
+	}
+	return bc
+}
+
+// Versions decide cuántas copias anteriores de cada archivo se conservan
+// en la carpeta .versions al sobrescribirlo o borrarlo
+type Versions struct {
+	// Type: simple (por defecto), staggered (escalonada) o none
+	Type string `yaml:"type,omitempty" json:"type,omitempty"`
+	// Keep es el máximo de versiones por archivo; 0 = sin límite
+	Keep int `yaml:"keep,omitempty" json:"keep,omitempty"`
+	// KeepDays es cuántos días se conserva una versión; 0 = sin límite
+	KeepDays int `yaml:"keep_days,omitempty" json:"keep_days,omitempty"`
+}
+
+// DefaultVersions es la política de las carpetas que no indican otra
+var DefaultVersions = Versions{Type: "simple", Keep: 5, KeepDays: 30}
+
+// DefaultTrashDays son los días de papelera de las carpetas que no indican otros
+const DefaultTrashDays = 30
+
+// DefaultShare es el nombre de la carpeta compartida cuando no se configura ninguna
+const DefaultShare = "shared"
+
+// Default devuelve la configuración por defecto
+func Default() *Config {
+	return &Config{
+		Port:          "8001",
+		DataDir:       ".",
+		SharedDir:     "shared",
+		OplogFile:     "log/oplog.json",
+		StateFile:     "state/state.json",
+		JobsFile:      "state/jobs.json",
+		PeersFile:     "config/peers.json",
+		SeedsFile:     "config/seeds.json",
+		ControlSocket: "state/p2pfs.sock",
+		ControlToken:  "state/control.token",
+
+		Discovery:     "broadcast",
+		DiscoveryPort: "48999",
+
+		AnnounceInterval: 5 * time.Second,
+		IDTimeout:        5 * time.Second,
+		SyncInterval:     5 * time.Second,
+		RetryInterval:    10 * time.Second,
+		PersistInterval:  10 * time.Second,
+		SeedRefresh:      time.Minute,
+		PeerExpiry:       72 * time.Hour,
+		RepairInterval:   10 * time.Minute,
+		RepairAfter:      time.Hour,
+
+		MaxRetries:      3,
+		RetryBackoff:    10 * time.Second,
+		RetryMaxWait:    time.Hour,
+		RetryAttempts:   10,
+		DialTimeout:     5 * time.Second,
+		RequestTimeout:  30 * time.Second,
+		ShutdownTimeout: 30 * time.Second,
+		Compression:     "auto",
+		MaxDecompressed: "1GiB",
+
+		MaxTransfers:     4,
+		MaxPeerTransfers: 2,
+	}
+}
+
+// field es un campo configurable con sus etiquetas
+type field struct {
+	key, flag, env, usage string
+	value                 reflect.Value
+}
+
+func (c *Config) fields() []field {
+	v := reflect.ValueOf(c).Elem()
+	t := v.Type()
+	var list []field
+	for i := 0; i < t.NumField(); i++ {
+		sf := t.Field(i)
+		key := sf.Tag.Get("yaml")
+		// Sin bandera, el campo solo se puede fijar en el archivo
+		if key == "" || key == "-" || sf.Tag.Get("flag") == "" {
+			continue
+		}
+		list = append(list, field{
+			key:   key,
+			flag:  sf.Tag.Get("flag"),
+			env:   sf.Tag.Get("env"),
+			usage: sf.Tag.Get("usage"),
+			value: v.Field(i),
+		})
+	}
+	return list
+}
+
+// set asigna un valor en texto a un campo; las listas se separan por comas
+func set(v reflect.Value, s string) error {
+	switch v.Interface().(type) {
+	case string:
+		v.SetString(s)
+	case int:
+		n, err := strconv.Atoi(s)
+		if err != nil {
+			return err
+		}
+		v.SetInt(int64(n))
+	case time.Duration:
+		d, err := time.ParseDuration(s)
+		if err != nil {
+			return err
+		}
+		v.SetInt(int64(d))
+	case []string:
+		var list []string
+		for _, item := range strings.Split(s, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				list = append(list, item)
+			}
+		}
+		v.Set(reflect.ValueOf(list))
+	default:
+		return fmt.Errorf("tipo no soportado %s", v.Type())
+	}
+	return nil
+}
+
+// Options guarda las banderas de un FlagSet hasta que se llama a Load
+type Options struct {
+	config string
+	values map[string]string
+	joins  []string
+}
+
+// RegisterFlags registra --config y una bandera por cada campo de Config
+func RegisterFlags(fs *flag.FlagSet) *Options {
+	opts := &Options{values: make(map[string]string)}
+	fs.StringVar(&opts.config, "config", "", "archivo de configuración YAML (por defecto "+DefaultFile+" si existe)")
+
+	def := Default()
+	for _, f := range def.fields() {
+		f := f
+		if f.flag == "join" {
+			fs.Func(f.flag, f.usage, func(s string) error {
+				opts.joins = append(opts.joins, s)
+				return nil
+			})
+			continue
+		}
+		usage := f.usage
+		if s := fmt.Sprint(f.value.Interface()); s != "" {
+			usage += " (por defecto " + s + ")"
+		}
+		fs.Func(f.flag, usage, func(s string) error {
+			// Validar el tipo ya al leer la bandera
+			if err := set(reflect.New(f.value.Type()).Elem(), s); err != nil {
+				return err
+			}
+			opts.values[f.key] = s
+			return nil
+		})
+	}
+	return opts
+}
+
+// Load arma la configuración: valores por defecto, archivo, entorno y
+// banderas ya analizadas en opts (puede ser nil). Las rutas relativas quedan
+// resueltas respecto a DataDir y el resultado validado.
+func Load(opts *Options) (*Config, error) {
+	if opts == nil {
+		opts = &Options{}
+	}
+	cfg := Default()
+
+	// 📄 Archivo
+	file := opts.config
+	if file == "" {
+		file = os.Getenv("P2PFS_CONFIG")
+	}
+	if file == "" {
+		if _, err := os.Stat(DefaultFile); err == nil {
+			file = DefaultFile
+		}
+	}
+	if file != "" {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return nil, fmt.Errorf("configuración: %v", err)
+		}
+		dec := yaml.NewDecoder(bytes.NewReader(data))
+		dec.KnownFields(true)
+		// Un archivo vacío (io.EOF) deja los valores por defecto
+		if err := dec.Decode(cfg); err != nil && !errors.Is(err, io.EOF) {
+			return nil, fmt.Errorf("configuración %s: %v", file, err)
+		}
+		cfg.File = file
+	}
+
+	// 🌍 Entorno: P2PFS_<CLAVE> y los nombres antiguos
+	for _, f := range cfg.fields() {
+		for _, name := range []string{f.env, "P2PFS_" + strings.ToUpper(f.key)} {
+			if name == "" {
+				continue
+			}
+			if s, ok := os.LookupEnv(name); ok {
+				if err := set(f.value, s); err != nil {
+					return nil, fmt.Errorf("variable %s: %v", name, err)
+				}
+			}
+		}
+	}
+
+	// 🚩 Banderas explícitas
+	for _, f := range cfg.fields() {
+		if s, ok := opts.values[f.key]; ok {
+			if err := set(f.value, s); err != nil {
+				return nil, fmt.Errorf("bandera --%s: %v", f.flag, err)
+			}
+		}
+	}
+	if len(opts.joins) > 0 {
+		cfg.Joins = append(append([]string{}, opts.joins...), cfg.Joins...)
+	}
+
+	if len(cfg.Shares) == 0 {
+		cfg.Shares = []Share{{Name: DefaultShare, Path: cfg.SharedDir}}
+	}
+	for i := range cfg.Shares {
+		if cfg.Shares[i].Versions == nil {
+			v := DefaultVersions
+			cfg.Shares[i].Versions = &v
+		}
+		if cfg.Shares[i].TrashDays == nil {
+			days := DefaultTrashDays
+			cfg.Shares[i].TrashDays = &days
+		}
+	}
+	cfg.resolvePaths()
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+// resolvePaths hace que las rutas relativas cuelguen de DataDir. Quedan
+// absolutas para que la configuración efectiva se pueda volver a cargar.
+func (c *Config) resolvePaths() {
+	if c.DataDir == "" || c.DataDir == "." {
+		return
+	}
+	if abs, err := filepath.Abs(c.DataDir); err == nil {
+		c.DataDir = abs
+	}
+	paths := []*string{&c.SharedDir, &c.OplogFile, &c.StateFile, &c.JobsFile, &c.PeersFile, &c.SeedsFile, &c.ControlSocket, &c.ControlToken}
+	for i := range c.Shares {
+		paths = append(paths, &c.Shares[i].Path)
+	}
+	for _, p := range paths {
+		if *p != "" && !filepath.IsAbs(*p) {
+			*p = filepath.Join(c.DataDir, *p)
+		}
+	}
+}
+
+// Validate comprueba que los valores tengan sentido
+func (c *Config) Validate() error {
+	var errs []string
+	check := func(ok bool, format string, args ...interface{}) {
+		if !ok {
+			errs = append(errs, fmt.Sprintf(format, args...))
+		}
+	}
+
+	check(validPort(c.Port), "port: puerto no válido %q", c.Port)
+	check(validPort(c.DiscoveryPort), "discovery_port: puerto no válido %q", c.DiscoveryPort)
+	check(c.SharedDir != "", "shared_dir: no puede estar vacío")
+	check(c.OplogFile != "", "oplog_file: no puede estar vacío")
+	check(c.StateFile != "", "state_file: no puede estar vacío")
+	check(c.JobsFile != "", "jobs_file: no puede estar vacío")
+	check(c.PeersFile != "", "peers_file: no puede estar vacío")
+	check(c.ControlToken != "" || (c.ControlSocket == "" && c.ControlHTTP == ""), "control_token: no puede estar vacío si la API de control está activa")
+	check(c.MaxRetries >= 1, "max_retries: debe ser al menos 1")
+	check(c.RetryAttempts >= 1, "retry_attempts: debe ser al menos 1")
+	check(c.RetryMaxWait >= c.RetryBackoff, "retry_max_wait: no puede ser menor que retry_backoff")
+	check(c.MaxTransfers >= 1, "max_transfers: debe ser al menos 1")
+	check(c.MaxPeerTransfers >= 1, "max_peer_transfers: debe ser al menos 1")
+
+	limits := []struct{ key, value string }{
+		{"upload_limit", c.UploadLimit},
+		{"download_limit", c.DownloadLimit},
+		{"peer_upload_limit", c.PeerUploadLimit},
+		{"peer_download_limit", c.PeerDownloadLimit},
+		{"max_decompressed", c.MaxDecompressed},
+	}
+	for _, l := range limits {
+		if _, err := bandwidth.ParseRate(l.value); err != nil {
+			errs = append(errs, fmt.Sprintf("%s: %v", l.key, err))
+		}
+	}
+	for i, r := range c.BandwidthRules {
+		for _, v := range []string{r.From, r.To} {
+			if _, err := bandwidth.ParseClock(v); err != nil {
+				errs = append(errs, fmt.Sprintf("bandwidth_rules[%d]: %v", i, err))
+			}
+		}
+		for _, v := range []string{r.Upload, r.Download} {
+			if _, err := bandwidth.ParseRate(v); err != nil {
+				errs = append(errs, fmt.Sprintf("bandwidth_rules[%d]: %v", i, err))
+			}
+		}
+		for _, d := range r.Days {
+			if _, err := bandwidth.ParseDay(d); err != nil {
+				errs = append(errs, fmt.Sprintf("bandwidth_rules[%d]: %v", i, err))
+			}
+		}
+	}
+
+	for _, mode := range strings.Split(c.Discovery, ",") {
+		switch strings.TrimSpace(mode) {
+		case "broadcast", "multicast", "multicast6", "mdns", "mdns6":
+		default:
+			errs = append(errs, fmt.Sprintf("discovery: mecanismo desconocido %q", mode))
+		}
+	}
+
+	switch c.Compression {
+	case "auto", "gzip", "deflate", "off":
+	default:
+		errs = append(errs, fmt.Sprintf("compression: valor desconocido %q", c.Compression))
+	}
+
+	names := make(map[string]bool)
+	for i, sh := range c.Shares {
+		check(sh.Name != "" && !strings.ContainsAny(sh.Name, `/\`) && sh.Name != "." && sh.Name != "..",
+			"shares[%d]: nombre no válido %q", i, sh.Name)
+		check(!names[sh.Name], "shares[%d]: nombre repetido %q", i, sh.Name)
+		check(sh.Path != "", "shares[%d]: falta path", i)
+		switch sh.Mode {
+		case "", "send-receive", "send-only", "receive-only", "archive", "both", "send", "receive":
+		default:
+			errs = append(errs, fmt.Sprintf("shares[%d]: mode no válido %q (send-receive, send-only, receive-only, archive)", i, sh.Mode))
+		}
+		for _, pattern := range sh.Ignore {
+			_, err := ignore.Compile(pattern)
+			check(err == nil, "shares[%d]: %v", i, err)
+		}
+		for _, dir := range sh.Only {
+			clean := path.Clean(filepath.ToSlash(dir))
+			check(clean != "." && clean != ".." && !path.IsAbs(clean) && !strings.HasPrefix(clean, "../"),
+				"shares[%d]: only: subcarpeta no válida %q", i, dir)
+		}
+		if v := sh.Versions; v != nil {
+			switch v.Type {
+			case "", "simple", "staggered", "none":
+			default:
+				errs = append(errs, fmt.Sprintf("shares[%d]: versions.type no válido %q (simple, staggered, none)", i, v.Type))
+			}
+			check(v.Keep >= 0, "shares[%d]: versions.keep no puede ser negativo", i)
+			check(v.KeepDays >= 0, "shares[%d]: versions.keep_days no puede ser negativo", i)
+		}
+		if sh.TrashDays != nil {
+			check(*sh.TrashDays >= 0, "shares[%d]: trash_days no puede ser negativo", i)
+		}
+		check(sh.Replicas >= 0, "shares[%d]: replicas no puede ser negativo", i)
+		check(placement.Valid(sh.Placement), "shares[%d]: placement desconocido %q (hash o space)", i, sh.Placement)
+		names[sh.Name] = true
+	}
+
+	durations := map[string]time.Duration{
+		"announce_interval": c.AnnounceInterval,
+		"id_timeout":        c.IDTimeout,
+		"sync_interval":     c.SyncInterval,
+		"retry_interval":    c.RetryInterval,
+		"persist_interval":  c.PersistInterval,
+		"seed_refresh":      c.SeedRefresh,
+		"peer_expiry":       c.PeerExpiry,
+		"repair_interval":   c.RepairInterval,
+		"repair_after":      c.RepairAfter,
+		"retry_backoff":     c.RetryBackoff,
+		"retry_max_wait":    c.RetryMaxWait,
+		"dial_timeout":      c.DialTimeout,
+		"request_timeout":   c.RequestTimeout,
+		"shutdown_timeout":  c.ShutdownTimeout,
+	}
+	for _, f := range c.fields() {
+		if d, ok := durations[f.key]; ok {
+			check(d > 0, "%s: debe ser mayor que cero", f.key)
+		}
+	}
+
+	if len(errs) > 0 {
+		return fmt.Errorf("configuración no válida:\n  %s", strings.Join(errs, "\n  "))
+	}
+	return nil
+}
+
+func validPort(p string) bool {
+	n, err := strconv.Atoi(p)
+	return err == nil && n > 0 && n < 65536
+}
@@ -0,0 +1,659 @@
+package control
+
+import (
+	"archive/zip"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"p2pfs/internal/bandwidth"
+	"p2pfs/internal/config"
+	"p2pfs/internal/fs"
+	"p2pfs/internal/jobs"
+	logger "p2pfs/internal/log"
+	"p2pfs/internal/node"
+	"p2pfs/internal/peer"
+	"p2pfs/internal/share"
+	"p2pfs/internal/snapshot"
+	"p2pfs/internal/state"
+	"p2pfs/internal/trash"
+	"p2pfs/internal/utils"
+	"p2pfs/internal/versions"
+)
+
+// ErrNotFound indica que el nodo o la ruta pedidos no existen
+var ErrNotFound = errors.New("no encontrado")
+
+// Status resume el estado del nodo
+type Status struct {
+	ID           int       `json:"id"`
+	Addr         string    `json:"addr"`
+	Addrs        []string  `json:"addrs"`
+	Discovery    string    `json:"discovery"`
+	Peers        int       `json:"peers"`
+	Online       int       `json:"online"`
+	PendingTasks int       `json:"pending_tasks"`
+	DeadTasks    int       `json:"dead_tasks"`
+	Transfers    int       `json:"transfers"`
+	Queued       int       `json:"queued"`
+	Upload       string    `json:"upload_limit"`
+	Download     string    `json:"download_limit"`
+	StartedAt    time.Time `json:"started_at"`
+	Uptime       string    `json:"uptime"`
+}
+
+// PeerStatus es un peer conocido junto con su disponibilidad
+type PeerStatus struct {
+	peer.PeerInfo
+	Addr   string `json:"addr"`
+	Online bool   `json:"online"`
+	Self   bool   `json:"self"`
+}
+
+// RevertResult es el resultado de revertir una carpeta
+type RevertResult struct {
+	Share string `json:"share"`
+	Fixed int    `json:"fixed"`
+	Error string `json:"error,omitempty"`
+}
+
+// TransferResult es el resultado de enviar, pedir o borrar un archivo en un peer
+type TransferResult struct {
+	Node  string `json:"node"`
+	OK    bool   `json:"ok"`
+	Error string `json:"error,omitempty"`
+}
+
+// Args son los argumentos de una acción (POST), que viajan como JSON en el
+// cuerpo de la petición; cada acción usa los suyos
+type Args struct {
+	Node    string   `json:"node,omitempty"`
+	Nodes   []string `json:"nodes,omitempty"`
+	Path    string   `json:"path,omitempty"`
+	Version string   `json:"version,omitempty"`
+	File    string   `json:"file,omitempty"`
+	Share   string   `json:"share,omitempty"`
+	ID      string   `json:"id,omitempty"`
+}
+
+// API reúne las consultas y acciones sobre un nodo. La usan tanto los
+// servidores de control como la GUI, que corre en el mismo proceso.
+type API struct {
+	node *node.Node
+
+	// Dónde se expone la API, para mostrarlo en Config
+	socket, http string
+}
+
+// NewAPI crea la API de un nodo en ejecución
+func NewAPI(n *node.Node) *API {
+	return &API{node: n}
+}
+
+// Self devuelve el peer local
+func (a *API) Self() *peer.Peer {
+	return a.node.Self
+}
+
+// Status resume el estado actual del nodo
+func (a *API) Status() Status {
+	self := a.node.Self
+	peers := self.Peers.Snapshot()
+
+	online := 0
+	for _, info := range peers {
+		if self.IsSelf(info) || peer.IsPeerOnline(info) {
+			online++
+		}
+	}
+
+	up, down := bandwidth.Rates(time.Now())
+	pending, dead := jobs.Counts()
+	return Status{
+		ID:           self.GetID(),
+		Addr:         self.Addr(),
+		Addrs:        self.Addrs,
+		Discovery:    a.node.Discovery.Name(),
+		Peers:        len(peers),
+		Online:       online,
+		PendingTasks: pending,
+		DeadTasks:    dead,
+		Transfers:    len(peer.ActiveTransfers()),
+		Queued:       peer.QueuedTransfers(),
+		Upload:       bandwidth.FormatRate(up),
+		Download:     bandwidth.FormatRate(down),
+		StartedAt:    a.node.StartedAt,
+		Uptime:       time.Since(a.node.StartedAt).Round(time.Second).String(),
+	}
+}
+
+// Peers lista los peers conocidos, incluido el propio nodo
+func (a *API) Peers() []PeerStatus {
+	self := a.node.Self
+	list := []PeerStatus{}
+	for _, info := range self.Peers.Snapshot() {
+		isSelf := self.IsSelf(info)
+		list = append(list, PeerStatus{
+			PeerInfo: info,
+			Addr:     peer.PeerAddr(info),
+			Online:   isSelf || peer.IsPeerOnline(info),
+			Self:     isSelf,
+		})
+	}
+	return list
+}
+
+// PeerEvents notifica altas, cambios y bajas de peers
+func (a *API) PeerEvents() (<-chan peer.PeerEvent, func()) {
+	return a.node.Self.Peers.Subscribe()
+}
+
+// resolveNode interpreta una referencia a un nodo: vacío, "local" o "self"
+// para este nodo, un ID numérico o la dirección host:puerto de un peer
+// registrado
+func (a *API) resolveNode(ref string) (peer.PeerInfo, bool, error) {
+	self := a.node.Self
+	if ref == "" || ref == "local" || ref == "self" {
+		return peer.PeerInfo{ID: self.GetID(), IP: self.IP, Port: self.Port, Addrs: self.Addrs}, true, nil
+	}
+
+	if id, err := strconv.Atoi(ref); err == nil {
+		info := self.FindPeerByID(id)
+		if info == nil {
+			return peer.PeerInfo{}, false, fmt.Errorf("nodo %d desconocido: %w", id, ErrNotFound)
+		}
+		return *info, self.IsSelf(*info), nil
+	}
+
+	host, port, err := net.SplitHostPort(ref)
+	if err != nil {
+		return peer.PeerInfo{}, false, fmt.Errorf("nodo no válido %q, use un ID o host:puerto: %w", ref, ErrNotFound)
+	}
+	if info := (peer.PeerInfo{IP: host, Port: port}); self.IsSelf(info) {
+		return info, true, nil
+	}
+	// Solo peers registrados: la API no debe servir para enviar archivos
+	// a una dirección cualquiera
+	info, ok := self.FindPeer(ref)
+	if !ok {
+		return peer.PeerInfo{}, false, fmt.Errorf("nodo %s desconocido: %w", ref, ErrNotFound)
+	}
+	return info, self.IsSelf(info), nil
+}
+
+// subTree busca una ruta "carpeta/ruta" dentro del árbol de carpetas compartidas
+func subTree(root fs.FileNode, path string) (fs.FileNode, bool) {
+	node := root
+	for _, part := range strings.Split(filepath.ToSlash(filepath.Clean(path)), "/") {
+		if part == "" || part == "." {
+			continue
+		}
+		found := false
+		for _, child := range node.Children {
+			if child.Name == part {
+				node = child
+				found = true
+				break
+			}
+		}
+		if !found {
+			return fs.FileNode{}, false
+		}
+	}
+	return node, true
+}
+
+// Tree devuelve el árbol compartido de un nodo, o la rama indicada por path
+func (a *API) Tree(ref, path string) (fs.FileNode, error) {
+	info, isSelf, err := a.resolveNode(ref)
+	if err != nil {
+		return fs.FileNode{}, err
+	}
+
+	var tree fs.FileNode
+	if isSelf {
+		tree = share.Tree(nil)
+	} else {
+		var remote *fs.FileNode
+		remote, err = a.node.Self.RequestFileTree(peer.PeerAddr(info))
+		if err == nil && remote == nil {
+			err = fmt.Errorf("el nodo no devolvió su árbol")
+		}
+		if remote != nil {
+			tree = *remote
+		}
+	}
+	if err != nil {
+		return fs.FileNode{}, err
+	}
+
+	node, ok := subTree(tree, path)
+	if !ok {
+		return fs.FileNode{}, fmt.Errorf("%s: %w", path, ErrNotFound)
+	}
+	return node, nil
+}
+
+// CachedTree arma un árbol plano con los archivos vistos en la última
+// sincronización con el nodo, para cuando no responde
+func (a *API) CachedTree(ref string) (fs.FileNode, error) {
+	info, _, err := a.resolveNode(ref)
+	if err != nil {
+		return fs.FileNode{}, err
+	}
+	tree := fs.FileNode{Name: "/", IsDir: true}
+	for _, f := range state.GetFileCache(peer.CacheKey(info)) {
+		tree.Children = append(tree.Children, fs.FileNode{Name: f.Name, ModTime: f.ModTime})
+	}
+	return tree, nil
+}
+
+// Fetch descarga un archivo ("carpeta/ruta") de un nodo remoto a la misma
+// carpeta compartida de este nodo
+func (a *API) Fetch(ref, path string) (TransferResult, error) {
+	info, isSelf, err := a.resolveNode(ref)
+	if err != nil {
+		return TransferResult{}, err
+	}
+	if isSelf {
+		return TransferResult{}, fmt.Errorf("el archivo ya está en este nodo")
+	}
+
+	addr := peer.PeerAddr(info)
+	res := TransferResult{Node: addr, OK: true}
+	if err := a.node.Self.RequestRemoteFile(path, addr); err != nil {
+		res.OK = false
+		res.Error = err.Error()
+	}
+	return res, nil
+}
+
+// Send envía un archivo a los nodos indicados o, si no se indica ninguno, a
+// todos los peers conocidos. Una ruta relativa es "carpeta/ruta" dentro de
+// las carpetas compartidas.
+func (a *API) Send(path string, refs []string) ([]TransferResult, error) {
+	self := a.node.Self
+	if !filepath.IsAbs(path) {
+		_, _, local, err := share.Resolve(path)
+		if err != nil {
+			return nil, fmt.Errorf("%v: %w", err, ErrNotFound)
+		}
+		path = local
+	} else if _, _, ok := share.Locate(path); !ok {
+		return nil, fmt.Errorf("%s está fuera de las carpetas compartidas: %w", path, ErrNotFound)
+	}
+	if _, err := os.Stat(path); err != nil {
+		return nil, fmt.Errorf("%v: %w", err, ErrNotFound)
+	}
+
+	var targets []peer.PeerInfo
+	for _, ref := range refs {
+		info, isSelf, err := a.resolveNode(ref)
+		if err != nil {
+			return nil, err
+		}
+		if !isSelf {
+			targets = append(targets, info)
+		}
+	}
+	if len(refs) == 0 {
+		for _, info := range self.Peers.Snapshot() {
+			if !self.IsSelf(info) {
+				targets = append(targets, info)
+			}
+		}
+	}
+
+	results := []TransferResult{}
+	for _, info := range targets {
+		addr := peer.PeerAddr(info)
+		if resolved, ok := peer.ResolvePeerAddr(info, time.Second); ok {
+			addr = resolved
+		}
+		res := TransferResult{Node: addr, OK: true}
+		if err := self.SendFile(path, addr); err != nil {
+			res.OK = false
+			res.Error = err.Error()
+		}
+		results = append(results, res)
+	}
+	return results, nil
+}
+
+// Delete elimina un archivo local y propaga el borrado; devuelve los peers que fallaron
+func (a *API) Delete(path string) ([]TransferResult, error) {
+	failed, err := a.node.Self.DeleteShared(path)
+	if err != nil {
+		return nil, err
+	}
+	results := []TransferResult{}
+	for addr, err := range failed {
+		results = append(results, TransferResult{Node: addr, Error: err.Error()})
+	}
+	return results, nil
+}
+
+// Sync lanza una sincronización con un nodo o, sin referencia, con todos los
+// peers en línea. Devuelve las direcciones con las que se inició.
+func (a *API) Sync(ref string) ([]string, error) {
+	self := a.node.Self
+	var targets []peer.PeerInfo
+	if ref == "" {
+		for _, info := range self.Peers.Snapshot() {
+			if !self.IsSelf(info) && peer.IsPeerOnline(info) {
+				targets = append(targets, info)
+			}
+		}
+	} else {
+		info, isSelf, err := a.resolveNode(ref)
+		if err != nil {
+			return nil, err
+		}
+		if isSelf {
+			return nil, fmt.Errorf("no se puede sincronizar un nodo consigo mismo")
+		}
+		targets = append(targets, info)
+	}
+
+	started := []string{}
+	for _, info := range targets {
+		self.StartSync(info)
+		started = append(started, peer.PeerAddr(info))
+	}
+	return started, nil
+}
+
+// Export escribe en w un ZIP con un archivo o carpeta local ("carpeta/ruta",
+// o solo "carpeta" para la carpeta entera), sin lo ignorado. Los errores de
+// la ruta se devuelven antes de escribir nada.
+func (a *API) Export(name string, w io.Writer) error {
+	s, rel, local, err := share.Resolve(name)
+	if err != nil {
+		return fmt.Errorf("%v: %w", err, ErrNotFound)
+	}
+	if _, err := os.Stat(local); err != nil {
+		return fmt.Errorf("%s: %w", name, ErrNotFound)
+	}
+	return utils.ZipTo(w, local, func(sub string, isDir bool) bool {
+		return s.Ignored(path.Join(rel, sub), isDir)
+	})
+}
+
+// Snapshot escribe en w el snapshot de una carpeta compartida (archivos,
+// versiones y manifiesto). Si la carpeta no existe no escribe nada.
+func (a *API) Snapshot(name string, w io.Writer) error {
+	s, ok := share.Get(name)
+	if !ok {
+		return fmt.Errorf("carpeta %s: %w", name, ErrNotFound)
+	}
+	self := a.node.Self
+	if err := snapshot.Export(w, s, snapshot.Origin{ID: self.GetID(), IP: self.IP, Addr: self.Addr()}); err != nil {
+		return err
+	}
+	logger.AppendToLocalLog(logger.Operation{
+		Type:      "SNAPSHOT_EXPORT",
+		FileName:  s.Name,
+		From:      self.Addr(),
+		Timestamp: time.Now().Unix(),
+		Message:   "Snapshot exportado",
+	})
+	return nil
+}
+
+// ImportSnapshot vuelca un snapshot (ruta de un archivo en esta máquina)
+// en la carpeta name, o en la del manifiesto si name está vacío
+func (a *API) ImportSnapshot(file, name string) (snapshot.Result, error) {
+	zr, err := zip.OpenReader(file)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			err = fmt.Errorf("%v: %w", err, ErrNotFound)
+		}
+		return snapshot.Result{}, err
+	}
+	defer zr.Close()
+
+	m, err := snapshot.ReadManifest(&zr.Reader)
+	if err != nil {
+		return snapshot.Result{}, err
+	}
+	if name == "" {
+		name = m.Share
+	}
+	s, ok := share.Get(name)
+	if !ok {
+		return snapshot.Result{}, fmt.Errorf("carpeta %s: %w", name, ErrNotFound)
+	}
+	res, err := snapshot.Import(&zr.Reader, m, s)
+	if err != nil {
+		return res, err
+	}
+	logger.AppendToLocalLog(logger.Operation{
+		Type:      "SNAPSHOT_IMPORT",
+		FileName:  s.Name,
+		From:      a.node.Self.Addr(),
+		Timestamp: time.Now().Unix(),
+		Message: fmt.Sprintf("Snapshot de %s (nodo %d, %s) importado: %d archivo(s), %d sin cambios, %d carpeta(s), %d versión(es)",
+			m.Share, m.Origin.ID, m.Created.Format("2006-01-02 15:04"), res.Files, res.Skipped, res.Dirs, res.Versions),
+	})
+	return res, nil
+}
+
+// Versions lista las versiones guardadas de un archivo ("carpeta/ruta"),
+// de la más reciente a la más antigua
+func (a *API) Versions(path string) ([]versions.Version, error) {
+	s, rel, _, err := share.Resolve(path)
+	if err != nil {
+		return nil, fmt.Errorf("%v: %w", err, ErrNotFound)
+	}
+	return versions.List(s.Path, rel)
+}
+
+// Restore recupera una versión de un archivo y la envía a los peers como
+// un cambio más
+func (a *API) Restore(path, id string) ([]TransferResult, error) {
+	s, rel, local, err := share.Resolve(path)
+	if err != nil {
+		return nil, fmt.Errorf("%v: %w", err, ErrNotFound)
+	}
+	if err := versions.Restore(s.Path, rel, id, s.Versions); err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			err = fmt.Errorf("%v: %w", err, ErrNotFound)
+		}
+		return nil, err
+	}
+	logger.AppendToLocalLog(logger.Operation{
+		Type:      "RESTORE",
+		FileName:  s.Key(rel),
+		From:      a.node.Self.Addr(),
+		Timestamp: time.Now().Unix(),
+		Message:   fmt.Sprintf("Versión %s restaurada", id),
+	})
+	if !s.CanSend() {
+		return []TransferResult{}, nil
+	}
+	return a.Send(local, nil)
+}
+
+// TrashItem es un elemento de la papelera de una carpeta
+type TrashItem struct {
+	Share string `json:"share"`
+	trash.Item
+}
+
+// Trash lista la papelera de una carpeta, o de todas si name está vacío
+func (a *API) Trash(name string) ([]TrashItem, error) {
+	list := []*share.Share{}
+	if name == "" {
+		list = share.All()
+	} else if s, ok := share.Get(name); ok {
+		list = append(list, s)
+	} else {
+		return nil, fmt.Errorf("carpeta %s: %w", name, ErrNotFound)
+	}
+
+	items := []TrashItem{}
+	for _, s := range list {
+		found, err := trash.List(s.Path)
+		if err != nil {
+			return nil, err
+		}
+		for _, item := range found {
+			items = append(items, TrashItem{Share: s.Name, Item: item})
+		}
+	}
+	return items, nil
+}
+
+// RestoreTrash devuelve a su sitio un elemento de la papelera y lo envía a
+// los peers, de modo que un borrado recuperado en un nodo vuelve a todos.
+// Si mientras tanto se creó otro archivo con esa ruta, pasa a ser una versión.
+func (a *API) RestoreTrash(name, id string) ([]TransferResult, error) {
+	s, ok := share.Get(name)
+	if !ok {
+		return nil, fmt.Errorf("carpeta %s: %w", name, ErrNotFound)
+	}
+	item, err := s.RestoreTrash(id)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			err = fmt.Errorf("%v: %w", err, ErrNotFound)
+		}
+		return nil, err
+	}
+	logger.AppendToLocalLog(logger.Operation{
+		Type:      "TRASH_RESTORE",
+		FileName:  s.Key(item.Path),
+		From:      a.node.Self.Addr(),
+		Timestamp: time.Now().Unix(),
+		Message:   fmt.Sprintf("Recuperado de la papelera (borrado por %s)", item.From),
+	})
+	if !s.CanSend() {
+		return []TransferResult{}, nil
+	}
+	return a.Send(s.LocalPath(item.Path), nil)
+}
+
+// Changes lista los cambios locales de las carpetas receive-only
+func (a *API) Changes() []peer.LocalChange {
+	return peer.LocalChanges()
+}
+
+// Revert descarta las diferencias de una carpeta send-only o receive-only
+// con el resto de nodos
+func (a *API) Revert(name string) (RevertResult, error) {
+	if _, ok := share.Get(name); !ok {
+		return RevertResult{}, fmt.Errorf("carpeta %s: %w", name, ErrNotFound)
+	}
+	fixed, err := a.node.Self.Revert(name)
+	res := RevertResult{Share: name, Fixed: fixed}
+	if err != nil && fixed == 0 {
+		return res, err
+	}
+	if err != nil {
+		res.Error = err.Error()
+	}
+	return res, nil
+}
+
+// Repair comprueba ahora el factor de replicación de las carpetas que lo
+// tienen y repone las copias que falten
+func (a *API) Repair() []peer.RepairResult {
+	return a.node.Self.Repair()
+}
+
+// Transfers lista las transferencias en curso
+func (a *API) Transfers() []peer.Transfer {
+	return peer.ActiveTransfers()
+}
+
+// CancelTransfer cancela una transferencia en curso
+func (a *API) CancelTransfer(id int) error {
+	if !peer.CancelTransfer(id) {
+		return fmt.Errorf("transferencia %d: %w", id, ErrNotFound)
+	}
+	return nil
+}
+
+// TransferEvents notifica el inicio, el avance y el final de las transferencias
+func (a *API) TransferEvents() (<-chan peer.TransferEvent, func()) {
+	return peer.SubscribeTransfers()
+}
+
+// RetryQueue devuelve los trabajos de la cola de reintentos; con status
+// solo los que están en ese estado (pending, running, dead)
+func (a *API) RetryQueue(status string) ([]jobs.Job, error) {
+	switch status {
+	case "", jobs.Pending, jobs.Running, jobs.Dead:
+	default:
+		return nil, fmt.Errorf("estado desconocido %q (pending, running, dead)", status)
+	}
+	list := []jobs.Job{}
+	for _, j := range jobs.List() {
+		if status == "" || j.Status == status {
+			list = append(list, j)
+		}
+	}
+	return list, nil
+}
+
+// RetryJob devuelve un trabajo de la cola de reintentos
+func (a *API) RetryJob(id string) (jobs.Job, error) {
+	j, ok := jobs.Get(id)
+	if !ok {
+		return jobs.Job{}, fmt.Errorf("trabajo %s: %w", id, ErrNotFound)
+	}
+	return j, nil
+}
+
+// FlushRetries reintenta ahora todos los trabajos pendientes y devuelve cuántos quedan
+func (a *API) FlushRetries() int {
+	return a.node.Self.RetryPending()
+}
+
+// CancelRetry quita un trabajo de la cola de reintentos
+func (a *API) CancelRetry(id string) (jobs.Job, error) {
+	j, err := jobs.Cancel(id)
+	if errors.Is(err, jobs.ErrNotFound) {
+		return j, fmt.Errorf("trabajo %s: %w", id, ErrNotFound)
+	}
+	return j, err
+}
+
+// RequeueRetry vuelve a intentar desde cero un trabajo fallido o, sin id,
+// todos los fallidos. Devuelve cuántos se reencolaron.
+func (a *API) RequeueRetry(id string) (int, error) {
+	n, err := jobs.Requeue(id)
+	if errors.Is(err, jobs.ErrNotFound) {
+		return 0, fmt.Errorf("trabajo %s: %w", id, ErrNotFound)
+	}
+	return n, err
+}
+
+// Log devuelve las últimas n operaciones del registro local (todas si n < 0)
+func (a *API) Log(n int) []logger.Operation {
+	ops := logger.ReadLocalLog()
+	if n >= 0 && n < len(ops) {
+		ops = ops[len(ops)-n:]
+	}
+	// El contenido de los archivos no aporta nada a la consulta
+	out := make([]logger.Operation, len(ops))
+	for i, op := range ops {
+		op.Data = nil
+		out[i] = op
+	}
+	return out
+}
+
+// Config devuelve la configuración efectiva del nodo
+func (a *API) Config() config.Config {
+	cfg := *a.node.Config
+	cfg.ControlSocket = a.socket
+	cfg.ControlHTTP = a.http
+	return cfg
+}
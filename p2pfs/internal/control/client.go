@@ -0,0 +1,133 @@
+package control
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// unixHost es el nombre de host de las peticiones por el socket Unix
+const unixHost = "p2pfs"
+
+// Client habla con la API de control de un nodo en ejecución
+type Client struct {
+	http  *http.Client
+	base  string
+	token string
+}
+
+// NewClient prepara un cliente para la API. target es la ruta de un socket
+// Unix o una URL http:// de la API HTTP local; token, el del nodo (ver
+// LoadToken).
+func NewClient(target, token string) *Client {
+	c := &Client{http: &http.Client{Timeout: 5 * time.Minute}, token: token}
+	if strings.HasPrefix(target, "http://") {
+		c.base = strings.TrimSuffix(target, "/")
+		return c
+	}
+
+	c.base = "http://" + unixHost
+	c.http.Transport = &http.Transport{
+		DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			var d net.Dialer
+			return d.DialContext(ctx, "unix", target)
+		},
+	}
+	return c
+}
+
+// Get consulta un endpoint y decodifica la respuesta JSON en out
+func (c *Client) Get(path string, query url.Values, out interface{}) error {
+	return c.do(http.MethodGet, path, query, nil, out)
+}
+
+// Post ejecuta una acción con sus argumentos y decodifica la respuesta JSON
+// en out
+func (c *Client) Post(path string, args Args, out interface{}) error {
+	body, err := json.Marshal(args)
+	if err != nil {
+		return err
+	}
+	return c.do(http.MethodPost, path, nil, body, out)
+}
+
+// Download consulta un endpoint que devuelve un archivo y lo copia en w
+func (c *Client) Download(path string, query url.Values, w io.Writer) error {
+	resp, err := c.send(c.http, http.MethodGet, path, query, nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	_, err = io.Copy(w, resp.Body)
+	return err
+}
+
+// Stream consulta un endpoint que envía un objeto JSON tras otro y llama a
+// each con el decodificador para cada uno, hasta que el nodo cierra la
+// conexión o each devuelve un error
+func (c *Client) Stream(path string, query url.Values, each func(*json.Decoder) error) error {
+	// Sin el tiempo máximo de las demás consultas
+	h := *c.http
+	h.Timeout = 0
+	resp, err := c.send(&h, http.MethodGet, path, query, nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	dec := json.NewDecoder(resp.Body)
+	for dec.More() {
+		if err := each(dec); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (c *Client) do(method, path string, query url.Values, body []byte, out interface{}) error {
+	resp, err := c.send(c.http, method, path, query, body)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if out == nil {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+// send hace la petición con el token del nodo (y body, si lo hay, como
+// argumentos JSON) y convierte en error una respuesta que no sea 200
+func (c *Client) send(h *http.Client, method, path string, query url.Values, body []byte) (*http.Response, error) {
+	u := c.base + path
+	if len(query) > 0 {
+		u += "?" + query.Encode()
+	}
+	req, err := http.NewRequest(method, u, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	req.Header.Set("Authorization", "Bearer "+c.token)
+
+	resp, err := h.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("no se pudo contactar al nodo (¿está en ejecución?): %v", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		return nil, fmt.Errorf("%s", strings.TrimSpace(string(body)))
+	}
+	return resp, nil
+}
@@ -0,0 +1,536 @@
+// Package control expone el estado de un nodo y acciones sobre él a otros
+// procesos de la misma máquina, mediante HTTP sobre un socket Unix o sobre
+// un puerto TCP de loopback.
+package control
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"p2pfs/internal/config"
+)
+
+// Serve abre el socket de control y, si se configuró, la API HTTP local.
+// Ambos exigen el token de cfg.ControlToken, que se crea si no existe.
+func Serve(cfg *config.Config, api *API) ([]*Server, error) {
+	if cfg.ControlSocket == "" && cfg.ControlHTTP == "" {
+		return nil, nil
+	}
+	token, err := ensureToken(cfg.ControlToken)
+	if err != nil {
+		return nil, fmt.Errorf("token de control: %v", err)
+	}
+
+	var servers []*Server
+	if cfg.ControlSocket != "" {
+		srv, err := Listen(cfg.ControlSocket, token, api)
+		if err != nil {
+			return nil, err
+		}
+		servers = append(servers, srv)
+	}
+	if cfg.ControlHTTP != "" {
+		srv, err := ListenHTTP(cfg.ControlHTTP, token, api)
+		if err != nil {
+			for _, s := range servers {
+				s.Close()
+			}
+			return nil, err
+		}
+		servers = append(servers, srv)
+	}
+	return servers, nil
+}
+
+// Server atiende las peticiones de control de un nodo
+type Server struct {
+	api   *API
+	token string
+	path  string // socket Unix a eliminar al cerrar
+	srv   *http.Server
+}
+
+// Listen abre el socket de control y empieza a atender peticiones que
+// traigan token
+func Listen(path, token string, api *API) (*Server, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, err
+	}
+
+	// Un socket que ya acepta conexiones pertenece a otro nodo en marcha;
+	// si no responde, es un resto de una ejecución anterior
+	if conn, err := net.Dial("unix", path); err == nil {
+		conn.Close()
+		return nil, fmt.Errorf("ya hay un nodo escuchando en %s", path)
+	}
+	os.Remove(path)
+
+	ln, err := listenPrivate(path)
+	if err != nil {
+		return nil, err
+	}
+
+	api.socket = path
+	s := &Server{api: api, token: token, path: path}
+	s.serve(ln)
+	fmt.Println("🎛️ Control local en", path)
+	return s, nil
+}
+
+// ListenHTTP atiende la API en un puerto TCP. Solo se aceptan direcciones de
+// loopback y, como cualquier web abierta en el navegador puede llegar a
+// ellas, peticiones sin Origin, con un Host de loopback y con token.
+func ListenHTTP(addr, token string, api *API) (*Server, error) {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, err
+	}
+	if ip := net.ParseIP(host); host != "localhost" && (ip == nil || !ip.IsLoopback()) {
+		return nil, fmt.Errorf("la API HTTP solo puede escuchar en loopback, no en %s", host)
+	}
+
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+
+	api.http = ln.Addr().String()
+	s := &Server{api: api, token: token}
+	s.serve(ln)
+	fmt.Println("🎛️ API HTTP local en http://" + ln.Addr().String())
+	return s, nil
+}
+
+func (s *Server) serve(ln net.Listener) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/status", s.handleStatus)
+	mux.HandleFunc("/config", s.handleConfig)
+	mux.HandleFunc("/peers", s.handlePeers)
+	mux.HandleFunc("/tree", s.handleTree)
+	mux.HandleFunc("/transfers", s.handleTransfers)
+	mux.HandleFunc("/transfers/cancel", s.handleTransferCancel)
+	mux.HandleFunc("/transfers/events", s.handleTransferEvents)
+	mux.HandleFunc("/get", s.handleGet)
+	mux.HandleFunc("/put", s.handlePut)
+	mux.HandleFunc("/rm", s.handleRemove)
+	mux.HandleFunc("/sync", s.handleSync)
+	mux.HandleFunc("/versions", s.handleVersions)
+	mux.HandleFunc("/restore", s.handleRestore)
+	mux.HandleFunc("/export", s.handleExport)
+	mux.HandleFunc("/snapshot", s.handleSnapshot)
+	mux.HandleFunc("/snapshot/import", s.handleSnapshotImport)
+	mux.HandleFunc("/trash", s.handleTrash)
+	mux.HandleFunc("/trash/restore", s.handleTrashRestore)
+	mux.HandleFunc("/changes", s.handleChanges)
+	mux.HandleFunc("/revert", s.handleRevert)
+	mux.HandleFunc("/repair", s.handleRepair)
+	mux.HandleFunc("/retry", s.handleRetry)
+	mux.HandleFunc("/retry/flush", s.handleRetryFlush)
+	mux.HandleFunc("/retry/cancel", s.handleRetryCancel)
+	mux.HandleFunc("/retry/requeue", s.handleRetryRequeue)
+	mux.HandleFunc("/log", s.handleLog)
+	s.srv = &http.Server{Handler: s.guard(mux)}
+
+	go func() {
+		if err := s.srv.Serve(ln); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			fmt.Println("⚠️ Error en el servidor de control:", err)
+		}
+	}()
+}
+
+// listenPrivate crea el socket Unix en path sin que llegue a existir con
+// permisos para otros usuarios: se crea dentro de una carpeta temporal
+// 0700, se restringe a 0600 y solo entonces se mueve a su sitio
+func listenPrivate(path string) (net.Listener, error) {
+	dir, err := os.MkdirTemp(filepath.Dir(path), ".p2pfs-ctl-")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dir)
+
+	tmp := filepath.Join(dir, "ctl.sock")
+	ln, err := net.Listen("unix", tmp)
+	if err != nil {
+		return nil, err
+	}
+	// Server.Close borra el socket por su ruta definitiva
+	ln.(*net.UnixListener).SetUnlinkOnClose(false)
+	if err := os.Chmod(tmp, 0600); err != nil {
+		ln.Close()
+		return nil, err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		ln.Close()
+		return nil, err
+	}
+	return ln, nil
+}
+
+// guard rechaza lo que no venga de un cliente local del usuario: peticiones
+// de un navegador (traen Origin), nombres de host que no son de loopback
+// (DNS rebinding) y las que no traen el token del nodo
+func (s *Server) guard(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Origin") != "" {
+			http.Error(w, "no se aceptan peticiones desde navegadores", http.StatusForbidden)
+			return
+		}
+		if !s.allowedHost(r.Host) {
+			http.Error(w, "host no permitido: "+r.Host, http.StatusForbidden)
+			return
+		}
+		given := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if subtle.ConstantTimeCompare([]byte(given), []byte(s.token)) != 1 {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="p2pfs"`)
+			http.Error(w, "falta el token de control o no es válido", http.StatusUnauthorized)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// allowedHost acepta localhost y direcciones de loopback y, en el socket
+// Unix, el nombre que usa Client
+func (s *Server) allowedHost(hostport string) bool {
+	host := hostport
+	if h, _, err := net.SplitHostPort(hostport); err == nil {
+		host = h
+	}
+	if host == "localhost" || (s.path != "" && host == unixHost) {
+		return true
+	}
+	ip := net.ParseIP(strings.Trim(host, "[]"))
+	return ip != nil && ip.IsLoopback()
+}
+
+// Close deja de atender peticiones y elimina el socket
+func (s *Server) Close() error {
+	err := s.srv.Close()
+	if s.path != "" {
+		os.Remove(s.path)
+	}
+	return err
+}
+
+func (s *Server) handleStatus(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, s.api.Status())
+}
+
+func (s *Server) handleConfig(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, s.api.Config())
+}
+
+func (s *Server) handlePeers(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, s.api.Peers())
+}
+
+// GET /tree?node=&path=[&cached=1]
+func (s *Server) handleTree(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	if q.Get("cached") != "" {
+		tree, err := s.api.CachedTree(q.Get("node"))
+		writeResult(w, tree, err)
+		return
+	}
+	tree, err := s.api.Tree(q.Get("node"), q.Get("path"))
+	writeResult(w, tree, err)
+}
+
+func (s *Server) handleTransfers(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, s.api.Transfers())
+}
+
+// POST /transfers/cancel {"id"}
+func (s *Server) handleTransferCancel(w http.ResponseWriter, r *http.Request) {
+	args, ok := readArgs(w, r)
+	if !ok {
+		return
+	}
+	id, err := strconv.Atoi(args.ID)
+	if err != nil {
+		http.Error(w, "id no válido", http.StatusBadRequest)
+		return
+	}
+	err = s.api.CancelTransfer(id)
+	writeResult(w, map[string]int{"cancelled": id}, err)
+}
+
+// GET /transfers/events: un objeto JSON por evento hasta que el cliente
+// se desconecta
+func (s *Server) handleTransferEvents(w http.ResponseWriter, r *http.Request) {
+	events, cancel := s.api.TransferEvents()
+	defer cancel()
+
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	flusher, _ := w.(http.Flusher)
+	enc := json.NewEncoder(w)
+	if flusher != nil {
+		flusher.Flush()
+	}
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case ev := <-events:
+			if err := enc.Encode(ev); err != nil {
+				return
+			}
+			if flusher != nil {
+				flusher.Flush()
+			}
+		}
+	}
+}
+
+// POST /get {"node", "path"}
+func (s *Server) handleGet(w http.ResponseWriter, r *http.Request) {
+	args, ok := readArgs(w, r)
+	if !ok {
+		return
+	}
+	res, err := s.api.Fetch(args.Node, args.Path)
+	writeResult(w, res, err)
+}
+
+// POST /put {"path", "nodes"}
+func (s *Server) handlePut(w http.ResponseWriter, r *http.Request) {
+	args, ok := readArgs(w, r)
+	if !ok {
+		return
+	}
+	res, err := s.api.Send(args.Path, args.Nodes)
+	writeResult(w, res, err)
+}
+
+// POST /rm {"path"}
+func (s *Server) handleRemove(w http.ResponseWriter, r *http.Request) {
+	args, ok := readArgs(w, r)
+	if !ok {
+		return
+	}
+	res, err := s.api.Delete(args.Path)
+	writeResult(w, res, err)
+}
+
+// POST /sync {"node"} (sin node, con todos)
+func (s *Server) handleSync(w http.ResponseWriter, r *http.Request) {
+	args, ok := readArgs(w, r)
+	if !ok {
+		return
+	}
+	started, err := s.api.Sync(args.Node)
+	writeResult(w, started, err)
+}
+
+// GET /versions?path=
+func (s *Server) handleVersions(w http.ResponseWriter, r *http.Request) {
+	list, err := s.api.Versions(r.URL.Query().Get("path"))
+	writeResult(w, list, err)
+}
+
+// POST /restore {"path", "version"}
+func (s *Server) handleRestore(w http.ResponseWriter, r *http.Request) {
+	args, ok := readArgs(w, r)
+	if !ok {
+		return
+	}
+	res, err := s.api.Restore(args.Path, args.Version)
+	writeResult(w, res, err)
+}
+
+// GET /export?path= devuelve un ZIP
+func (s *Server) handleExport(w http.ResponseWriter, r *http.Request) {
+	name := r.URL.Query().Get("path")
+	out := &lazyWriter{w: w, name: path.Base(name) + ".zip"}
+	if err := s.api.Export(name, out); err != nil {
+		if !out.started {
+			writeResult(w, nil, err)
+			return
+		}
+		fmt.Printf("⚠️ Exportación de %s interrumpida: %v\n", name, err)
+	}
+}
+
+// GET /snapshot?share= devuelve el snapshot de la carpeta
+func (s *Server) handleSnapshot(w http.ResponseWriter, r *http.Request) {
+	name := r.URL.Query().Get("share")
+	out := &lazyWriter{w: w, name: name + ".snapshot.zip"}
+	if err := s.api.Snapshot(name, out); err != nil {
+		if !out.started {
+			writeResult(w, nil, err)
+			return
+		}
+		fmt.Printf("⚠️ Snapshot de %s interrumpido: %v\n", name, err)
+	}
+}
+
+// POST /snapshot/import {"file", "share"}
+func (s *Server) handleSnapshotImport(w http.ResponseWriter, r *http.Request) {
+	args, ok := readArgs(w, r)
+	if !ok {
+		return
+	}
+	res, err := s.api.ImportSnapshot(args.File, args.Share)
+	writeResult(w, res, err)
+}
+
+// lazyWriter envía las cabeceras del ZIP con la primera escritura, para
+// poder responder con un error mientras no se haya escrito nada
+type lazyWriter struct {
+	w       http.ResponseWriter
+	name    string
+	started bool
+}
+
+func (l *lazyWriter) Write(p []byte) (int, error) {
+	if !l.started {
+		l.started = true
+		l.w.Header().Set("Content-Type", "application/zip")
+		l.w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", l.name))
+	}
+	return l.w.Write(p)
+}
+
+// GET /trash[?share=]
+func (s *Server) handleTrash(w http.ResponseWriter, r *http.Request) {
+	items, err := s.api.Trash(r.URL.Query().Get("share"))
+	writeResult(w, items, err)
+}
+
+// POST /trash/restore {"share", "id"}
+func (s *Server) handleTrashRestore(w http.ResponseWriter, r *http.Request) {
+	args, ok := readArgs(w, r)
+	if !ok {
+		return
+	}
+	res, err := s.api.RestoreTrash(args.Share, args.ID)
+	writeResult(w, res, err)
+}
+
+func (s *Server) handleChanges(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, s.api.Changes())
+}
+
+// POST /revert {"share"}
+func (s *Server) handleRevert(w http.ResponseWriter, r *http.Request) {
+	args, ok := readArgs(w, r)
+	if !ok {
+		return
+	}
+	res, err := s.api.Revert(args.Share)
+	writeResult(w, res, err)
+}
+
+// POST /repair
+func (s *Server) handleRepair(w http.ResponseWriter, r *http.Request) {
+	if _, ok := readArgs(w, r); !ok {
+		return
+	}
+	writeJSON(w, s.api.Repair())
+}
+
+// GET /retry?status= o /retry?id=
+func (s *Server) handleRetry(w http.ResponseWriter, r *http.Request) {
+	if id := r.URL.Query().Get("id"); id != "" {
+		job, err := s.api.RetryJob(id)
+		writeResult(w, job, err)
+		return
+	}
+	list, err := s.api.RetryQueue(r.URL.Query().Get("status"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	writeJSON(w, list)
+}
+
+// POST /retry/flush
+func (s *Server) handleRetryFlush(w http.ResponseWriter, r *http.Request) {
+	if _, ok := readArgs(w, r); !ok {
+		return
+	}
+	writeJSON(w, map[string]int{"remaining": s.api.FlushRetries()})
+}
+
+// POST /retry/cancel {"id"}
+func (s *Server) handleRetryCancel(w http.ResponseWriter, r *http.Request) {
+	args, ok := readArgs(w, r)
+	if !ok {
+		return
+	}
+	job, err := s.api.CancelRetry(args.ID)
+	writeResult(w, job, err)
+}
+
+// POST /retry/requeue {"id"} (sin id, todos los fallidos)
+func (s *Server) handleRetryRequeue(w http.ResponseWriter, r *http.Request) {
+	args, ok := readArgs(w, r)
+	if !ok {
+		return
+	}
+	n, err := s.api.RequeueRetry(args.ID)
+	writeResult(w, map[string]int{"requeued": n}, err)
+}
+
+// GET /log?n=
+func (s *Server) handleLog(w http.ResponseWriter, r *http.Request) {
+	n, err := strconv.Atoi(r.URL.Query().Get("n"))
+	if err != nil {
+		n = -1
+	}
+	writeJSON(w, s.api.Log(n))
+}
+
+// readArgs comprueba que la petición sea un POST y decodifica sus
+// argumentos, un objeto JSON en el cuerpo
+func readArgs(w http.ResponseWriter, r *http.Request) (Args, bool) {
+	var args Args
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "use POST", http.StatusMethodNotAllowed)
+		return args, false
+	}
+	if mt, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type")); mt != "application/json" {
+		http.Error(w, "los argumentos van en JSON (Content-Type: application/json)", http.StatusUnsupportedMediaType)
+		return args, false
+	}
+	dec := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<20))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&args); err != nil && !errors.Is(err, io.EOF) {
+		http.Error(w, "argumentos no válidos: "+err.Error(), http.StatusBadRequest)
+		return args, false
+	}
+	return args, true
+}
+
+// writeResult responde v o, si hubo error, un código acorde a su causa
+func writeResult(w http.ResponseWriter, v interface{}, err error) {
+	switch {
+	case err == nil:
+		writeJSON(w, v)
+	case errors.Is(err, ErrNotFound):
+		http.Error(w, err.Error(), http.StatusNotFound)
+	default:
+		http.Error(w, err.Error(), http.StatusBadGateway)
+	}
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(v); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
@@ -0,0 +1,63 @@
+package control
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// LoadToken lee el token de la API de control de un nodo
+func LoadToken(path string) (string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	token := strings.TrimSpace(string(data))
+	if token == "" {
+		return "", fmt.Errorf("%s está vacío", path)
+	}
+	return token, nil
+}
+
+// ensureToken devuelve el token guardado en path o, si aún no existe, crea
+// uno al azar legible solo por el usuario del nodo
+func ensureToken(path string) (string, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return "", err
+	}
+
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	token := hex.EncodeToString(buf)
+
+	// O_EXCL con 0600: el archivo nunca existe con otros permisos
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if errors.Is(err, os.ErrExist) {
+		if info, err := os.Stat(path); err == nil && info.Mode().Perm()&0077 != 0 {
+			fmt.Printf("⚠️ %s era accesible para otros usuarios; se restringe a 0600\n", path)
+			if err := os.Chmod(path, 0600); err != nil {
+				return "", err
+			}
+		}
+		return LoadToken(path)
+	}
+	if err != nil {
+		return "", err
+	}
+	if _, err := f.WriteString(token + "\n"); err != nil {
+		f.Close()
+		os.Remove(path)
+		return "", err
+	}
+	if err := f.Close(); err != nil {
+		return "", err
+	}
+	fmt.Println("🔑 Token de la API de control creado en", path)
+	return token, nil
+}
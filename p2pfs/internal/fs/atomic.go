@@ -0,0 +1,114 @@
+package fs
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	iofs "io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// TempPrefix marca los archivos temporales de una escritura en curso. Se
+// crean en la misma carpeta que el destino para que el rename final sea
+// atómico; los que deja una caída se borran al arrancar (CleanTemp).
+const TempPrefix = ".p2pfs-tmp-"
+
+// IsTemp indica si name es un temporal de una escritura en curso
+func IsTemp(name string) bool {
+	return strings.HasPrefix(filepath.Base(name), TempPrefix)
+}
+
+// WriteFileAtomic escribe data en path de forma que un lector (o el
+// archivo tras una caída) vea el contenido anterior o el nuevo completo,
+// nunca uno a medias
+func WriteFileAtomic(path string, data []byte, perm os.FileMode) error {
+	return CopyAtomic(path, bytes.NewReader(data), perm)
+}
+
+// CopyAtomic es WriteFileAtomic leyendo el contenido de r: escribe en un
+// temporal de la misma carpeta, hace fsync y lo renombra sobre path
+func CopyAtomic(path string, r io.Reader, perm os.FileMode) error {
+	f, err := CreateAtomic(path)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(f, r); err != nil {
+		f.Abort()
+		return err
+	}
+	return f.Commit(perm)
+}
+
+// AtomicFile es un temporal junto a su destino que solo lo sustituye al
+// confirmarse con Commit; sirve para escribirlo por partes (WriteAt)
+type AtomicFile struct {
+	*os.File
+	path string
+}
+
+// CreateAtomic crea el temporal con el que se escribirá path
+func CreateAtomic(path string) (*AtomicFile, error) {
+	tmp, err := os.CreateTemp(filepath.Dir(path), TempPrefix+filepath.Base(path)+"-*")
+	if err != nil {
+		return nil, err
+	}
+	return &AtomicFile{File: tmp, path: path}, nil
+}
+
+// Abort descarta el temporal
+func (f *AtomicFile) Abort() {
+	f.Close()
+	os.Remove(f.Name())
+}
+
+// Commit hace fsync del temporal y lo renombra sobre el destino; si algo
+// falla el temporal se descarta
+func (f *AtomicFile) Commit(perm os.FileMode) error {
+	err := f.Chmod(perm)
+	if err == nil {
+		err = f.Sync()
+	}
+	if err == nil {
+		err = f.Close()
+	}
+	if err == nil {
+		err = os.Rename(f.Name(), f.path)
+	}
+	if err != nil {
+		f.Abort()
+		return err
+	}
+
+	// Sin sincronizar la carpeta el rename podría perderse en una caída;
+	// no todos los sistemas lo permiten, así que el error se ignora
+	if d, err := os.Open(filepath.Dir(f.path)); err == nil {
+		d.Sync()
+		d.Close()
+	}
+	return nil
+}
+
+// CleanTemp borra bajo root los temporales que dejaron escrituras
+// interrumpidas y devuelve cuántos eliminó
+func CleanTemp(root string) (int, error) {
+	removed := 0
+	err := filepath.WalkDir(root, func(p string, d iofs.DirEntry, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		if d.IsDir() || !IsTemp(d.Name()) {
+			return nil
+		}
+		if err := os.Remove(p); err != nil {
+			return fmt.Errorf("no se pudo borrar el temporal %s: %w", p, err)
+		}
+		removed++
+		return nil
+	})
+	return removed, err
+}
@@ -0,0 +1,62 @@
+package fs
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"os"
+)
+
+// ChunkSize es el tamaño de los bloques en que se descarga un archivo
+// grande desde varios peers a la vez
+const ChunkSize = 1 << 20
+
+// ChunkHashes devuelve el SHA-256 de cada bloque de path y el del archivo
+// completo, leyéndolo una sola vez
+func ChunkHashes(path string) ([]string, string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, "", err
+	}
+	defer f.Close()
+
+	whole := sha256.New()
+	buf := make([]byte, ChunkSize)
+	var chunks []string
+	for {
+		n, err := io.ReadFull(f, buf)
+		if n > 0 {
+			whole.Write(buf[:n])
+			chunks = append(chunks, fmt.Sprintf("%x", sha256.Sum256(buf[:n])))
+		}
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			break
+		}
+		if err != nil {
+			return nil, "", err
+		}
+	}
+	return chunks, fmt.Sprintf("%x", whole.Sum(nil)), nil
+}
+
+// ReadChunk lee el bloque index de path
+func ReadChunk(path string, index int) ([]byte, error) {
+	if index < 0 {
+		return nil, fmt.Errorf("bloque no válido %d", index)
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	buf := make([]byte, ChunkSize)
+	n, err := f.ReadAt(buf, int64(index)*ChunkSize)
+	if n == 0 && err != nil {
+		if err == io.EOF {
+			return nil, fmt.Errorf("bloque %d fuera del archivo", index)
+		}
+		return nil, err
+	}
+	return buf[:n], nil
+}
@@ -0,0 +1,9 @@
+//go:build !unix
+
+package fs
+
+// FreeSpace no está disponible en este sistema: la colocación por espacio
+// libre trata al nodo como si no lo conociera
+func FreeSpace(path string) int64 {
+	return 0
+}
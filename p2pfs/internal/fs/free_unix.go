@@ -0,0 +1,15 @@
+//go:build unix
+
+package fs
+
+import "syscall"
+
+// FreeSpace devuelve los bytes libres del sistema de archivos de path; 0
+// si no se pueden consultar
+func FreeSpace(path string) int64 {
+	var st syscall.Statfs_t
+	if err := syscall.Statfs(path, &st); err != nil {
+		return 0
+	}
+	return int64(st.Bavail) * int64(st.Bsize)
+}
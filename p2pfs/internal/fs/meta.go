@@ -0,0 +1,136 @@
+package fs
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Meta son los metadatos de un archivo que viajan con su contenido
+type Meta struct {
+	Mode    os.FileMode `json:"mode,omitempty"` // permisos
+	ModTime time.Time   `json:"mod_time"`       // fecha de modificación
+	Link    string      `json:"link,omitempty"` // destino, si es un enlace simbólico
+}
+
+// ReadMeta lee los metadatos de path. Con links un enlace simbólico se
+// describe como tal; si no, se sigue como hasta ahora.
+func ReadMeta(path string, links bool) (Meta, os.FileInfo, error) {
+	stat := os.Stat
+	if links {
+		stat = os.Lstat
+	}
+	info, err := stat(path)
+	if err != nil {
+		return Meta{}, nil, err
+	}
+	meta := Meta{Mode: info.Mode().Perm(), ModTime: info.ModTime()}
+	if info.Mode()&os.ModeSymlink != 0 {
+		if meta.Link, err = os.Readlink(path); err != nil {
+			return meta, info, err
+		}
+	}
+	return meta, info, nil
+}
+
+// ApplyMeta deja path con los permisos y la fecha de meta. Los enlaces
+// simbólicos no se tocan: cambiarlos afectaría a su destino.
+func ApplyMeta(path string, meta Meta) error {
+	if meta.Link != "" {
+		return nil
+	}
+	if meta.Mode.Perm() != 0 {
+		if err := os.Chmod(path, meta.Mode.Perm()); err != nil {
+			return err
+		}
+	}
+	if meta.ModTime.IsZero() {
+		return nil
+	}
+	return os.Chtimes(path, time.Now(), meta.ModTime)
+}
+
+// WriteSymlinkAtomic crea path como enlace simbólico a target, sustituyendo
+// de una vez lo que hubiera
+func WriteSymlinkAtomic(path, target string) error {
+	tmp := filepath.Join(filepath.Dir(path), fmt.Sprintf("%s%s-%d", TempPrefix, filepath.Base(path), time.Now().UnixNano()))
+	if err := os.Symlink(target, tmp); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return nil
+}
+
+// LinkInside indica si un enlace en rel (ruta relativa con "/") que apunta
+// a target queda dentro de la carpeta compartida
+func LinkInside(rel, target string) bool {
+	target = filepath.ToSlash(target)
+	if target == "" || path.IsAbs(target) || filepath.IsAbs(target) {
+		return false
+	}
+	joined := path.Join(path.Dir(rel), target)
+	return joined != ".." && !strings.HasPrefix(joined, "../")
+}
+
+// RealPath resuelve los enlaces simbólicos de p. La parte final que aún no
+// existe se añade tal cual; un enlace roto en el camino es un error, porque
+// al crear lo que falta se seguiría.
+func RealPath(p string) (string, error) {
+	p, err := filepath.Abs(p)
+	if err != nil {
+		return "", err
+	}
+	rest := ""
+	for {
+		real, err := filepath.EvalSymlinks(p)
+		if err == nil {
+			return filepath.Join(real, rest), nil
+		}
+		if !os.IsNotExist(err) {
+			return "", err
+		}
+		if _, err := os.Lstat(p); err == nil {
+			return "", fmt.Errorf("%s es un enlace roto", p)
+		}
+		parent := filepath.Dir(p)
+		if parent == p {
+			return filepath.Join(p, rest), nil
+		}
+		rest = filepath.Join(filepath.Base(p), rest)
+		p = parent
+	}
+}
+
+// Within indica si p, una vez resueltos sus enlaces, queda dentro de root
+func Within(root, p string) bool {
+	realRoot, err := RealPath(root)
+	if err != nil {
+		return false
+	}
+	real, err := RealPath(p)
+	if err != nil {
+		return false
+	}
+	rel, err := filepath.Rel(realRoot, real)
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
+// LinkWithin indica si un enlace en dest que apunta a target queda, ya en
+// el disco, dentro de root: a diferencia de LinkInside sigue los enlaces
+// que haya en las carpetas de dest
+func LinkWithin(root, dest, target string) bool {
+	if target == "" || path.IsAbs(filepath.ToSlash(target)) || filepath.IsAbs(target) {
+		return false
+	}
+	parent, err := RealPath(filepath.Dir(dest))
+	if err != nil {
+		return false
+	}
+	return Within(root, filepath.Join(parent, filepath.FromSlash(target)))
+}
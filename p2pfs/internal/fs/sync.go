@@ -17,7 +17,7 @@ func ApplyOperation(op log.Operation) error {
 		if err := os.MkdirAll(dir, 0755); err != nil {
 			return fmt.Errorf("error creando directorio para archivo: %w", err)
 		}
-		err := os.WriteFile(absPath, op.Data, 0644)
+		err := WriteFileAtomic(absPath, op.Data, 0644)
 		if err != nil {
 			return fmt.Errorf("error al escribir archivo: %w", err)
 		}
@@ -22,7 +22,7 @@ func SaveFile(path string, data []byte) error {
 		return fmt.Errorf("error creando directorio: %w", err)
 	}
 
-	if err := os.WriteFile(absPath, data, 0644); err != nil {
+	if err := WriteFileAtomic(absPath, data, 0644); err != nil {
 		return fmt.Errorf("error escribiendo archivo: %w", err)
 	}
 
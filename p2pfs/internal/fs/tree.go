@@ -2,6 +2,7 @@ package fs
 
 import (
 	"os"
+	"path"
 	"path/filepath"
 	"time"
   "fmt"
@@ -9,15 +10,35 @@ import (
 
 // FileNode representa un nodo en el árbol de archivos.
 type FileNode struct {
-	Name     string     `json:"name"`               // Nombre del archivo o carpeta
-	IsDir    bool       `json:"is_dir"`             // Si es directorio
-	ModTime  time.Time  `json:"mod_time"`           // Última modificación
-	Children []FileNode `json:"children,omitempty"` // Hijos (si es directorio)
+	Name     string      `json:"name"`               // Nombre del archivo o carpeta
+	IsDir    bool        `json:"is_dir"`             // Si es directorio
+	ModTime  time.Time   `json:"mod_time"`           // Última modificación
+	Mode     os.FileMode `json:"mode,omitempty"`     // Permisos
+	Size     int64       `json:"size,omitempty"`     // Tamaño en bytes (archivos)
+	Link     string      `json:"link,omitempty"`     // Destino, si es un enlace simbólico
+	Children []FileNode  `json:"children,omitempty"` // Hijos (si es directorio)
 }
 
-// BuildFileTree construye recursivamente un árbol desde un directorio base.
-func BuildFileTree(root string) (FileNode, error) {
+// TreeOptions ajusta cómo se recorre una carpeta
+type TreeOptions struct {
+	// Skip omite las entradas para las que devuelve true; rel es la ruta
+	// relativa a la raíz con separador "/". Un directorio omitido no se recorre.
+	Skip func(rel string, isDir bool) bool
+	// Links describe los enlaces simbólicos como tales en lugar de seguirlos
+	Links bool
+}
+
+// BuildFileTree construye recursivamente un árbol desde un directorio base,
+// según opts.
+func BuildFileTree(root string, opts TreeOptions) (FileNode, error) {
+	return buildTree(root, "", opts)
+}
+
+func buildTree(root, rel string, opts TreeOptions) (FileNode, error) {
 	info, err := os.Stat(root)
+	if opts.Links && rel != "" {
+		info, err = os.Lstat(root)
+	}
 	if err != nil {
 		if os.IsNotExist(err) {
 			// Carpeta no existe: aún devolvemos un nodo vacío para que la GUI muestre algo
@@ -35,9 +56,14 @@ func BuildFileTree(root string) (FileNode, error) {
 		Name:    info.Name(),
 		IsDir:   info.IsDir(),
 		ModTime: info.ModTime(),
+		Mode:    info.Mode().Perm(),
+	}
+	if info.Mode()&os.ModeSymlink != 0 {
+		node.Link, _ = os.Readlink(root)
 	}
 
 	if !info.IsDir() {
+		node.Size = info.Size()
 		return node, nil
 	}
 
@@ -48,7 +74,11 @@ func BuildFileTree(root string) (FileNode, error) {
 
 	for _, entry := range entries {
 		childPath := filepath.Join(root, entry.Name())
-		childNode, err := BuildFileTree(childPath)
+		childRel := path.Join(rel, entry.Name())
+		if opts.Skip != nil && opts.Skip(childRel, entry.IsDir()) {
+			continue
+		}
+		childNode, err := buildTree(childPath, childRel, opts)
 		if err != nil {
 			fmt.Println("⚠️ Error leyendo hijo:", childPath, err)
 			continue
@@ -74,3 +104,41 @@ func FlattenTree(root FileNode) []FileNode {
 	return flat
 }
 
+
+// FlattenTreePaths devuelve los archivos del árbol indexados por su ruta
+// relativa a la raíz, con separador "/".
+func FlattenTreePaths(root FileNode) map[string]FileNode {
+	flat := make(map[string]FileNode)
+	var traverse func(node FileNode, rel string)
+	traverse = func(node FileNode, rel string) {
+		if !node.IsDir {
+			flat[rel] = node
+			return
+		}
+		for _, child := range node.Children {
+			traverse(child, path.Join(rel, child.Name))
+		}
+	}
+	traverse(root, "")
+	return flat
+}
+
+// FlattenTreeDirs devuelve las carpetas del árbol (sin la raíz) indexadas
+// por su ruta relativa, con separador "/".
+func FlattenTreeDirs(root FileNode) map[string]FileNode {
+	flat := make(map[string]FileNode)
+	var traverse func(node FileNode, rel string)
+	traverse = func(node FileNode, rel string) {
+		if !node.IsDir {
+			return
+		}
+		if rel != "" {
+			flat[rel] = node
+		}
+		for _, child := range node.Children {
+			traverse(child, path.Join(rel, child.Name))
+		}
+	}
+	traverse(root, "")
+	return flat
+}
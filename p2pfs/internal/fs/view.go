@@ -18,6 +18,12 @@ type FileInfo struct {
 // ListFiles recorre el sistema de archivos local (desde una ruta base) y
 // retorna la lista de archivos y carpetas con su información relevante.
 func ListFiles(baseDir string) ([]FileInfo, error) {
+	return ListFilteredFiles(baseDir, nil)
+}
+
+// ListFilteredFiles es ListFiles omitiendo las entradas para las que skip
+// devuelve true (rel con separador "/"); una carpeta omitida no se recorre.
+func ListFilteredFiles(baseDir string, skip func(rel string, isDir bool) bool) ([]FileInfo, error) {
 	var result []FileInfo
 
 	err := filepath.Walk(baseDir, func(path string, info os.FileInfo, err error) error {
@@ -26,10 +32,14 @@ func ListFiles(baseDir string) ([]FileInfo, error) {
 			return nil
 		}
 
-		// Saltar archivos ocultos o temporales si se desea
-		// if strings.HasPrefix(info.Name(), ".") {
-		// 	return nil
-		// }
+		if rel, err := filepath.Rel(baseDir, path); err == nil && rel != "." && skip != nil {
+			if skip(filepath.ToSlash(rel), info.IsDir()) {
+				if info.IsDir() {
+					return filepath.SkipDir
+				}
+				return nil
+			}
+		}
 
 		entry := FileInfo{
 			Name:     info.Name(),
@@ -2,17 +2,17 @@
 package gui
 
 import (
+	"context"
 	"fmt"
 	"image/color"
-	"net"
-	"os"
 	"path/filepath"
 	"strings"
+	"sync"
 	"time"
 
+	"p2pfs/internal/control"
 	"p2pfs/internal/fs"
 	"p2pfs/internal/peer"
-	"p2pfs/internal/state"
 
 	"fyne.io/fyne/v2"
 	"fyne.io/fyne/v2/app"
@@ -24,11 +24,10 @@ import (
 
 var selectedFile string
 var localFileListWidget *fyne.Container
-var conn *peer.Peer
+var api *control.API
 var fileButtons map[string]*widget.Button
-var selfPort string
-var getPeersFunc func() []peer.PeerInfo
 var mainPanel *fyne.Container
+var refreshMu sync.Mutex // refreshUI se llama desde el ticker y desde los eventos de peers
 
 var backgroundColor = color.RGBA{R: 34, G: 40, B: 49, A: 255}
 var panelColor = color.RGBA{R: 57, G: 62, B: 70, A: 255}
@@ -37,14 +36,17 @@ var selectedColor = color.RGBA{R: 0, G: 122, B: 255, A: 255}
 var textPrimary = color.RGBA{R: 238, G: 238, B: 238, A: 255}
 var textSecondary = color.RGBA{R: 200, G: 200, B: 200, A: 255}
 
-func StartGUI(selfID int, getPeers func() []peer.PeerInfo, self *peer.Peer) {
-	conn = self
+// StartGUI muestra la ventana principal; todas las consultas y acciones
+// pasan por la API de control del nodo. Vuelve cuando se cierra la ventana
+// o se cancela ctx, sin detener el nodo: eso le toca a quien la llama.
+func StartGUI(ctx context.Context, nodeAPI *control.API) {
+	api = nodeAPI
 	fileButtons = make(map[string]*widget.Button)
-	getPeersFunc = getPeers
-	selfPort = self.Port
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
 
 	a := app.New()
-	w := a.NewWindow(fmt.Sprintf("P2PFS - Nodo %d", selfID))
+	w := a.NewWindow(fmt.Sprintf("P2PFS - Nodo %d", api.Status().ID))
 	w.Resize(fyne.NewSize(1200, 800))
 
 	statusLabel := widget.NewLabel("🟢 Sistema iniciado")
@@ -61,69 +63,128 @@ func StartGUI(selfID int, getPeers func() []peer.PeerInfo, self *peer.Peer) {
 				dialog.ShowInformation("Aviso", "No hay archivo seleccionado", w)
 				return
 			}
-			err := os.Remove("shared/" + selectedFile)
+			failed, err := api.Delete(selectedFile)
 			if err != nil {
 				dialog.ShowError(err, w)
 			} else {
 				updateLocalFiles()
-				statusLabel.SetText("🗑️ Archivo eliminado: " + selectedFile)
+				statusLabel.SetText(fmt.Sprintf("🗑️ Archivo eliminado: %s (%d peer(s) sin confirmar)", selectedFile, len(failed)))
 				selectedFile = ""
 			}
 		}),
+		widget.NewButton("Versiones", func() {
+			if selectedFile == "" {
+				dialog.ShowInformation("Aviso", "Seleccione un archivo primero", w)
+				return
+			}
+			showVersions(w, statusLabel, selectedFile)
+		}),
+		widget.NewButton("Papelera", func() {
+			showTrash(w, statusLabel)
+		}),
 		widget.NewButton("Transferir archivo", func() {
 			if selectedFile == "" {
 				dialog.ShowInformation("Aviso", "Seleccione un archivo primero", w)
 				return
 			}
-			msg := ""
-			success := 0
-			for _, peerInfo := range getPeersFunc() {
-				if peerInfo.Port == selfPort {
-					continue
-				}
-				addr := fmt.Sprintf("%s:%s", peerInfo.IP, peerInfo.Port)
-				err := conn.SendFile("shared/" + selectedFile, addr)
+			// El avance se ve en el panel de transferencias mientras tanto
+			statusLabel.SetText("📤 Enviando " + selectedFile + "...")
+			go func(name string) {
+				results, err := api.Send(name, nil)
 				if err != nil {
-					msg += fmt.Sprintf("❌ %s: %v\n", addr, err)
-				} else {
-					msg += fmt.Sprintf("✅ %s: Enviado\n", addr)
-					success++
+					dialog.ShowError(err, w)
+					return
 				}
+				msg := ""
+				success := 0
+				for _, res := range results {
+					if !res.OK {
+						msg += fmt.Sprintf("❌ %s: %s\n", res.Node, res.Error)
+					} else {
+						msg += fmt.Sprintf("✅ %s: Enviado\n", res.Node)
+						success++
+					}
+				}
+				dialog.ShowInformation("Transferencia", msg, w)
+				statusLabel.SetText(fmt.Sprintf("📤 Archivo enviado a %d nodo(s)", success))
+			}(selectedFile)
+		}),
+		widget.NewButton("Sincronizar", func() {
+			started, err := api.Sync("")
+			if err != nil {
+				dialog.ShowError(err, w)
+				return
 			}
-			dialog.ShowInformation("Transferencia", msg, w)
-			statusLabel.SetText(fmt.Sprintf("📤 Archivo enviado a %d nodo(s)", success))
+			statusLabel.SetText(fmt.Sprintf("🔁 Sincronizando con %d nodo(s)", len(started)))
 		}),
 	)
 
-	content := container.NewBorder(buttonBar, nil, nil, nil, mainPanel)
+	content := container.NewBorder(buttonBar, transfersPanel(ctx, statusLabel), nil, nil, mainPanel)
 	w.SetContent(content)
 
 	refreshUI(w, statusLabel)
 	go func() {
-		for range time.Tick(3 * time.Second) {
-			refreshUI(w, statusLabel)
+		ticker := time.NewTicker(3 * time.Second)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				refreshUI(w, statusLabel)
+			}
+		}
+	}()
+
+	// Redibujar en cuanto cambie la membresía, sin esperar al siguiente tick
+	events, unsubscribe := api.PeerEvents()
+	go func() {
+		defer unsubscribe()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case ev, ok := <-events:
+				if !ok {
+					return
+				}
+				statusLabel.SetText(fmt.Sprintf("🔔 Peer %s: %s", peer.PeerAddr(ev.Peer), ev.Type))
+				refreshUI(w, statusLabel)
+			}
 		}
 	}()
 
+	// Una señal al proceso cierra la ventana como si lo hiciera el usuario
+	go func() {
+		<-ctx.Done()
+		a.Quit()
+	}()
+
 	w.ShowAndRun()
 }
 
 func refreshUI(w fyne.Window, statusLabel *widget.Label) {
-	allPeers := getPeersFunc()
-	var slots [4]*peer.PeerInfo
-
-	slots[0] = &peer.PeerInfo{
-		ID:   conn.ID,
-		IP:   conn.IP,
-		Port: conn.Port,
+	refreshMu.Lock()
+	defer refreshMu.Unlock()
+
+	allPeers := api.Peers()
+	var slots [4]*control.PeerStatus
+
+	st := api.Status()
+	slots[0] = &control.PeerStatus{
+		PeerInfo: peer.PeerInfo{ID: st.ID},
+		Addr:     st.Addr,
+		Online:   true,
+		Self:     true,
 	}
 
 	idx := 1
 	for _, p := range allPeers {
-		if p.IP == conn.IP && p.Port == conn.Port {
+		if p.Self {
 			continue
 		}
 		if idx < 4 {
+			p := p
 			slots[idx] = &p
 			idx++
 		}
@@ -141,36 +202,30 @@ func refreshUI(w fyne.Window, statusLabel *widget.Label) {
 			continue
 		}
 
-		isLocal := p.IP == conn.IP && p.Port == conn.Port
+		isLocal := p.Self
 		var treeRoot fs.FileNode
-		titleText := fmt.Sprintf("Máquina %d (%s:%s)", p.ID, p.IP, p.Port)
-		peerAddr := fmt.Sprintf("%s:%s", p.IP, p.Port)
+		peerAddr := p.Addr
+		titleText := fmt.Sprintf("Máquina %d (%s)", p.ID, peerAddr)
 
 		iconStatus := widget.NewIcon(theme.CancelIcon())
-		if connTest, err := net.DialTimeout("tcp", peerAddr, 500*time.Millisecond); err == nil {
+		if p.Online {
 			iconStatus = widget.NewIcon(theme.ConfirmIcon())
-			connTest.Close()
 		}
 
 		if isLocal {
-			titleText = fmt.Sprintf("Máquina Local (%s:%s)", p.IP, p.Port)
-			treeRoot, _ = fs.BuildFileTree("shared")
+			titleText = fmt.Sprintf("Máquina Local (%s)", peerAddr)
+			treeRoot, _ = api.Tree("", "")
 			treeRoot.Name = ""
 		} else {
-			treeRoot = fs.FileNode{}
-			if state.OnlineStatus[p.IP] {
-				treePtr, err := conn.RequestFileTree(peerAddr)
-				if err == nil && treePtr != nil {
-					treeRoot = *treePtr
-				}
+			var err error = fmt.Errorf("peer fuera de línea")
+			if p.Online {
+				treeRoot, err = api.Tree(peerAddr, "")
 			}
-			if treeRoot.Name == "" {
-				files := state.FileCache[p.IP]
-				treeRoot = fs.FileNode{Name: "/", IsDir: true}
-				for _, f := range files {
-					treeRoot.Children = append(treeRoot.Children, fs.FileNode{Name: f.Name, IsDir: false, ModTime: f.ModTime})
-				}
+			if err != nil {
+				treeRoot, _ = api.CachedTree(peerAddr)
 			}
+			// Rutas "carpeta/ruta", igual que en el árbol local
+			treeRoot.Name = ""
 		}
 
 		idMap := make(map[string]fs.FileNode)
@@ -230,7 +285,11 @@ func refreshUI(w fyne.Window, statusLabel *widget.Label) {
 				} else {
 					dialog.ShowCustomConfirm("Descargar archivo", "Descargar", "Cancelar", widget.NewLabel(fileName), func(ok bool) {
 						if ok {
-							conn.RequestRemoteFile(fileName, peerAddr)
+							go func() {
+								if _, err := api.Fetch(peerAddr, fileName); err != nil {
+									dialog.ShowError(err, w)
+								}
+							}()
 						}
 					}, w)
 				}
@@ -313,3 +372,163 @@ func updateLocalFiles() {
 }
 
 
+
+// showVersions muestra las versiones guardadas de un archivo local y
+// permite restaurar una
+func showVersions(w fyne.Window, statusLabel *widget.Label, fileName string) {
+	list, err := api.Versions(fileName)
+	if err != nil {
+		dialog.ShowError(err, w)
+		return
+	}
+	if len(list) == 0 {
+		dialog.ShowInformation("Versiones", "No hay versiones guardadas de "+fileName, w)
+		return
+	}
+
+	var d dialog.Dialog
+	rows := container.NewVBox()
+	for _, v := range list {
+		v := v
+		label := widget.NewLabel(fmt.Sprintf("%s  (%d bytes)", v.Time.Format("2006-01-02 15:04:05"), v.Size))
+		restore := widget.NewButton("Restaurar", func() {
+			results, err := api.Restore(fileName, v.ID)
+			if err != nil {
+				dialog.ShowError(err, w)
+				return
+			}
+			d.Hide()
+			statusLabel.SetText(fmt.Sprintf("♻️ %s restaurado (%d nodo(s) notificados)", fileName, len(results)))
+			updateLocalFiles()
+		})
+		rows.Add(container.NewBorder(nil, nil, nil, restore, label))
+	}
+
+	scroll := container.NewVScroll(rows)
+	scroll.SetMinSize(fyne.NewSize(420, 240))
+	d = dialog.NewCustom("Versiones de "+fileName, "Cerrar", scroll, w)
+	d.Show()
+}
+
+// showTrash muestra lo que otros nodos borraron y sigue en la papelera, y
+// permite recuperarlo
+func showTrash(w fyne.Window, statusLabel *widget.Label) {
+	items, err := api.Trash("")
+	if err != nil {
+		dialog.ShowError(err, w)
+		return
+	}
+	if len(items) == 0 {
+		dialog.ShowInformation("Papelera", "La papelera está vacía", w)
+		return
+	}
+
+	var d dialog.Dialog
+	rows := container.NewVBox()
+	for _, item := range items {
+		item := item
+		name := item.Share + "/" + item.Path
+		label := widget.NewLabel(fmt.Sprintf("%s  (%s, %s)", name, item.DeletedAt.Format("2006-01-02 15:04:05"), item.From))
+		restore := widget.NewButton("Recuperar", func() {
+			results, err := api.RestoreTrash(item.Share, item.ID)
+			if err != nil {
+				dialog.ShowError(err, w)
+				return
+			}
+			d.Hide()
+			statusLabel.SetText(fmt.Sprintf("♻️ %s recuperado (%d nodo(s) notificados)", name, len(results)))
+			updateLocalFiles()
+		})
+		rows.Add(container.NewBorder(nil, nil, nil, restore, label))
+	}
+
+	scroll := container.NewVScroll(rows)
+	scroll.SetMinSize(fyne.NewSize(520, 240))
+	d = dialog.NewCustom("Papelera", "Cerrar", scroll, w)
+	d.Show()
+}
+
+// transferRow es una transferencia en el panel de transferencias
+type transferRow struct {
+	box   *fyne.Container
+	label *widget.Label
+	bar   *widget.ProgressBar // nil si no se conoce el total
+}
+
+// transfersPanel muestra las transferencias en curso con su avance y un
+// botón para cancelarlas; se actualiza con los eventos de transferencia
+// hasta que se cancela ctx
+func transfersPanel(ctx context.Context, statusLabel *widget.Label) fyne.CanvasObject {
+	list := container.NewVBox()
+	rows := make(map[int]*transferRow)
+
+	// Una fila por transferencia; si se perdió su evento de inicio se crea
+	// con el primero que llegue
+	row := func(t peer.Transfer) *transferRow {
+		if r, ok := rows[t.ID]; ok {
+			return r
+		}
+		r := &transferRow{label: widget.NewLabel("")}
+		var bar fyne.CanvasObject
+		if t.Total > 0 {
+			r.bar = widget.NewProgressBar()
+			bar = r.bar
+		} else {
+			bar = widget.NewProgressBarInfinite()
+		}
+		id := t.ID
+		cancel := widget.NewButtonWithIcon("", theme.CancelIcon(), func() {
+			if err := api.CancelTransfer(id); err != nil {
+				statusLabel.SetText("⚠️ " + err.Error())
+			}
+		})
+		r.box = container.NewBorder(nil, nil, nil, cancel, container.NewVBox(r.label, bar))
+		rows[t.ID] = r
+		list.Add(r.box)
+		return r
+	}
+	update := func(t peer.Transfer) {
+		r := row(t)
+		arrow := "📥"
+		if t.Direction != "fetch" && t.Direction != "recv" {
+			arrow = "📤"
+		}
+		r.label.SetText(fmt.Sprintf("%s %s (%s): %s", arrow, t.FileName, t.Peer, peer.DescribeProgress(t)))
+		if r.bar != nil && t.Total > 0 {
+			r.bar.SetValue(float64(t.Bytes) / float64(t.Total))
+		}
+	}
+
+	for _, t := range api.Transfers() {
+		update(t)
+	}
+	events, unsubscribe := api.TransferEvents()
+	go func() {
+		<-ctx.Done()
+		unsubscribe()
+	}()
+	go func() {
+		for ev := range events {
+			t := ev.Transfer
+			if ev.Type != peer.TransferFinished {
+				update(t)
+				continue
+			}
+			if r, ok := rows[t.ID]; ok {
+				list.Remove(r.box)
+				delete(rows, t.ID)
+			}
+			switch t.State {
+			case peer.TransferCancelled:
+				statusLabel.SetText(fmt.Sprintf("🚫 Transferencia de %s cancelada", t.FileName))
+			case peer.TransferFailed:
+				statusLabel.SetText(fmt.Sprintf("❌ Transferencia de %s fallida: %s", t.FileName, t.Error))
+			}
+		}
+	}()
+
+	title := widget.NewLabelWithStyle("Transferencias", fyne.TextAlignLeading, fyne.TextStyle{Bold: true})
+	scroll := container.NewVScroll(list)
+	scroll.SetMinSize(fyne.NewSize(0, 110))
+	return container.NewBorder(title, nil, nil, nil, scroll)
+}
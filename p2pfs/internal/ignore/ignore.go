@@ -0,0 +1,299 @@
+// Package ignore interpreta patrones con la sintaxis de .gitignore para
+// decidir qué archivos de una carpeta compartida no se replican.
+package ignore
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+// FileName es el archivo de patrones que se busca en cada carpeta. Es
+// propio de cada nodo y no se replica.
+const FileName = ".p2pfsignore"
+
+// Defaults se aplican antes que los patrones configurados, que pueden
+// anularlos con "!"
+var Defaults = []string{
+	FileName,
+	".git/",
+	".svn/",
+	".hg/",
+	"*.swp",
+	"*.swo",
+	"*~",
+	".#*",
+	".DS_Store",
+	"Thumbs.db",
+	"desktop.ini",
+}
+
+// reload es cada cuánto se vuelve a comprobar si un .p2pfsignore cambió
+const reload = 2 * time.Second
+
+// Rule es una línea de un archivo de patrones
+type Rule struct {
+	pattern string
+	re      *regexp.Regexp
+	negate  bool
+	dirOnly bool
+}
+
+// Compile interpreta una línea. Las líneas vacías y los comentarios
+// devuelven nil sin error.
+func Compile(line string) (*Rule, error) {
+	line = strings.TrimRight(line, "\r")
+	// Los espacios finales se ignoran salvo que estén escapados
+	for strings.HasSuffix(line, " ") && !strings.HasSuffix(line, `\ `) {
+		line = line[:len(line)-1]
+	}
+	if line == "" || strings.HasPrefix(line, "#") {
+		return nil, nil
+	}
+
+	r := &Rule{pattern: line}
+	if strings.HasPrefix(line, "!") {
+		r.negate = true
+		line = line[1:]
+	}
+	if strings.HasSuffix(line, "/") {
+		r.dirOnly = true
+		line = strings.TrimRight(line, "/")
+	}
+	if line == "" {
+		return nil, fmt.Errorf("patrón vacío %q", r.pattern)
+	}
+
+	re, err := globToRegexp(line)
+	if err != nil {
+		return nil, fmt.Errorf("patrón no válido %q: %v", r.pattern, err)
+	}
+	r.re = re
+	return r, nil
+}
+
+// globToRegexp traduce un patrón de .gitignore a una expresión regular que
+// se compara con la ruta relativa a la carpeta del archivo de patrones. Un
+// patrón sin "/" (salvo al final) vale a cualquier profundidad.
+func globToRegexp(pattern string) (*regexp.Regexp, error) {
+	var b strings.Builder
+	b.WriteString("^")
+	if !strings.Contains(pattern, "/") {
+		b.WriteString("(?:.*/)?")
+	}
+	p := []rune(strings.TrimPrefix(pattern, "/"))
+
+	for i := 0; i < len(p); i++ {
+		switch c := p[i]; c {
+		case '*':
+			if i+1 < len(p) && p[i+1] == '*' && (i == 0 || p[i-1] == '/') {
+				switch {
+				case i+2 == len(p): // "dir/**": todo lo que hay dentro
+					b.WriteString(".*")
+					i++
+					continue
+				case p[i+2] == '/': // "**/": cualquier número de carpetas
+					b.WriteString("(?:.*/)?")
+					i += 2
+					continue
+				}
+			}
+			for i+1 < len(p) && p[i+1] == '*' {
+				i++
+			}
+			b.WriteString("[^/]*")
+		case '?':
+			b.WriteString("[^/]")
+		case '[':
+			end := classEnd(p, i)
+			if end < 0 {
+				b.WriteString(`\[`)
+				continue
+			}
+			b.WriteString("[")
+			j := i + 1
+			if p[j] == '!' || p[j] == '^' {
+				b.WriteString("^")
+				j++
+			}
+			for ; j < end; j++ {
+				if p[j] == '\\' && j+1 < end {
+					j++
+				}
+				b.WriteString(regexp.QuoteMeta(string(p[j])))
+			}
+			b.WriteString("]")
+			i = end
+		case '\\':
+			if i+1 < len(p) {
+				i++
+				b.WriteString(regexp.QuoteMeta(string(p[i])))
+			}
+		default:
+			b.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+
+	b.WriteString("$")
+	return regexp.Compile(b.String())
+}
+
+// classEnd devuelve la posición del "]" que cierra la clase que empieza en
+// start, o -1 si no se cierra
+func classEnd(p []rune, start int) int {
+	j := start + 1
+	if j < len(p) && (p[j] == '!' || p[j] == '^') {
+		j++
+	}
+	if j < len(p) && p[j] == ']' {
+		j++
+	}
+	for ; j < len(p); j++ {
+		switch p[j] {
+		case '\\':
+			j++
+		case ']':
+			return j
+		}
+	}
+	return -1
+}
+
+// Parse interpreta un archivo de patrones. Las líneas no válidas se omiten
+// y se informa la primera.
+func Parse(data string) ([]*Rule, error) {
+	var rules []*Rule
+	var first error
+	for n, line := range strings.Split(data, "\n") {
+		r, err := Compile(line)
+		if err != nil {
+			if first == nil {
+				first = fmt.Errorf("línea %d: %v", n+1, err)
+			}
+			continue
+		}
+		if r != nil {
+			rules = append(rules, r)
+		}
+	}
+	return rules, first
+}
+
+// match aplica las reglas en orden; gana la última que coincide
+func match(rules []*Rule, rel string, isDir bool) (matched, ignored bool) {
+	for _, r := range rules {
+		if r.dirOnly && !isDir {
+			continue
+		}
+		if r.re.MatchString(rel) {
+			matched, ignored = true, !r.negate
+		}
+	}
+	return matched, ignored
+}
+
+// Matcher decide qué rutas de una carpeta se ignoran combinando unos
+// patrones fijos con los .p2pfsignore de la carpeta y sus subcarpetas
+type Matcher struct {
+	root string
+	base []*Rule
+
+	mu    sync.Mutex
+	files map[string]*ruleFile
+}
+
+// ruleFile es un .p2pfsignore leído, con lo necesario para saber si cambió
+type ruleFile struct {
+	checked time.Time
+	modTime time.Time
+	size    int64
+	rules   []*Rule
+}
+
+// NewMatcher crea el filtro de la carpeta root con los patrones dados
+func NewMatcher(root string, patterns []string) (*Matcher, error) {
+	m := &Matcher{root: root, files: make(map[string]*ruleFile)}
+	for _, pattern := range patterns {
+		r, err := Compile(pattern)
+		if err != nil {
+			return nil, err
+		}
+		if r != nil {
+			m.base = append(m.base, r)
+		}
+	}
+	return m, nil
+}
+
+// Ignored indica si la ruta relativa (separada por "/") se ignora. Una
+// ruta dentro de una carpeta ignorada también se ignora y, como en git, no
+// se puede volver a incluir.
+func (m *Matcher) Ignored(rel string, isDir bool) bool {
+	rel = path.Clean(rel)
+	if rel == "." || rel == "" {
+		return false
+	}
+	parts := strings.Split(rel, "/")
+	for i := range parts {
+		entryIsDir := isDir || i < len(parts)-1
+		if m.ignoredEntry(parts[:i+1], entryIsDir) {
+			return true
+		}
+	}
+	return false
+}
+
+// ignoredEntry evalúa una sola ruta con los patrones fijos y luego con los
+// .p2pfsignore desde la raíz hasta su carpeta; los más profundos ganan
+func (m *Matcher) ignoredEntry(parts []string, isDir bool) bool {
+	rel := strings.Join(parts, "/")
+	_, ignored := match(m.base, rel, isDir)
+	for depth := 0; depth < len(parts); depth++ {
+		dir := strings.Join(parts[:depth], "/")
+		sub := strings.Join(parts[depth:], "/")
+		if matched, ig := match(m.rulesIn(dir), sub, isDir); matched {
+			ignored = ig
+		}
+	}
+	return ignored
+}
+
+// rulesIn devuelve los patrones del .p2pfsignore de una subcarpeta,
+// releyéndolo solo si cambió
+func (m *Matcher) rulesIn(dir string) []*Rule {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	cached := m.files[dir]
+	if cached != nil && time.Since(cached.checked) < reload {
+		return cached.rules
+	}
+
+	file := filepath.Join(m.root, filepath.FromSlash(dir), FileName)
+	info, err := os.Stat(file)
+	if err != nil {
+		m.files[dir] = &ruleFile{checked: time.Now()}
+		return nil
+	}
+	if cached != nil && cached.modTime.Equal(info.ModTime()) && cached.size == info.Size() {
+		cached.checked = time.Now()
+		return cached.rules
+	}
+
+	data, err := os.ReadFile(file)
+	if err != nil {
+		fmt.Printf("⚠️ No se pudo leer %s: %v\n", file, err)
+		return nil
+	}
+	rules, err := Parse(string(data))
+	if err != nil {
+		fmt.Printf("⚠️ %s: %v\n", file, err)
+	}
+	m.files[dir] = &ruleFile{checked: time.Now(), modTime: info.ModTime(), size: info.Size(), rules: rules}
+	return rules
+}
@@ -0,0 +1,138 @@
+package ignore
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// TestCompile comprueba la traducción de cada patrón con rutas que deben
+// coincidir y rutas que no
+func TestCompile(t *testing.T) {
+	for _, tc := range []struct {
+		pattern string
+		match   []string
+		noMatch []string
+	}{
+		{pattern: "*.log", match: []string{"a.log", "x/y/a.log"}, noMatch: []string{"a.log.txt", "log"}},
+		{pattern: "**/x", match: []string{"x", "a/x", "a/b/x"}, noMatch: []string{"ax", "x/a", "a/bx"}},
+		{pattern: "a/**/b", match: []string{"a/b", "a/x/b", "a/x/y/b"}, noMatch: []string{"b", "x/a/b", "a/xb", "ab"}},
+		{pattern: "a/**", match: []string{"a/b", "a/b/c"}, noMatch: []string{"a", "b/a/c"}},
+		{pattern: "/build", match: []string{"build"}, noMatch: []string{"src/build", "builds"}},
+		{pattern: "docs/*.md", match: []string{"docs/a.md"}, noMatch: []string{"a.md", "x/docs/a.md", "docs/x/a.md"}},
+		{pattern: "?.txt", match: []string{"a.txt", "d/b.txt"}, noMatch: []string{"ab.txt", ".txt"}},
+		{pattern: "[!a-z].txt", match: []string{"A.txt", "1.txt", "-.txt"}, noMatch: []string{"a.txt", "q.txt"}},
+		{pattern: "[a-c]x", match: []string{"ax", "cx"}, noMatch: []string{"dx", "-x"}},
+		{pattern: "[^0-9]", match: []string{"a"}, noMatch: []string{"5"}},
+		{pattern: "[]]", match: []string{"]"}, noMatch: []string{"a"}},
+		{pattern: "[abc", match: []string{"[abc"}, noMatch: []string{"a"}},
+		{pattern: `\ lead`, match: []string{" lead"}, noMatch: []string{"lead"}},
+		{pattern: `trail\ `, match: []string{"trail "}, noMatch: []string{"trail"}},
+		{pattern: "trail   ", match: []string{"trail"}, noMatch: []string{"trail "}},
+		{pattern: `\#hash`, match: []string{"#hash"}},
+		{pattern: `\!bang`, match: []string{"!bang"}, noMatch: []string{"bang"}},
+		{pattern: `a\*b`, match: []string{"a*b"}, noMatch: []string{"axb"}},
+		{pattern: "a.b", match: []string{"a.b"}, noMatch: []string{"axb"}},
+		{pattern: "a+(b)", match: []string{"a+(b)"}, noMatch: []string{"aab"}},
+	} {
+		r, err := Compile(tc.pattern)
+		if err != nil || r == nil {
+			t.Errorf("Compile(%q) = %v, %v", tc.pattern, r, err)
+			continue
+		}
+		for _, rel := range tc.match {
+			if !r.re.MatchString(rel) {
+				t.Errorf("%q no coincide con %q (%s)", tc.pattern, rel, r.re)
+			}
+		}
+		for _, rel := range tc.noMatch {
+			if r.re.MatchString(rel) {
+				t.Errorf("%q coincide con %q (%s)", tc.pattern, rel, r.re)
+			}
+		}
+	}
+}
+
+// TestCompileLines comprueba los comentarios, las líneas vacías, la
+// negación y los patrones de carpeta
+func TestCompileLines(t *testing.T) {
+	for _, line := range []string{"", "   ", "# comentario", "\r"} {
+		if r, err := Compile(line); r != nil || err != nil {
+			t.Errorf("Compile(%q) = %v, %v; se esperaba nil", line, r, err)
+		}
+	}
+	for _, line := range []string{"!", "/", "!/"} {
+		if _, err := Compile(line); err == nil {
+			t.Errorf("Compile(%q) no dio error", line)
+		}
+	}
+	r, err := Compile("!keep/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !r.negate || !r.dirOnly || !r.re.MatchString("keep") {
+		t.Errorf("!keep/: negate=%v dirOnly=%v re=%s", r.negate, r.dirOnly, r.re)
+	}
+}
+
+// TestMatcherIgnored comprueba el orden de los patrones, las carpetas, la
+// reinclusión y los .p2pfsignore anidados
+func TestMatcherIgnored(t *testing.T) {
+	root := t.TempDir()
+	files := map[string]string{
+		FileName:                          "*.tmp\nbuild/\n/secret.txt\n",
+		filepath.Join("a", FileName):      "!keep.tmp\n*.dat\n",
+		filepath.Join("a", "b", FileName): "!x.dat\n",
+	}
+	for name, content := range files {
+		p := filepath.Join(root, name)
+		if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(p, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	m, err := NewMatcher(root, append(append([]string{}, Defaults...), "*.bak", "!important.bak", "logs/"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range []struct {
+		rel     string
+		isDir   bool
+		ignored bool
+	}{
+		{"doc.txt", false, false},
+		{FileName, false, true},
+		{"a/" + FileName, false, true},
+		{".git", true, true},
+		{".git/config", false, true},
+		{"x.swp", false, true},
+		{"notes~", false, true},
+		{"old.bak", false, true},
+		{"important.bak", false, false}, // "!" tras el patrón lo reincluye
+		{"logs", true, true},
+		{"logs", false, false}, // "logs/" solo vale para carpetas
+		{"logs/today.txt", false, true},
+		{"build/out.bin", false, true},
+		{"a/build/out.bin", false, true},
+		{"secret.txt", false, true},
+		{"a/secret.txt", false, false}, // "/secret.txt" va anclado a su carpeta
+		{"x.tmp", false, true},
+		{"a/x.tmp", false, true},
+		{"a/keep.tmp", false, false}, // a/.p2pfsignore anula al de la raíz
+		{"keep.tmp", false, true},    // pero solo dentro de a
+		{"a/b/keep.tmp", false, false},
+		{"a/y.dat", false, true},
+		{"y.dat", false, false},
+		{"a/b/x.dat", false, false}, // a/b/.p2pfsignore anula a a/.p2pfsignore
+		{"a/b/y.dat", false, true},
+		{"build", true, true},
+		{"build/keep.tmp", false, true}, // dentro de una carpeta ignorada no se reincluye
+	} {
+		if got := m.Ignored(tc.rel, tc.isDir); got != tc.ignored {
+			t.Errorf("Ignored(%q, %v) = %v, se esperaba %v", tc.rel, tc.isDir, got, tc.ignored)
+		}
+	}
+}
@@ -0,0 +1,385 @@
+// Package jobs es la cola persistente de trabajos que fallaron y se
+// reintentan más tarde: envíos, borrados y sincronizaciones con un peer.
+// Cada trabajo se identifica por su tipo, destino y archivo, de modo que el
+// mismo fallo repetido no lo duplica, y el envío de una carpeta incluye los
+// de los archivos que contiene. Los reintentos se espacian cada vez
+// más (con algo de azar para que no coincidan) y, agotados los intentos, el
+// trabajo queda como fallido definitivamente hasta que se reencole o cancele.
+package jobs
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"p2pfs/internal/fs"
+)
+
+// Tipos de trabajo
+const (
+	Transfer = "TRANSFER" // enviar un archivo local (ruta local) a un peer
+	Delete   = "DELETE"   // pedir a un peer que borre "carpeta/ruta"
+	Sync     = "SYNC"     // sincronizar con un peer
+)
+
+// Estados de un trabajo
+const (
+	Pending = "pending" // espera su próximo intento
+	Running = "running" // se está reintentando
+	Dead    = "dead"    // agotó los intentos
+)
+
+// ErrNotFound indica que no hay ningún trabajo con ese ID
+var ErrNotFound = errors.New("trabajo no encontrado")
+
+var (
+	// File es donde se guarda la cola; lo fija la configuración del nodo
+	File = "state/jobs.json"
+	// MaxAttempts son los reintentos antes de dar un trabajo por fallido
+	MaxAttempts = 10
+	// Backoff es la espera antes del primer reintento; se duplica en cada
+	// fallo hasta MaxBackoff
+	Backoff    = 10 * time.Second
+	MaxBackoff = time.Hour
+)
+
+// Job es un trabajo pendiente
+type Job struct {
+	ID        string    `json:"id"`
+	Key       string    `json:"key"` // clave de idempotencia: tipo|destino|archivo
+	Type      string    `json:"type"`
+	FileName  string    `json:"filename,omitempty"`
+	To        string    `json:"to"`
+	Force     bool      `json:"force,omitempty"` // envío que sobrescribe la copia remota
+	Status    string    `json:"status"`
+	Attempts  int       `json:"attempts"`
+	LastError string    `json:"last_error,omitempty"`
+	Created   time.Time `json:"created"`
+	Updated   time.Time `json:"updated"`
+	NextRun   time.Time `json:"next_run"`
+}
+
+var (
+	mu   sync.Mutex
+	jobs = make(map[string]*Job)
+)
+
+// Key devuelve la clave de idempotencia de un trabajo
+func Key(kind, to, name string) string {
+	return kind + "|" + to + "|" + name
+}
+
+// idOf deriva de la clave un ID corto para la CLI
+func idOf(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])[:10]
+}
+
+// delay es la espera tras el intento attempts: Backoff·2^attempts hasta
+// MaxBackoff, escogida al azar entre la mitad y el total
+func delay(attempts int) time.Duration {
+	d := Backoff
+	for i := 0; i < attempts && d < MaxBackoff; i++ {
+		d *= 2
+	}
+	if d > MaxBackoff {
+		d = MaxBackoff
+	}
+	if d <= 1 {
+		return d
+	}
+	half := d / 2
+	return half + time.Duration(rand.Int63n(int64(d-half)))
+}
+
+// Load carga la cola desde File. Los trabajos que estaban en curso cuando
+// se detuvo el nodo vuelven a quedar pendientes.
+func Load() error {
+	mu.Lock()
+	defer mu.Unlock()
+
+	data, err := os.ReadFile(File)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return fmt.Errorf("read: %w", err)
+	}
+	var list []*Job
+	if err := json.Unmarshal(data, &list); err != nil {
+		return fmt.Errorf("unmarshal: %w", err)
+	}
+	jobs = make(map[string]*Job, len(list))
+	for _, j := range list {
+		if j.Status == Running {
+			j.Status = Pending
+		}
+		jobs[j.ID] = j
+	}
+	return nil
+}
+
+// saveLocked escribe la cola a disco; el llamador debe tener mu
+func saveLocked() error {
+	data, err := json.MarshalIndent(listLocked(), "", "  ")
+	if err != nil {
+		return fmt.Errorf("marshal: %w", err)
+	}
+	if err := os.MkdirAll(filepath.Dir(File), 0755); err != nil {
+		return err
+	}
+	return fs.WriteFileAtomic(File, data, 0644)
+}
+
+// Save escribe la cola a disco, con los trabajos en curso tal cual: al
+// cargarla vuelven a quedar pendientes
+func Save() error {
+	mu.Lock()
+	defer mu.Unlock()
+	return saveLocked()
+}
+
+func save() {
+	if err := saveLocked(); err != nil {
+		fmt.Println("⚠️ Error al guardar la cola de reintentos:", err)
+	}
+}
+
+// listLocked devuelve copias de los trabajos, los más antiguos primero
+func listLocked() []Job {
+	list := make([]Job, 0, len(jobs))
+	for _, j := range jobs {
+		list = append(list, *j)
+	}
+	sort.Slice(list, func(a, b int) bool {
+		if !list[a].Created.Equal(list[b].Created) {
+			return list[a].Created.Before(list[b].Created)
+		}
+		return list[a].ID < list[b].ID
+	})
+	return list
+}
+
+// covers indica si el trabajo a incluye a b: reenviar una carpeta reenvía
+// todo lo que contiene
+func covers(a, b *Job) bool {
+	return a.Type == Transfer && b.Type == Transfer && a.To == b.To &&
+		strings.HasPrefix(b.FileName, a.FileName+string(filepath.Separator))
+}
+
+// parentLocked busca el trabajo que incluye a job; el llamador debe tener mu
+func parentLocked(job *Job) (*Job, bool) {
+	for _, other := range jobs {
+		if covers(other, job) {
+			return other, true
+		}
+	}
+	return nil, false
+}
+
+// Add encola un trabajo fallido y lo devuelve, indicando si es nuevo. Si ya
+// hay uno con la misma clave, o el envío de una carpeta que lo incluye, no
+// se duplica: se anota el último error y, si estaba fallido
+// definitivamente, vuelve a empezar sus intentos. El envío de una carpeta
+// sustituye a los de los archivos que contiene.
+func Add(job Job) (Job, bool) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	now := time.Now()
+	job.Key = Key(job.Type, job.To, job.FileName)
+	job.ID = idOf(job.Key)
+	j, ok := jobs[job.ID]
+	if !ok {
+		j, ok = parentLocked(&job)
+	}
+	if ok {
+		j.Force = j.Force || job.Force
+		j.LastError = job.LastError
+		j.Updated = now
+		if j.Status == Dead {
+			j.Status = Pending
+			j.Attempts = 0
+			j.NextRun = now.Add(delay(0))
+		}
+		save()
+		return *j, false
+	}
+	for id, other := range jobs {
+		if covers(&job, other) {
+			delete(jobs, id)
+		}
+	}
+	job.Status = Pending
+	job.Attempts = 0
+	job.Created = now
+	job.Updated = now
+	job.NextRun = now.Add(delay(0))
+	jobs[job.ID] = &job
+	save()
+	return job, true
+}
+
+// Due marca como en curso y devuelve los trabajos pendientes cuyo próximo
+// intento ya llegó; con all, todos los pendientes
+func Due(all bool) []Job {
+	mu.Lock()
+	defer mu.Unlock()
+
+	now := time.Now()
+	var due []Job
+	for _, j := range jobs {
+		if j.Status != Pending || (!all && j.NextRun.After(now)) {
+			continue
+		}
+		j.Status = Running
+		due = append(due, *j)
+	}
+	if len(due) > 0 {
+		save()
+	}
+	sort.Slice(due, func(a, b int) bool { return due[a].Created.Before(due[b].Created) })
+	return due
+}
+
+// Done quita un trabajo completado (o que ya no hace falta)
+func Done(id string) {
+	mu.Lock()
+	defer mu.Unlock()
+	if _, ok := jobs[id]; ok {
+		delete(jobs, id)
+		save()
+	}
+}
+
+// Resolve quita el trabajo con esa clave, si lo hay: otra operación ya
+// hizo lo que él tenía pendiente
+func Resolve(kind, to, name string) {
+	Done(idOf(Key(kind, to, name)))
+}
+
+// Postpone vuelve a dejar pendiente un trabajo en curso para dentro de wait,
+// sin contarlo como intento (p. ej. porque otra operación hacía lo mismo)
+func Postpone(id string, wait time.Duration) {
+	mu.Lock()
+	defer mu.Unlock()
+	if j, ok := jobs[id]; ok {
+		j.Status = Pending
+		j.Updated = time.Now()
+		j.NextRun = j.Updated.Add(wait)
+		save()
+	}
+}
+
+// Failed anota un intento fallido y programa el siguiente o, agotados los
+// intentos, da el trabajo por fallido. Devuelve cómo queda.
+func Failed(id string, err error) (Job, bool) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	j, ok := jobs[id]
+	if !ok {
+		return Job{}, false
+	}
+	now := time.Now()
+	j.Attempts++
+	j.Updated = now
+	if err != nil {
+		j.LastError = err.Error()
+	}
+	if j.Attempts >= MaxAttempts {
+		j.Status = Dead
+		j.NextRun = time.Time{}
+	} else {
+		j.Status = Pending
+		j.NextRun = now.Add(delay(j.Attempts))
+	}
+	save()
+	return *j, true
+}
+
+// Cancel quita un trabajo de la cola, esté como esté
+func Cancel(id string) (Job, error) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	j, ok := jobs[id]
+	if !ok {
+		return Job{}, fmt.Errorf("%s: %w", id, ErrNotFound)
+	}
+	delete(jobs, id)
+	save()
+	return *j, nil
+}
+
+// Requeue vuelve a poner un trabajo fallido (o pendiente) para intentarlo
+// ya, con los intentos a cero. Con id vacío reencola todos los fallidos.
+// Devuelve cuántos se reencolaron.
+func Requeue(id string) (int, error) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	now := time.Now()
+	n := 0
+	for _, j := range jobs {
+		if (id == "" && j.Status != Dead) || (id != "" && j.ID != id) || j.Status == Running {
+			continue
+		}
+		j.Status = Pending
+		j.Attempts = 0
+		j.NextRun = now
+		j.Updated = now
+		n++
+	}
+	if n == 0 && id != "" {
+		if _, ok := jobs[id]; !ok {
+			return 0, fmt.Errorf("%s: %w", id, ErrNotFound)
+		}
+	}
+	if n > 0 {
+		save()
+	}
+	return n, nil
+}
+
+// Get devuelve un trabajo por su ID
+func Get(id string) (Job, bool) {
+	mu.Lock()
+	defer mu.Unlock()
+	j, ok := jobs[id]
+	if !ok {
+		return Job{}, false
+	}
+	return *j, true
+}
+
+// List devuelve los trabajos de la cola, los más antiguos primero
+func List() []Job {
+	mu.Lock()
+	defer mu.Unlock()
+	return listLocked()
+}
+
+// Counts devuelve cuántos trabajos siguen pendientes (o en curso) y
+// cuántos fallaron definitivamente
+func Counts() (pending, dead int) {
+	mu.Lock()
+	defer mu.Unlock()
+	for _, j := range jobs {
+		if j.Status == Dead {
+			dead++
+		} else {
+			pending++
+		}
+	}
+	return pending, dead
+}
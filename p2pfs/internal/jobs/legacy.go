@@ -0,0 +1,39 @@
+package jobs
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+)
+
+// legacyTask es el formato de log/retry_queue.json de versiones anteriores
+type legacyTask struct {
+	Type     string `json:"type"`
+	FilePath string `json:"filepath"`
+	Target   string `json:"target"`
+}
+
+// ImportLegacyFile pasa a la cola los envíos de un retry_queue.json antiguo
+// y borra el archivo. Devuelve cuántos se importaron.
+func ImportLegacyFile(path string) (int, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return 0, nil
+		}
+		return 0, err
+	}
+	var tasks []legacyTask
+	if err := json.Unmarshal(data, &tasks); err != nil {
+		return 0, fmt.Errorf("formato inválido en %s: %v", path, err)
+	}
+	n := 0
+	for _, t := range tasks {
+		if t.Type != Transfer {
+			continue
+		}
+		Add(Job{Type: t.Type, FileName: t.FilePath, To: t.Target, LastError: "importado de la cola anterior"})
+		n++
+	}
+	return n, os.Remove(path)
+}
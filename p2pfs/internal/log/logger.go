@@ -24,7 +24,8 @@ type Operation struct {
 }
 
 
-var logFile = "log/oplog.json"
+// LogFile es la ruta del registro de operaciones; la fija la configuración del nodo
+var LogFile = "log/oplog.json"
 var mu sync.Mutex // para acceso concurrente seguro
 
 // AppendToLocalLog agrega una operación al registro local
@@ -32,7 +33,7 @@ func AppendToLocalLog(op Operation) {
 	mu.Lock()
 	defer mu.Unlock()
 
-	ops := ReadLocalLog()
+	ops := readLocalLog()
 
 	ops = append(ops, op)
 
@@ -41,14 +42,35 @@ func AppendToLocalLog(op Operation) {
 	}
 }
 
+// Flush espera a que termine la escritura del registro que esté en curso y
+// lo fuerza a disco; se llama antes de salir para no perder lo último
+func Flush() error {
+	mu.Lock()
+	defer mu.Unlock()
+
+	f, err := os.Open(LogFile)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return f.Sync()
+}
+
 // ReadLocalLog devuelve todas las operaciones registradas localmente
 func ReadLocalLog() []Operation {
 	mu.Lock()
 	defer mu.Unlock()
+	return readLocalLog()
+}
 
+// readLocalLog lee el archivo de log; el llamador debe tener mu
+func readLocalLog() []Operation {
 	var ops []Operation
 
-	data, err := os.ReadFile(logFile)
+	data, err := os.ReadFile(LogFile)
 	if err != nil {
 		// Si no existe, retornamos vacío
 		return ops
@@ -63,7 +85,7 @@ func ReadLocalLog() []Operation {
 
 // saveLogToFile sobrescribe el archivo de log con el contenido dado
 func saveLogToFile(ops []Operation) error {
-	dir := filepath.Dir(logFile)
+	dir := filepath.Dir(LogFile)
 	if err := os.MkdirAll(dir, 0755); err != nil {
 		return err
 	}
@@ -73,6 +95,6 @@ func saveLogToFile(ops []Operation) error {
 		return err
 	}
 
-	return os.WriteFile(logFile, data, 0644)
+	return os.WriteFile(LogFile, data, 0644)
 }
 
@@ -3,13 +3,23 @@ package message
 import "p2pfs/internal/fs"
 
 type Message struct {
-	Type      string        `json:"type"`               // TRANSFER, DELETE, LIST, etc.
-	From      string        `json:"from"`               // Nodo origen
-	Origin    string        `json:"origin,omitempty"`   // Nodo origen original si es relay
-	FileName  string        `json:"filename,omitempty"` // Nombre del archivo
-	Path      string        `json:"path,omitempty"`     // Ruta completa (sync)
-	Data      []byte        `json:"data,omitempty"`     // Payload (opcional)
-	FileTree  *fs.FileNode  `json:"filetree,omitempty"` // Árbol de archivos (LIST)
-	Timestamp int64         `json:"timestamp"`
+	Type      string           `json:"type"`                // TRANSFER, DELETE, LIST, etc.
+	From      string           `json:"from"`                // Nodo origen
+	Origin    string           `json:"origin,omitempty"`    // Nodo origen original si es relay
+	FileName  string           `json:"filename,omitempty"`  // Nombre del archivo
+	Path      string           `json:"path,omitempty"`      // Ruta completa (sync)
+	Data      []byte           `json:"data,omitempty"`      // Payload (opcional)
+	FileTree  *fs.FileNode     `json:"filetree,omitempty"`  // Árbol de archivos (LIST)
+	Hash      string           `json:"hash,omitempty"`      // SHA-256 de Data (TRANSFER)
+	Force     bool             `json:"force,omitempty"`     // Sobrescribir aunque la copia local sea más reciente
+	Meta      *fs.Meta         `json:"meta,omitempty"`      // Permisos, fecha y enlace del archivo (TRANSFER)
+	Encoding  string           `json:"encoding,omitempty"`  // Compresión de Data; Hash es del contenido sin comprimir
+	RawSize   int64            `json:"raw_size,omitempty"`  // Tamaño de Data sin comprimir (con Encoding)
+	Accept    []string         `json:"accept,omitempty"`    // Compresiones que el remitente sabe descomprimir
+	Size      int64            `json:"size,omitempty"`      // Tamaño del archivo (CHUNKS)
+	Chunks    []string         `json:"chunks,omitempty"`    // SHA-256 de cada bloque (CHUNKS)
+	Index     int              `json:"index,omitempty"`     // Número de bloque (REQUEST_CHUNK, CHUNK)
+	Free      map[string]int64 `json:"free,omitempty"`      // Espacio libre por carpeta (LIST)
+	NotFound  bool             `json:"not_found,omitempty"` // El archivo pedido no existe (ERROR)
+	Timestamp int64            `json:"timestamp"`
 }
-
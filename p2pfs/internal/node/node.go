@@ -0,0 +1,264 @@
+// Package node arranca los componentes de red de un nodo P2PFS,
+// independientemente de si se muestra la GUI o se ejecuta como servicio.
+package node
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"p2pfs/internal/bandwidth"
+	"p2pfs/internal/compress"
+	"p2pfs/internal/config"
+	"p2pfs/internal/fs"
+	"p2pfs/internal/jobs"
+	logger "p2pfs/internal/log"
+	"p2pfs/internal/peer"
+	"p2pfs/internal/share"
+	"p2pfs/internal/state"
+	"p2pfs/internal/supervisor"
+)
+
+// Node es un nodo en ejecución
+type Node struct {
+	Self      *peer.Peer
+	Discovery peer.Discovery
+	Config    *config.Config
+	StartedAt time.Time
+
+	tasks   *supervisor.Supervisor
+	stopLog func()        // deja de seguir las transferencias en consola
+	logDone chan struct{} // se cierra cuando LogTransfers termina
+}
+
+// apply traslada la configuración a los paquetes que la usan
+func apply(cfg *config.Config) {
+	peer.PeersFile = cfg.PeersFile
+	peer.SeedsFile = cfg.SeedsFile
+	peer.PeerExpiry = cfg.PeerExpiry
+	peer.SeedRefreshInterval = cfg.SeedRefresh
+	peer.DiscoveryMode = cfg.Discovery
+	peer.DiscoveryInterface = cfg.DiscoveryInterface
+	peer.BroadcastPort = cfg.DiscoveryPort
+	peer.BroadcastInterval = cfg.AnnounceInterval
+	peer.MaxRetries = cfg.MaxRetries
+	peer.DialTimeout = cfg.DialTimeout
+	peer.RequestTimeout = cfg.RequestTimeout
+	compress.Configure(cfg.Compression)
+	compress.MaxSize, _ = bandwidth.ParseRate(cfg.MaxDecompressed)
+	bandwidth.Configure(cfg.Bandwidth())
+	peer.MaxTransfers = cfg.MaxTransfers
+	peer.MaxPeerTransfers = cfg.MaxPeerTransfers
+	peer.RepairAfter = cfg.RepairAfter
+	state.StateFile = cfg.StateFile
+	jobs.File = cfg.JobsFile
+	jobs.Backoff = cfg.RetryBackoff
+	jobs.MaxBackoff = cfg.RetryMaxWait
+	jobs.MaxAttempts = cfg.RetryAttempts
+	logger.LogFile = cfg.OplogFile
+	share.Configure(cfg.Shares)
+}
+
+// Start aplica la configuración y arranca descubrimiento, listener TCP,
+// reintentos, sincronización y persistencia de peers; espera a que el nodo
+// tenga un ID, salvo que se cancele ctx. Las tareas de fondo se detienen al
+// cancelar ctx o con Shutdown, que hay que llamar en ambos casos.
+func Start(ctx context.Context, cfg *config.Config) *Node {
+	apply(cfg)
+	if cfg.File != "" {
+		fmt.Println("⚙️ Configuración cargada de", cfg.File)
+	}
+	for _, s := range cfg.Shares {
+		if err := os.MkdirAll(s.Path, 0755); err != nil {
+			fmt.Printf("⚠️ No se pudo crear la carpeta compartida %s: %v\n", s.Name, err)
+		}
+		// Temporales de escrituras que una caída dejó a medias
+		if n, err := fs.CleanTemp(s.Path); err != nil {
+			fmt.Printf("⚠️ Error al limpiar temporales de %s: %v\n", s.Name, err)
+		} else if n > 0 {
+			fmt.Printf("🧹 %d temporal(es) de escrituras interrumpidas eliminados de %s\n", n, s.Name)
+		}
+	}
+
+	if err := state.LoadState(); err != nil {
+		fmt.Println("⚠️ Error al cargar el estado:", err)
+	}
+	loadJobs(cfg)
+
+	// Crear nodo sin ID asignado aún
+	self := peer.NewPeer(0, cfg.Port, nil)
+	fmt.Println("Esta máquina tiene IP:", self.IP, "- direcciones:", strings.Join(self.Addrs, ", "))
+
+	// 📡 Mecanismo de descubrimiento en la red local
+	d, err := peer.NewDiscovery(cfg.Discovery)
+	if err != nil {
+		fmt.Println("⚠️", err, "- se usa broadcast")
+		d = &peer.BroadcastDiscovery{}
+	}
+	peer.ActiveDiscovery = d
+
+	n := &Node{
+		Self:      self,
+		Discovery: d,
+		Config:    cfg,
+		StartedAt: time.Now(),
+		tasks:     supervisor.New(ctx),
+		logDone:   make(chan struct{}),
+	}
+	self.Tasks = n.tasks
+
+	// 📒 Contactar primero a los peers conocidos de ejecuciones anteriores
+	self.LoadKnownPeers(peer.PeersFile)
+
+	// 🌱 Unirse a través de semillas (otras subredes o VPN)
+	seeds, err := peer.LoadSeedsFromFile(cfg.SeedsFile)
+	if err != nil {
+		fmt.Println("⚠️ No se pudo cargar la lista de semillas:", err)
+	}
+	seeds = append(append([]string{}, cfg.Joins...), seeds...)
+	if len(seeds) > 0 {
+		self.JoinSeeds(seeds)
+		n.tasks.Go("semillas", func(ctx context.Context) {
+			self.RejoinSeeds(ctx, seeds, peer.SeedRefreshInterval)
+		})
+	}
+
+	if self.GetID() != 0 {
+		// ID recuperado o asignado por una semilla: anunciarnos sin esperar ASSIGN_ID
+		peer.BroadcastNewNode(self.Announcement("NEW_NODE"))
+	}
+
+	// 📊 Avance de las transferencias largas en consola; sigue hasta que
+	// terminan las que quedan al detener el nodo
+	transferEvents, stopLog := peer.SubscribeTransfers()
+	n.stopLog = stopLog
+	go func() {
+		defer close(n.logDone)
+		peer.LogTransfers(transferEvents)
+	}()
+
+	// 🔊 Listeners y tareas de red
+	n.tasks.Go("descubrimiento", func(ctx context.Context) { d.Listen(ctx, self) })
+	n.tasks.Go("HELLO", func(ctx context.Context) { peer.BroadcastHello(ctx, self) })
+	n.tasks.Go("listener", self.StartListener)
+	n.tasks.Go("reintentos", func(ctx context.Context) { self.RetryWorker(ctx, cfg.RetryInterval) })
+	n.tasks.Go("sincronización", func(ctx context.Context) { self.MonitorPeersAndSync(ctx, cfg.SyncInterval) })
+	n.tasks.Go("replicación", func(ctx context.Context) { self.RepairWorker(ctx, cfg.RepairInterval) })
+	n.tasks.Go("peers", func(ctx context.Context) { self.PersistPeers(ctx, peer.PeersFile, cfg.PersistInterval) })
+	n.tasks.Go("versiones", func(ctx context.Context) { share.Prune(ctx, time.Hour) })
+
+	// ⏱️ Esperar ID o asignarlo
+	select {
+	case <-ctx.Done():
+		fmt.Println("🛑 Arranque interrumpido")
+		return n
+	case <-time.After(cfg.IDTimeout):
+	}
+	if self.ClaimID(1) {
+		fmt.Println("⚠️  No se recibió ASSIGN_ID. Asignando ID=1 como nodo inicial.")
+
+		// 🔈 Anunciar el nodo
+		peer.BroadcastNewNode(self.Announcement("NEW_NODE"))
+	}
+
+	// ✅ Asegurar inclusión propia si ya fue asignado por otro
+	alreadyPresent := false
+	for _, p := range self.Peers.Snapshot() {
+		if self.IsSelf(p) {
+			alreadyPresent = true
+			break
+		}
+	}
+	if !alreadyPresent {
+		self.AddPeer(peer.PeerInfo{
+			ID:    self.GetID(),
+			IP:    self.IP,
+			Port:  self.Port,
+			Addrs: self.Addrs,
+		})
+	}
+
+	return n
+}
+
+// loadJobs carga la cola de reintentos y le pasa las tareas de las colas
+// de versiones anteriores (en state.json y en log/retry_queue.json)
+func loadJobs(cfg *config.Config) {
+	if err := jobs.Load(); err != nil {
+		fmt.Println("⚠️ Error al cargar la cola de reintentos:", err)
+	}
+	migrated := 0
+	for _, t := range state.TakeRetryQueue() {
+		if t.Type != jobs.Transfer {
+			continue
+		}
+		jobs.Add(jobs.Job{Type: t.Type, FileName: t.FileName, To: t.To, LastError: "importado de la cola anterior"})
+		migrated++
+	}
+	n, err := jobs.ImportLegacyFile(filepath.Join(filepath.Dir(cfg.OplogFile), "retry_queue.json"))
+	if err != nil {
+		fmt.Println("⚠️ Error al importar la cola de reintentos anterior:", err)
+	}
+	if migrated += n; migrated > 0 {
+		fmt.Printf("📦 %d tarea(s) de la cola de reintentos anterior importadas\n", migrated)
+	}
+	if pending, dead := jobs.Counts(); pending+dead > 0 {
+		fmt.Printf("🕓 %d trabajo(s) pendientes de reintentar y %d fallido(s)\n", pending, dead)
+	}
+}
+
+// shutdownGrace es lo que se espera a las transferencias interrumpidas al
+// agotarse shutdown_timeout
+const shutdownGrace = 5 * time.Second
+
+// Shutdown detiene las tareas de fondo, deja terminar las transferencias en
+// curso durante shutdown_timeout como mucho (después las interrumpe) y
+// guarda peers, estado, cola de reintentos y registro antes de salir
+func (n *Node) Shutdown() {
+	deadline := time.Now().Add(n.Config.ShutdownTimeout)
+	fmt.Println("🛑 Deteniendo tareas de fondo...")
+
+	// Las tareas dejan de empezar trabajo nuevo; el listener espera a las
+	// conexiones abiertas y los reintentos a los envíos que ya hacían
+	stuck := n.tasks.Stop(time.Until(deadline))
+	if active := len(peer.ActiveTransfers()); active > 0 {
+		fmt.Printf("⏳ Esperando a %d transferencia(s) en curso...\n", active)
+	}
+	left := peer.DrainTransfers(time.Until(deadline))
+
+	// Lo que no terminó a tiempo se interrumpe; los envíos quedan en la cola
+	// de reintentos para la próxima vez
+	peer.StopTransfers()
+	if left > 0 || len(stuck) > 0 {
+		fmt.Printf("⚠️ Se interrumpen %d transferencia(s) sin terminar\n", left)
+		peer.DrainTransfers(shutdownGrace)
+		if stuck = n.tasks.Stop(shutdownGrace); len(stuck) > 0 {
+			fmt.Println("⚠️ Tareas que no terminaron a tiempo:", strings.Join(stuck, ", "))
+		}
+	}
+	n.stopLog()
+	<-n.logDone
+
+	if err := n.Self.SaveKnownPeers(peer.PeersFile); err != nil {
+		fmt.Println("⚠️ Error al guardar peers:", err)
+	}
+	if err := state.SaveState(); err != nil {
+		fmt.Println("⚠️ Error al guardar estado:", err)
+	}
+	if err := jobs.Save(); err != nil {
+		fmt.Println("⚠️ Error al guardar la cola de reintentos:", err)
+	}
+	logger.AppendToLocalLog(logger.Operation{
+		Type:      "SHUTDOWN",
+		From:      n.Self.Addr(),
+		Timestamp: time.Now().Unix(),
+		Message:   "Nodo detenido",
+	})
+	if err := logger.Flush(); err != nil {
+		fmt.Println("⚠️ Error al guardar el registro:", err)
+	}
+	fmt.Println("👋 Nodo detenido")
+}
@@ -0,0 +1,189 @@
+package peer
+
+import (
+	"net"
+	"sync"
+	"time"
+)
+
+// preferredHosts recuerda, por peer, la dirección que respondió por última
+// vez (clave: ip:puerto principal del peer)
+var (
+	preferredHosts = make(map[string]string)
+	addrMutex      sync.Mutex
+)
+
+// GetLocalIP retorna la dirección de la interfaz por la que sale la ruta
+// por defecto; en hosts con varias interfaces es la más probable de ser
+// alcanzable por otros nodos.
+func GetLocalIP() string {
+	// UDP no envía nada al "conectar": solo se consulta la tabla de rutas
+	for _, probe := range []string{"192.0.2.1:9", "[2001:db8::1]:9"} {
+		conn, err := net.Dial("udp", probe)
+		if err != nil {
+			continue
+		}
+		local := conn.LocalAddr().(*net.UDPAddr).IP
+		conn.Close()
+		if !local.IsLoopback() && !local.IsUnspecified() {
+			return local.String()
+		}
+	}
+
+	if ips := GetLocalIPs(); len(ips) > 0 {
+		return ips[0]
+	}
+	return "127.0.0.1"
+}
+
+// GetLocalIPs retorna todas las direcciones unicast no loopback del host,
+// primero IPv4 y luego IPv6 global. Las IPv6 de enlace local se omiten
+// porque requieren una zona que solo tiene sentido en este host.
+func GetLocalIPs() []string {
+	addrs, err := net.InterfaceAddrs()
+	if err != nil {
+		return nil
+	}
+
+	var v4, v6 []string
+	for _, addr := range addrs {
+		ipnet, ok := addr.(*net.IPNet)
+		if !ok || ipnet.IP.IsLoopback() || ipnet.IP.IsLinkLocalUnicast() {
+			continue
+		}
+		if ipnet.IP.To4() != nil {
+			v4 = append(v4, ipnet.IP.String())
+		} else if ipnet.IP.IsGlobalUnicast() {
+			v6 = append(v6, ipnet.IP.String())
+		}
+	}
+	return append(v4, v6...)
+}
+
+// localBroadcastAddrs retorna la dirección de broadcast dirigida de cada
+// subred IPv4 local, para alcanzar todas las interfaces de un host multi-homed.
+func localBroadcastAddrs() []net.IP {
+	addrs, err := net.InterfaceAddrs()
+	if err != nil {
+		return nil
+	}
+
+	var result []net.IP
+	for _, addr := range addrs {
+		ipnet, ok := addr.(*net.IPNet)
+		if !ok || ipnet.IP.IsLoopback() {
+			continue
+		}
+		ip4 := ipnet.IP.To4()
+		mask := ipnet.Mask
+		if ip4 == nil || len(mask) != net.IPv4len {
+			continue
+		}
+		bcast := make(net.IP, net.IPv4len)
+		for i := range ip4 {
+			bcast[i] = ip4[i] | ^mask[i]
+		}
+		result = append(result, bcast)
+	}
+	return result
+}
+
+// Addr retorna la dirección principal del nodo local (host:puerto)
+func (p *Peer) Addr() string {
+	return net.JoinHostPort(p.IP, p.Port)
+}
+
+// CacheKey es la clave de un peer en la caché de archivos remotos del
+// estado: el host:puerto con el que está registrado, como en el registro
+func CacheKey(info PeerInfo) string {
+	return peerKey(info)
+}
+
+// IsSelf indica si info corresponde al nodo local en cualquiera de sus direcciones
+func (p *Peer) IsSelf(info PeerInfo) bool {
+	if info.Port != p.Port {
+		return false
+	}
+	if info.IP == p.IP {
+		return true
+	}
+	for _, host := range p.Addrs {
+		if info.IP == host {
+			return true
+		}
+	}
+	return false
+}
+
+// candidateHosts lista las direcciones de un peer, empezando por la que
+// funcionó la última vez
+func candidateHosts(info PeerInfo) []string {
+	addrMutex.Lock()
+	preferred := preferredHosts[net.JoinHostPort(info.IP, info.Port)]
+	addrMutex.Unlock()
+
+	seen := make(map[string]bool)
+	var hosts []string
+	for _, host := range append([]string{preferred, info.IP}, info.Addrs...) {
+		if host != "" && !seen[host] {
+			seen[host] = true
+			hosts = append(hosts, host)
+		}
+	}
+	return hosts
+}
+
+// PeerAddr retorna host:puerto con la mejor dirección conocida del peer
+func PeerAddr(info PeerInfo) string {
+	hosts := candidateHosts(info)
+	if len(hosts) == 0 {
+		return net.JoinHostPort(info.IP, info.Port)
+	}
+	return net.JoinHostPort(hosts[0], info.Port)
+}
+
+// ResolvePeerAddr prueba las direcciones del peer en orden y recuerda la
+// primera que acepta conexiones.
+func ResolvePeerAddr(info PeerInfo, timeout time.Duration) (string, bool) {
+	key := net.JoinHostPort(info.IP, info.Port)
+	for _, host := range candidateHosts(info) {
+		address := net.JoinHostPort(host, info.Port)
+		conn, err := net.DialTimeout("tcp", address, timeout)
+		if err != nil {
+			continue
+		}
+		conn.Close()
+
+		addrMutex.Lock()
+		preferredHosts[key] = host
+		addrMutex.Unlock()
+		return address, true
+	}
+	return key, false
+}
+
+// mergeHosts agrega a dst las direcciones de src que aún no contiene
+func mergeHosts(dst []string, src ...string) []string {
+	for _, host := range src {
+		found := false
+		for _, existing := range dst {
+			if existing == host {
+				found = true
+				break
+			}
+		}
+		if !found && host != "" {
+			dst = append(dst, host)
+		}
+	}
+	return dst
+}
+
+// parsePeerAddr convierte "host:puerto" (o "[v6]:puerto") en PeerInfo
+func parsePeerAddr(addr string) (PeerInfo, error) {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return PeerInfo{}, err
+	}
+	return PeerInfo{IP: host, Port: port}, nil
+}
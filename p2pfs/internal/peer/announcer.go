@@ -1,51 +1,81 @@
 package peer
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"net"
-	"os"
 	"strconv"
 	"time"
 )
 
-var BroadcastPort = getEnvOrDefault("DISCOVERY_PORT", "48999")
-const BroadcastInterval = 5 * time.Second
+// BroadcastPort y BroadcastInterval los fija la configuración del nodo
+var BroadcastPort = "48999"
+var BroadcastInterval = 5 * time.Second
 
-// BroadcastHello emite periódicamente un mensaje HELLO por UDP broadcast
-func BroadcastHello(self *Peer) {
-	addr := net.UDPAddr{
-		IP:   net.IPv4bcast,
-		Port: mustParsePort(BroadcastPort),
+// BroadcastDiscovery descubre nodos con broadcast UDP limitado (255.255.255.255)
+type BroadcastDiscovery struct{}
+
+func (d *BroadcastDiscovery) Name() string { return "broadcast" }
+
+// Announce envía el anuncio a toda la subred local y al broadcast dirigido
+// de cada interfaz IPv4, para hosts con varias tarjetas de red
+func (d *BroadcastDiscovery) Announce(msg NodeAnnouncement) error {
+	data, _ := json.Marshal(msg)
+	port := mustParsePort(BroadcastPort)
+
+	var lastErr error
+	sent := false
+	for _, ip := range append([]net.IP{net.IPv4bcast}, localBroadcastAddrs()...) {
+		conn, err := net.DialUDP("udp4", nil, &net.UDPAddr{IP: ip, Port: port})
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		if _, err := conn.Write(data); err != nil {
+			lastErr = err
+		} else {
+			sent = true
+		}
+		conn.Close()
 	}
-	conn, err := net.DialUDP("udp", nil, &addr)
-	if err != nil {
-		fmt.Println("Error al emitir broadcast HELLO:", err)
-		return
+	if sent {
+		return nil
 	}
-	defer conn.Close()
+	return lastErr
+}
 
-	for {
-		if self.ID == 0 {
-			msg := NodeAnnouncement{
-				Type: "HELLO",
-				IP:   self.IP,
-				Port: self.Port,
-			}
-			data, _ := json.Marshal(msg)
+// Listen recibe los anuncios difundidos por broadcast
+func (d *BroadcastDiscovery) Listen(ctx context.Context, self *Peer) {
+	ListenForBroadcasts(ctx, self, func() []PeerInfo {
+		return self.Peers.Snapshot()
+	})
+}
 
-			_, err := conn.Write(data)
-			if err == nil {
-				self.LastHelloSent = time.Now()
-				fmt.Println("📣 Enviado HELLO desde", self.IP+":"+self.Port)
+// BroadcastHello emite periódicamente un mensaje HELLO mientras el nodo no
+// tenga ID, hasta que se cancela ctx
+func BroadcastHello(ctx context.Context, self *Peer) {
+	for {
+		if self.GetID() == 0 {
+			msg := self.Announcement("HELLO")
+			if err := ActiveDiscovery.Announce(msg); err != nil {
+				fmt.Println("Error al emitir HELLO:", err)
+			} else {
+				self.markHelloSent()
+				fmt.Printf("📣 Enviado HELLO (%s) desde %s\n", ActiveDiscovery.Name(), self.Addr())
 			}
 		}
-		time.Sleep(BroadcastInterval)
+		select {
+		case <-time.After(BroadcastInterval):
+		case <-ctx.Done():
+			return
+		}
 	}
 }
 
 // ListenForBroadcasts escucha mensajes por UDP (HELLO, ASSIGN_ID, NEW_NODE)
-func ListenForBroadcasts(self *Peer, getPeerList func() []PeerInfo) {
+// hasta que se cancela ctx
+func ListenForBroadcasts(ctx context.Context, self *Peer, getPeerList func() []PeerInfo) {
 	addr := net.UDPAddr{
 		IP:   net.IPv4zero,
 		Port: mustParsePort(BroadcastPort),
@@ -56,14 +86,19 @@ func ListenForBroadcasts(self *Peer, getPeerList func() []PeerInfo) {
 		return
 	}
 	defer conn.Close()
+	defer closeOnCancel(ctx, conn)()
 
 	buf := make([]byte, 1024)
 	for {
 		n, sender, err := conn.ReadFromUDP(buf)
 		if err != nil {
+			if ctx.Err() != nil {
+				return
+			}
 			continue
 		}
-		go handleBroadcastMessage(buf[:n], sender, self, getPeerList)
+		data := append([]byte(nil), buf[:n]...)
+		go handleBroadcastMessage(data, sender, self, getPeerList)
 	}
 }
 
@@ -82,9 +117,3 @@ func mustParsePort(p string) int {
 	return port
 }
 
-func getEnvOrDefault(key, fallback string) string {
-	if value, ok := os.LookupEnv(key); ok {
-		return value
-	}
-	return fallback
-}
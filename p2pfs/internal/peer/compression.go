@@ -0,0 +1,102 @@
+package peer
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+
+	"p2pfs/internal/compress"
+	"p2pfs/internal/message"
+)
+
+// Compresiones que acepta cada peer (por dirección host:puerto), aprendidas
+// de sus respuestas a LIST, REQUEST_FILE y TRANSFER. Mientras no se sabe
+// nada de un peer se le envía sin comprimir.
+var (
+	encodingsMu sync.RWMutex
+	encodings   = make(map[string][]string)
+)
+
+// learnEncodings recuerda lo que addr anunció que acepta
+func learnEncodings(addr string, accept []string) {
+	encodingsMu.Lock()
+	encodings[addr] = accept
+	encodingsMu.Unlock()
+}
+
+func peerEncodings(addr string) []string {
+	encodingsMu.RLock()
+	defer encodingsMu.RUnlock()
+	return encodings[addr]
+}
+
+// encodeData comprime el contenido del archivo name para un nodo que acepta
+// accept. Devuelve data tal cual (y None) si no hay compresión en común, no
+// compensa o el resultado no es más pequeño.
+func encodeData(name string, data []byte, accept []string) ([]byte, string) {
+	enc := compress.Negotiate(accept)
+	if enc == compress.None || !compress.Worth(name, data) {
+		return data, compress.None
+	}
+	packed, err := compress.Encode(enc, data)
+	if err != nil || len(packed) >= len(data) {
+		return data, compress.None
+	}
+	return packed, enc
+}
+
+// decodeData devuelve el contenido sin comprimir de un mensaje, sin pasar
+// del tamaño que declara ni de compress.MaxSize
+func decodeData(msg message.Message) ([]byte, error) {
+	if msg.Encoding == compress.None {
+		return msg.Data, nil
+	}
+	limit := compress.MaxSize
+	if msg.RawSize > 0 && (limit <= 0 || msg.RawSize < limit) {
+		limit = msg.RawSize
+	}
+	data, err := compress.Decode(msg.Encoding, msg.Data, limit)
+	if err != nil {
+		return nil, fmt.Errorf("no se pudo descomprimir %s: %v", msg.FileName, err)
+	}
+	return data, nil
+}
+
+// describeEncoding resume la compresión de un envío para el registro
+func describeEncoding(enc string, raw, sent int) string {
+	if enc == compress.None {
+		return ""
+	}
+	return fmt.Sprintf(" (%s, %d → %d bytes)", enc, raw, sent)
+}
+
+// ackTransfer responde a un TRANSFER con las compresiones que acepta este
+// nodo, para que el emisor comprima los siguientes envíos
+func (p *Peer) ackTransfer(conn net.Conn) {
+	data, _ := json.Marshal(message.Message{
+		Type:   "ACK",
+		From:   strconv.Itoa(p.GetID()),
+		Accept: compress.Supported,
+	})
+	conn.Write(data)
+}
+
+// readAck lee la respuesta de un TRANSFER enviado a addr. Los nodos
+// anteriores cierran sin responder: se anota que no aceptan compresión.
+func readAck(conn net.Conn, addr string) {
+	conn.SetReadDeadline(time.Now().Add(RequestTimeout))
+	data, err := io.ReadAll(conn)
+	if err != nil {
+		return
+	}
+	var ack message.Message
+	if len(data) > 0 && json.Unmarshal(data, &ack) == nil && ack.Type == "ACK" {
+		learnEncodings(addr, ack.Accept)
+		return
+	}
+	learnEncodings(addr, nil)
+}
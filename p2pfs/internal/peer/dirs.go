@@ -0,0 +1,150 @@
+package peer
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"p2pfs/internal/fs"
+	logger "p2pfs/internal/log"
+	"p2pfs/internal/message"
+	"p2pfs/internal/share"
+)
+
+// sendDir replica la carpeta rel en addr archivo por archivo. Lo que el
+// peer ya tiene con la misma fecha no se vuelve a enviar (salvo con force)
+// y las carpetas vacías se crean con MKDIR. Cada archivo que falla queda
+// en la cola de reintentos.
+func (p *Peer) sendDir(s *share.Share, rel, addr string, force bool, prio Priority) error {
+	tree, err := fs.BuildFileTree(s.LocalPath(rel), fs.TreeOptions{
+		Skip: func(sub string, isDir bool) bool {
+			return s.Ignored(path.Join(rel, sub), isDir)
+		},
+		Links: s.Symlinks,
+	})
+	if err != nil {
+		return fmt.Errorf("no se pudo recorrer %s: %v", s.Key(rel), err)
+	}
+
+	// Lo que ya tiene el peer; si no ofrece la carpeta se envía todo
+	var remote map[string]fs.FileNode
+	if !force {
+		if remoteTree, err := p.RequestFileTree(addr); err == nil && remoteTree != nil {
+			for _, child := range remoteTree.Children {
+				if child.Name == s.Name {
+					remote = fs.FlattenTreePaths(child)
+				}
+			}
+		}
+	}
+
+	files := fs.FlattenTreePaths(tree)
+	subs := make([]string, 0, len(files))
+	for sub := range files {
+		subs = append(subs, sub)
+	}
+	sort.Strings(subs)
+
+	sent, skipped := 0, 0
+	var errs []string
+	for _, sub := range subs {
+		f := files[sub]
+		full := path.Join(rel, sub)
+		if r, ok := remote[full]; ok && r.ModTime.Equal(f.ModTime) && r.Link == f.Link {
+			skipped++
+			continue
+		}
+		if err := p.sendFile(s.LocalPath(full), addr, force, prio); err != nil {
+			errs = append(errs, fmt.Sprintf("%s: %v", s.Key(full), err))
+			continue
+		}
+		sent++
+	}
+
+	empty := fs.FlattenTreeDirs(tree)
+	if len(tree.Children) == 0 {
+		empty[""] = tree
+	}
+	for sub, d := range empty {
+		if len(d.Children) > 0 {
+			continue
+		}
+		name := s.Key(path.Join(rel, sub))
+		if err := p.sendMkdir(addr, name, fs.Meta{Mode: d.Mode, ModTime: d.ModTime}); err != nil {
+			errs = append(errs, fmt.Sprintf("%s: %v", name, err))
+		}
+	}
+
+	logger.AppendToLocalLog(logger.Operation{
+		Type:      "DIR_TRANSFER",
+		FileName:  s.Key(rel),
+		From:      p.Addr(),
+		Timestamp: time.Now().Unix(),
+		Message:   fmt.Sprintf("Carpeta enviada a %s: %d archivo(s) enviados, %d ya al día, %d error(es)", addr, sent, skipped, len(errs)),
+	})
+	fmt.Printf("📂 %s enviada a %s: %d archivo(s) enviados, %d ya al día\n", s.Key(rel), addr, sent, skipped)
+	if len(errs) > 0 {
+		return fmt.Errorf("%s", strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+// sendMkdir pide a addr que cree la carpeta name ("carpeta/ruta")
+func (p *Peer) sendMkdir(addr, name string, meta fs.Meta) error {
+	data, _ := json.Marshal(message.Message{
+		Type:      "MKDIR",
+		From:      strconv.Itoa(p.GetID()),
+		FileName:  name,
+		Meta:      &meta,
+		Timestamp: time.Now().Unix(),
+	})
+	conn, err := net.DialTimeout("tcp", addr, DialTimeout)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	if _, err := conn.Write(data); err != nil {
+		return err
+	}
+	return closeWrite(conn)
+}
+
+// handleMkdir crea una carpeta enviada por otro nodo
+func (p *Peer) handleMkdir(conn net.Conn, msg message.Message) {
+	s, rel, local, err := p.incomingShare(conn, msg)
+	switch {
+	case err != nil:
+	case !s.CanReceive():
+		err = fmt.Errorf("%s no acepta cambios remotos", s.Name)
+	case s.Ignored(rel, true):
+		err = fmt.Errorf("%s está ignorado", msg.FileName)
+	}
+	if err != nil {
+		fmt.Printf("⚠️ Carpeta rechazada: %v\n", err)
+		return
+	}
+	if _, err := os.Lstat(local); err == nil {
+		return
+	}
+	if err := os.MkdirAll(local, 0755); err != nil {
+		fmt.Printf("❌ Error al crear la carpeta %s: %v\n", msg.FileName, err)
+		return
+	}
+	if msg.Meta != nil {
+		fs.ApplyMeta(local, *msg.Meta)
+	}
+	fmt.Printf("📁 Carpeta %s creada\n", msg.FileName)
+	logger.AppendToLocalLog(logger.Operation{
+		Type:      "MKDIR",
+		FileName:  msg.FileName,
+		From:      conn.RemoteAddr().String(),
+		Timestamp: time.Now().Unix(),
+		Message:   "Carpeta creada por petición remota",
+	})
+}
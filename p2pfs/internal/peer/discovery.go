@@ -0,0 +1,177 @@
+package peer
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+)
+
+// Discovery es un mecanismo para encontrar otros nodos en la red local.
+type Discovery interface {
+	// Name identifica el mecanismo en logs y en la configuración
+	Name() string
+	// Listen recibe anuncios de otros nodos; bloquea hasta que se cancela ctx
+	Listen(ctx context.Context, self *Peer)
+	// Announce difunde un anuncio (HELLO, ASSIGN_ID, NEW_NODE)
+	Announce(msg NodeAnnouncement) error
+}
+
+// DiscoveryMode selecciona el mecanismo por defecto (ver NewDiscovery)
+var DiscoveryMode = "broadcast"
+
+// DiscoveryInterface restringe multicast/mDNS a una interfaz de red concreta
+var DiscoveryInterface = ""
+
+// ActiveDiscovery es el mecanismo usado por BroadcastHello y BroadcastNewNode
+var ActiveDiscovery Discovery = &BroadcastDiscovery{}
+
+// Grupos multicast por defecto para el protocolo de anuncios JSON
+const (
+	MulticastGroupV4 = "239.255.48.99"
+	MulticastGroupV6 = "ff02::4899"
+)
+
+// NewDiscovery construye el mecanismo indicado. Acepta "broadcast",
+// "multicast", "multicast6", "mdns", "mdns6" o varios separados por comas.
+func NewDiscovery(mode string) (Discovery, error) {
+	var ifi *net.Interface
+	if DiscoveryInterface != "" {
+		var err error
+		ifi, err = net.InterfaceByName(DiscoveryInterface)
+		if err != nil {
+			return nil, fmt.Errorf("interfaz %s: %v", DiscoveryInterface, err)
+		}
+	}
+
+	var all multiDiscovery
+	for _, name := range strings.Split(mode, ",") {
+		switch strings.TrimSpace(name) {
+		case "broadcast":
+			all = append(all, &BroadcastDiscovery{})
+		case "multicast":
+			all = append(all, &MulticastDiscovery{Group: MulticastGroupV4, Interface: ifi})
+		case "multicast6":
+			all = append(all, &MulticastDiscovery{Group: MulticastGroupV6, Interface: ifi})
+		case "mdns":
+			all = append(all, &MDNSDiscovery{Interface: ifi})
+		case "mdns6":
+			all = append(all, &MDNSDiscovery{IPv6: true, Interface: ifi})
+		default:
+			return nil, fmt.Errorf("mecanismo de descubrimiento desconocido: %q", name)
+		}
+	}
+
+	if len(all) == 1 {
+		return all[0], nil
+	}
+	return all, nil
+}
+
+// multiDiscovery combina varios mecanismos a la vez
+type multiDiscovery []Discovery
+
+func (m multiDiscovery) Name() string {
+	var names []string
+	for _, d := range m {
+		names = append(names, d.Name())
+	}
+	return strings.Join(names, ",")
+}
+
+func (m multiDiscovery) Listen(ctx context.Context, self *Peer) {
+	var wg sync.WaitGroup
+	for _, d := range m[1:] {
+		wg.Add(1)
+		go func(d Discovery) {
+			defer wg.Done()
+			d.Listen(ctx, self)
+		}(d)
+	}
+	m[0].Listen(ctx, self)
+	wg.Wait()
+}
+
+// Announce tiene éxito si al menos un mecanismo pudo emitir el anuncio
+func (m multiDiscovery) Announce(msg NodeAnnouncement) error {
+	var lastErr error
+	sent := false
+	for _, d := range m {
+		if err := d.Announce(msg); err != nil {
+			lastErr = fmt.Errorf("%s: %v", d.Name(), err)
+			continue
+		}
+		sent = true
+	}
+	if sent {
+		return nil
+	}
+	return lastErr
+}
+
+// MulticastDiscovery usa el mismo protocolo JSON que el broadcast, pero
+// sobre un grupo multicast IPv4 o IPv6.
+type MulticastDiscovery struct {
+	Group     string
+	Interface *net.Interface
+}
+
+func (d *MulticastDiscovery) Name() string { return "multicast " + d.Group }
+
+func (d *MulticastDiscovery) network() string {
+	if ip := net.ParseIP(d.Group); ip != nil && ip.To4() == nil {
+		return "udp6"
+	}
+	return "udp4"
+}
+
+func (d *MulticastDiscovery) groupAddr() *net.UDPAddr {
+	addr := &net.UDPAddr{
+		IP:   net.ParseIP(d.Group),
+		Port: mustParsePort(BroadcastPort),
+	}
+	if d.Interface != nil && d.network() == "udp6" {
+		addr.Zone = d.Interface.Name
+	}
+	return addr
+}
+
+// Announce envía el anuncio al grupo multicast
+func (d *MulticastDiscovery) Announce(msg NodeAnnouncement) error {
+	conn, err := net.DialUDP(d.network(), nil, d.groupAddr())
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	data, _ := json.Marshal(msg)
+	_, err = conn.Write(data)
+	return err
+}
+
+// Listen se une al grupo multicast y procesa los anuncios recibidos
+func (d *MulticastDiscovery) Listen(ctx context.Context, self *Peer) {
+	conn, err := net.ListenMulticastUDP(d.network(), d.Interface, d.groupAddr())
+	if err != nil {
+		fmt.Printf("Error al unirse al grupo %s: %v\n", d.Group, err)
+		return
+	}
+	defer conn.Close()
+	defer closeOnCancel(ctx, conn)()
+
+	getPeerList := func() []PeerInfo { return self.Peers.Snapshot() }
+	buf := make([]byte, 1024)
+	for {
+		n, sender, err := conn.ReadFromUDP(buf)
+		if err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+			continue
+		}
+		data := append([]byte(nil), buf[:n]...)
+		go ParseAndHandleAnnouncement(data, sender, self, getPeerList)
+	}
+}
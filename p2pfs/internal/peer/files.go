@@ -0,0 +1,452 @@
+package peer
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"p2pfs/internal/bandwidth"
+	"p2pfs/internal/fs"
+	"p2pfs/internal/jobs"
+	logger "p2pfs/internal/log"
+	"p2pfs/internal/message"
+	"p2pfs/internal/share"
+	"p2pfs/internal/state"
+	"p2pfs/internal/utils"
+)
+
+// Parámetros de transferencia; los fija la configuración del nodo
+var (
+	// MaxRetries es el número de intentos de un envío antes de encolarlo
+	MaxRetries = 3
+	// DialTimeout limita cuánto se espera al conectar con un peer
+	DialTimeout = 5 * time.Second
+	// RequestTimeout limita cuánto se espera la respuesta de un peer
+	RequestTimeout = 30 * time.Second
+)
+
+// remoteHost devuelve el host del otro extremo de una conexión
+func remoteHost(conn net.Conn) string {
+	host, _, err := net.SplitHostPort(conn.RemoteAddr().String())
+	if err != nil {
+		return conn.RemoteAddr().String()
+	}
+	return host
+}
+
+// hostOf devuelve el host de una dirección host:puerto
+func hostOf(addr string) string {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return addr
+	}
+	return host
+}
+
+// lookupPeer devuelve el peer conocido que escucha en addr o, si no se
+// conoce, uno con solo la dirección
+func (p *Peer) lookupPeer(addr string) PeerInfo {
+	if known, ok := p.FindPeer(addr); ok {
+		return known
+	}
+	info, _ := parsePeerAddr(addr)
+	return info
+}
+
+// FindPeer busca el peer registrado que escucha en addr, por cualquiera de
+// sus direcciones
+func (p *Peer) FindPeer(addr string) (PeerInfo, bool) {
+	info, err := parsePeerAddr(addr)
+	if err != nil {
+		return PeerInfo{}, false
+	}
+	for _, known := range p.Peers.Snapshot() {
+		if known.Port != info.Port {
+			continue
+		}
+		for _, host := range candidateHosts(known) {
+			if host == info.IP {
+				return known, true
+			}
+		}
+	}
+	return PeerInfo{}, false
+}
+
+// sharesWith indica si la carpeta se comparte con el peer
+func sharesWith(s *share.Share, info PeerInfo) bool {
+	return s.Allows(info.ID, info.Port, candidateHosts(info)...)
+}
+
+// senderID devuelve el ID que declara el remitente de msg solo si
+// corresponde a un peer registrado con la dirección desde la que llega la
+// conexión; si no, 0, y las carpetas solo lo admiten por su dirección
+func (p *Peer) senderID(conn net.Conn, msg message.Message) int {
+	id, err := strconv.Atoi(msg.From)
+	if err != nil || id == 0 {
+		return 0
+	}
+	known, ok := p.Peers.FindByID(id)
+	if !ok {
+		return 0
+	}
+	host := remoteHost(conn)
+	for _, h := range candidateHosts(known) {
+		if h == host {
+			return id
+		}
+	}
+	return 0
+}
+
+// incomingShare resuelve el archivo de un mensaje entrante y comprueba que
+// el remitente participa en la carpeta y que la ruta no está ignorada
+func (p *Peer) incomingShare(conn net.Conn, msg message.Message) (*share.Share, string, string, error) {
+	s, rel, local, err := share.Resolve(msg.FileName)
+	if err != nil {
+		return nil, "", "", err
+	}
+	if rel == "" {
+		return nil, "", "", fmt.Errorf("falta la ruta dentro de %s", s.Name)
+	}
+	if !s.Allows(p.senderID(conn, msg), "", remoteHost(conn)) {
+		return nil, "", "", fmt.Errorf("el nodo %s (%s) no participa en %s", msg.From, remoteHost(conn), s.Name)
+	}
+	info, err := os.Stat(local)
+	if s.Ignored(rel, err == nil && info.IsDir()) {
+		return nil, "", "", fmt.Errorf("%s está ignorado", msg.FileName)
+	}
+	return s, rel, local, nil
+}
+
+// closeWrite indica al otro extremo que el mensaje terminó sin cerrar la
+// lectura; el listener lee cada petición hasta EOF
+func closeWrite(conn net.Conn) error {
+	if tcp, ok := conn.(*net.TCPConn); ok {
+		return tcp.CloseWrite()
+	}
+	return nil
+}
+
+// roundTrip envía un mensaje al listener TCP de addr y devuelve su respuesta
+func (p *Peer) roundTrip(addr string, msg message.Message) (message.Message, error) {
+	return p.roundTripContext(context.Background(), addr, msg, nil)
+}
+
+// roundTripContext es roundTrip cancelable con ctx; con tr, lo recibido
+// cuenta como progreso de esa transferencia
+func (p *Peer) roundTripContext(ctx context.Context, addr string, msg message.Message, tr *tracker) (message.Message, error) {
+	var resp message.Message
+
+	dialer := net.Dialer{Timeout: DialTimeout}
+	conn, err := dialer.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return resp, fmt.Errorf("error de conexión: %v", err)
+	}
+	defer conn.Close()
+	defer closeOnCancel(ctx, conn)()
+	conn.SetDeadline(time.Now().Add(RequestTimeout))
+
+	data, _ := json.Marshal(msg)
+	if _, err := conn.Write(data); err != nil {
+		return resp, fmt.Errorf("error al enviar petición: %v", err)
+	}
+	if err := closeWrite(conn); err != nil {
+		return resp, err
+	}
+
+	var r io.Reader = bandwidth.Reader(ctx, conn, hostOf(addr))
+	if tr != nil {
+		r = tr.reader(r)
+	}
+	response, err := io.ReadAll(r)
+	if err != nil {
+		return resp, fmt.Errorf("error al recibir respuesta: %v", err)
+	}
+	if err := json.Unmarshal(response, &resp); err != nil {
+		return resp, fmt.Errorf("respuesta no válida: %v", err)
+	}
+	return resp, nil
+}
+
+// writeShared guarda data como rel dentro de la carpeta con una escritura
+// atómica y le aplica los permisos y la fecha de meta; la copia anterior,
+// si tenía otro contenido, se guarda antes como versión sin quitarla de su
+// sitio. Si meta describe un enlace simbólico se crea el enlace.
+func writeShared(s *share.Share, rel, dest string, data []byte, meta *fs.Meta) error {
+	if meta != nil && meta.Link != "" {
+		return linkShared(s, rel, dest, meta.Link)
+	}
+	if old, err := os.ReadFile(dest); err != nil || !bytes.Equal(old, data) {
+		if err := s.KeepVersion(rel); err != nil {
+			return err
+		}
+	}
+	os.MkdirAll(filepath.Dir(dest), 0755)
+	perm := os.FileMode(0644)
+	if meta != nil && meta.Mode.Perm() != 0 {
+		perm = meta.Mode.Perm()
+	}
+	if err := fs.WriteFileAtomic(dest, data, perm); err != nil {
+		return err
+	}
+	if meta == nil {
+		return nil
+	}
+	return fs.ApplyMeta(dest, *meta)
+}
+
+// commitShared coloca como rel el temporal tmp, ya escrito y comprobado
+// (hash es el de su contenido), y le aplica meta; como en writeShared, la
+// copia anterior se guarda como versión si tenía otro contenido
+func commitShared(s *share.Share, rel, dest string, tmp *fs.AtomicFile, hash string, meta fs.Meta) error {
+	if old, err := utils.CalculateSHA256(dest); err != nil || old != hash {
+		if err := s.KeepVersion(rel); err != nil {
+			tmp.Abort()
+			return err
+		}
+	}
+	perm := meta.Mode.Perm()
+	if perm == 0 {
+		perm = 0644
+	}
+	if err := tmp.Commit(perm); err != nil {
+		return err
+	}
+	return fs.ApplyMeta(dest, meta)
+}
+
+// linkShared crea rel como enlace simbólico a target, siempre que la
+// carpeta replique enlaces y el destino quede dentro de ella
+func linkShared(s *share.Share, rel, dest, target string) error {
+	if !s.Symlinks {
+		return fmt.Errorf("%s es un enlace simbólico y %s no replica enlaces", rel, s.Name)
+	}
+	if !fs.LinkInside(rel, target) || !fs.LinkWithin(s.Path, dest, target) {
+		return fmt.Errorf("el enlace %s -> %s apunta fuera de %s", rel, target, s.Name)
+	}
+	if current, err := os.Readlink(dest); err == nil && current == target {
+		return nil
+	}
+	if err := s.KeepVersion(rel); err != nil {
+		return err
+	}
+	os.MkdirAll(filepath.Dir(dest), 0755)
+	return fs.WriteSymlinkAtomic(dest, target)
+}
+
+// removeShared borra rel de la carpeta guardando antes su versión
+func removeShared(s *share.Share, rel, path string) error {
+	if err := s.Archive(rel); err != nil {
+		return err
+	}
+	return fs.DeletePath(path)
+}
+
+// DeleteShared borra un archivo o carpeta ("carpeta/ruta") y pide a los
+// peers de la carpeta que hagan lo mismo. Devuelve los peers que fallaron.
+func (p *Peer) DeleteShared(name string) (map[string]error, error) {
+	s, rel, path, err := share.Resolve(name)
+	if err != nil {
+		return nil, err
+	}
+	if rel == "" {
+		return nil, fmt.Errorf("no se puede borrar la carpeta compartida %s completa", s.Name)
+	}
+	if err := removeShared(s, rel, path); err != nil {
+		return nil, err
+	}
+	name = s.Key(rel)
+	logger.AppendToLocalLog(logger.Operation{
+		Type:      "DELETE",
+		FileName:  name,
+		From:      p.Addr(),
+		Timestamp: time.Now().Unix(),
+		Message:   "Eliminado localmente",
+	})
+
+	failed := make(map[string]error)
+	if !s.CanSend() {
+		return failed, nil
+	}
+	for _, info := range p.Peers.Snapshot() {
+		if p.IsSelf(info) || !sharesWith(s, info) {
+			continue
+		}
+		addr, ok := ResolvePeerAddr(info, time.Second)
+		if !ok {
+			addr = PeerAddr(info)
+			failed[addr] = fmt.Errorf("peer no disponible")
+		} else if err := p.sendDelete(addr, name); err != nil {
+			failed[addr] = err
+		}
+	}
+	// Los peers que no se enteraron lo harán en un reintento
+	for addr, err := range failed {
+		p.queueJob(jobs.Job{Type: jobs.Delete, FileName: name, To: addr}, err)
+	}
+	return failed, nil
+}
+
+// sendDelete pide a addr que borre "carpeta/ruta"
+func (p *Peer) sendDelete(addr, name string) error {
+	data, _ := json.Marshal(message.Message{
+		Type:      "DELETE",
+		From:      strconv.Itoa(p.GetID()),
+		FileName:  name,
+		Timestamp: time.Now().Unix(),
+	})
+	conn, err := net.DialTimeout("tcp", addr, DialTimeout)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	if _, err := conn.Write(data); err != nil {
+		return err
+	}
+	return closeWrite(conn)
+}
+
+func (p *Peer) handleDelete(conn net.Conn, msg message.Message) {
+	s, rel, path, err := p.incomingShare(conn, msg)
+	if err == nil && !s.CanReceive() {
+		err = fmt.Errorf("%s no acepta cambios remotos", s.Name)
+	}
+	if err != nil {
+		fmt.Printf("⚠️ Eliminación rechazada: %v\n", err)
+		return
+	}
+	if s.KeepsDeletes() {
+		fmt.Printf("🗄️ %s se conserva: %s es una carpeta de archivo\n", msg.FileName, s.Name)
+		logger.AppendToLocalLog(logger.Operation{
+			Type:      "DELETE_IGNORED",
+			FileName:  msg.FileName,
+			From:      conn.RemoteAddr().String(),
+			Timestamp: time.Now().Unix(),
+			Message:   "Carpeta de archivo: no se aplican borrados remotos",
+		})
+		return
+	}
+	// Lo que borra otro nodo va a la papelera para poder recuperarlo
+	note := "Eliminado por petición remota"
+	if s.TrashAge > 0 {
+		from := fmt.Sprintf("nodo %s (%s)", msg.From, remoteHost(conn))
+		item, err := s.Trash(rel, from)
+		if err != nil {
+			fmt.Printf("❌ Error al mover %s a la papelera: %v\n", msg.FileName, err)
+			return
+		}
+		if item.ID != "" {
+			note = fmt.Sprintf("Movido a la papelera (%s) por petición remota", item.ID)
+		}
+	} else if err := removeShared(s, rel, path); err != nil {
+		fmt.Printf("❌ Error al eliminar %s: %v\n", msg.FileName, err)
+		return
+	}
+	state.ForgetReceived(s.Key(rel))
+	fmt.Printf("🗑️ %s: %s\n", msg.FileName, note)
+	logger.AppendToLocalLog(logger.Operation{
+		Type:      "DELETE",
+		FileName:  msg.FileName,
+		From:      conn.RemoteAddr().String(),
+		Timestamp: time.Now().Unix(),
+		Message:   note,
+	})
+}
+
+// handleTransfer guarda un archivo enviado por otro nodo, salvo que la
+// carpeta no lo acepte, el contenido no coincida con su hash o la copia
+// local sea más reciente. tr sigue la recepción desde que empezó a llegar;
+// sin él (un remitente que no pone el tipo primero) se sigue desde aquí.
+func (p *Peer) handleTransfer(conn net.Conn, msg message.Message, tr *tracker) {
+	if tr == nil {
+		tr = trackTransfer("recv", "", remoteHost(conn), 0)
+	}
+	size := int64(len(msg.Data))
+	if msg.RawSize > 0 {
+		size = msg.RawSize
+	}
+	tr.describe(msg.FileName, size)
+	// Se confirma al remitente antes de cerrar el seguimiento, que corta la conexión
+	var result error
+	defer func() { tr.finish(tr.err(result)) }()
+	defer p.ackTransfer(conn)
+
+	s, rel, destPath, err := p.incomingShare(conn, msg)
+	if err == nil && !s.CanReceive() {
+		err = fmt.Errorf("%s no acepta cambios remotos", s.Name)
+	}
+	if err == nil {
+		msg.Data, err = decodeData(msg)
+	}
+	if err != nil {
+		result = err
+		fmt.Printf("⚠️ Transferencia rechazada: %v\n", err)
+		logger.AppendToLocalLog(logger.Operation{
+			Type:      "TRANSFER_REJECTED",
+			FileName:  msg.FileName,
+			From:      conn.RemoteAddr().String(),
+			Timestamp: time.Now().Unix(),
+			Message:   err.Error(),
+		})
+		return
+	}
+	if msg.Hash != "" && utils.HashBytes(msg.Data) != msg.Hash {
+		result = fmt.Errorf("hash incorrecto para %s", msg.FileName)
+		fmt.Printf("❌ Hash incorrecto para %s, se descarta\n", msg.FileName)
+		logger.AppendToLocalLog(logger.Operation{
+			Type:      "HASH_MISMATCH",
+			FileName:  msg.FileName,
+			From:      conn.RemoteAddr().String(),
+			Timestamp: time.Now().Unix(),
+			Message:   "El contenido recibido no coincide con el hash enviado",
+		})
+		return
+	}
+	remoteTime := time.Unix(msg.Timestamp, 0)
+	if msg.Meta != nil && !msg.Meta.ModTime.IsZero() {
+		remoteTime = msg.Meta.ModTime
+	} else if msg.Timestamp == 0 {
+		remoteTime = time.Now()
+	}
+	if info, err := os.Lstat(destPath); err == nil && !msg.Force {
+		if info.ModTime().After(remoteTime) {
+			result = fmt.Errorf("la copia local es más reciente")
+			fmt.Printf("⚠️ Archivo local más reciente (%s), se ignora transferencia\n", msg.FileName)
+			logger.AppendToLocalLog(logger.Operation{
+				Type:      "TIMESTAMP_CONFLICT",
+				FileName:  msg.FileName,
+				From:      conn.RemoteAddr().String(),
+				Timestamp: time.Now().Unix(),
+				Message:   "Archivo local más reciente. Transferencia ignorada.",
+			})
+			return
+		}
+	}
+	// Cancelada mientras se comprobaba: no se escribe nada
+	if tr.ctx.Err() != nil {
+		fmt.Printf("⏹️ Recepción de %s cancelada\n", msg.FileName)
+		return
+	}
+	if err := writeShared(s, rel, destPath, msg.Data, msg.Meta); err != nil {
+		result = err
+		fmt.Printf("❌ Error al guardar archivo %s: %v\n", msg.FileName, err)
+		return
+	}
+	recordReceived(s, rel, destPath)
+	fmt.Printf("📥 Archivo %s recibido y guardado\n", msg.FileName)
+	logger.AppendToLocalLog(logger.Operation{
+		Type:      "TRANSFER",
+		FileName:  msg.FileName,
+		From:      conn.RemoteAddr().String(),
+		Timestamp: time.Now().Unix(),
+		Message:   "Archivo recibido exitosamente vía TRANSFER",
+	})
+}
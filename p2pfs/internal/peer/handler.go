@@ -49,7 +49,7 @@ func handleConnection(conn net.Conn) {
 		return
 	}
 
-	fmt.Printf("📩 Mensaje recibido: %s desde nodo %d\n", msg.Type, msg.Origin)
+	fmt.Printf("📩 Mensaje recibido: %s desde nodo %s\n", msg.Type, msg.Origin)
 
 	switch msg.Type {
 	case "TRANSFER":
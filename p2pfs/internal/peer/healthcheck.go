@@ -2,10 +2,11 @@ package peer
 
 import (
 	"bufio"
+	"context"
 	"encoding/json"
 	"fmt"
 	"net"
-	"strings"
+	"sync"
 	"time"
 )
 
@@ -15,27 +16,45 @@ var peerLastSeen = make(map[string]time.Time)
 // Estado anterior de cada peer (activo/inactivo)
 var peerWasDown = make(map[string]bool)
 
+// healthMu protege peerLastSeen, peerWasDown y peerStatuses
+var healthMu sync.Mutex
 
 type HandshakeMessage struct {
 	Type       string   `json:"type"`
 	From       string   `json:"from"`
+	ID         int      `json:"id,omitempty"`      // HELLO: ID del emisor; WELCOME: ID asignado al emisor
+	SelfID     int      `json:"self_id,omitempty"` // WELCOME: ID de quien responde
+	Addrs      []string `json:"addrs,omitempty"`   // Todas las direcciones del emisor
 	KnownPeers []string `json:"known_peers,omitempty"`
 }
 
 // Mapa de estado de peers vivos
 var peerStatuses = make(map[string]bool)
 
-// CheckPeerAlive intenta conectarse a un peer
+// CheckPeerAlive intenta conectarse a un peer por cualquiera de sus direcciones
 func CheckPeerAlive(peer PeerInfo) bool {
 	address := net.JoinHostPort(peer.IP, peer.Port)
-	conn, err := net.DialTimeout("tcp", address, 1*time.Second)
-	if err != nil {
-		peerStatuses[address] = false
-		return false
-	}
-	conn.Close()
-	peerStatuses[address] = true
-	return true
+	_, alive := ResolvePeerAddr(peer, 1*time.Second)
+	healthMu.Lock()
+	peerStatuses[address] = alive
+	healthMu.Unlock()
+	return alive
+}
+
+// IsPeerOnline retorna el resultado de la última comprobación del peer
+func IsPeerOnline(peer PeerInfo) bool {
+	healthMu.Lock()
+	defer healthMu.Unlock()
+	return peerStatuses[net.JoinHostPort(peer.IP, peer.Port)]
+}
+
+// forgetPeerHealth descarta el historial de un peer olvidado
+func forgetPeerHealth(address string) {
+	healthMu.Lock()
+	defer healthMu.Unlock()
+	delete(peerLastSeen, address)
+	delete(peerWasDown, address)
+	delete(peerStatuses, address)
 }
 
 // GetLivePeers retorna los IDs de peers activos
@@ -59,7 +78,7 @@ func StartHandshakeListener(self PeerInfo, getPeerList func() []PeerInfo) {
 		fmt.Println("Error al iniciar listener:", err)
 		return
 	}
-	fmt.Println("🔊 Escuchando handshakes en", self.IP+":"+self.Port)
+	fmt.Println("🔊 Escuchando handshakes en", net.JoinHostPort(self.IP, self.Port))
 
 	go func() {
 		for {
@@ -100,45 +119,50 @@ func handleHandshake(conn net.Conn, self PeerInfo, getPeerList func() []PeerInfo
 }
 
 // SendHelloAndReceivePeers envía HELLO y recibe WELCOME
-func SendHelloAndReceivePeers(addr string) ([]string, error) {
+func SendHelloAndReceivePeers(addr string, hello HandshakeMessage) (HandshakeMessage, error) {
+	var res HandshakeMessage
+
 	conn, err := net.DialTimeout("tcp", addr, 2*time.Second)
 	if err != nil {
-		return nil, err
+		return res, err
 	}
 	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
 
-	msg := HandshakeMessage{
-		Type: "HELLO",
-		From: "?", // opcional
+	hello.Type = "HELLO"
+	data, _ := json.Marshal(hello)
+	if _, err := conn.Write(append(data, '\n')); err != nil {
+		return res, err
+	}
+	// El listener principal lee hasta EOF antes de responder
+	if tcp, ok := conn.(*net.TCPConn); ok {
+		tcp.CloseWrite()
 	}
-
-data, _ := json.Marshal(msg)
-	conn.Write(append(data, '\n'))
 
 	reader := bufio.NewReader(conn)
-	line, _ := reader.ReadString('\n')
-
-	var res HandshakeMessage
-	json.Unmarshal([]byte(line), &res)
+	line, err := reader.ReadString('\n')
+	if err != nil && line == "" {
+		return res, err
+	}
 
-	if res.Type == "WELCOME" {
-		return res.KnownPeers, nil
+	if err := json.Unmarshal([]byte(line), &res); err != nil {
+		return res, fmt.Errorf("respuesta inválida del handshake: %v", err)
+	}
+	if res.Type != "WELCOME" {
+		return res, fmt.Errorf("respuesta inválida del handshake")
 	}
-	return nil, fmt.Errorf("respuesta inválida del handshake")
+	return res, nil
 }
 
 // MergePeerListsFromStrings añade nuevos peers evitando duplicados
-func MergePeerListsFromStrings(known []string, current *[]PeerInfo) {
-	existing := make(map[string]bool)
-	for _, p := range *current {
-		existing[net.JoinHostPort(p.IP, p.Port)] = true
-	}
+func MergePeerListsFromStrings(known []string, current *Registry) {
 	for _, addr := range known {
-		if !existing[addr] {
-			ipPort := strings.Split(addr, ":")
-			if len(ipPort) == 2 {
-				*current = append(*current, PeerInfo{IP: ipPort[0], Port: ipPort[1]})
-			}
+		info, err := parsePeerAddr(addr)
+		if err != nil {
+			continue
+		}
+		if _, exists := current.Get(peerKey(info)); !exists {
+			current.Add(info)
 		}
 	}
 }
@@ -149,6 +173,9 @@ func UpdatePeerStatus(peer PeerInfo) bool {
 	address := net.JoinHostPort(peer.IP, peer.Port)
 	alive := CheckPeerAlive(peer)
 
+	healthMu.Lock()
+	defer healthMu.Unlock()
+
 	if alive {
 		now := time.Now()
 		_, seen := peerLastSeen[address]
@@ -172,23 +199,35 @@ func UpdatePeerStatus(peer PeerInfo) bool {
 }
 
 // MonitorPeersAndSync revisa periódicamente el estado de los peers
-// y sincroniza automáticamente si detecta reconexión.
-func (p *Peer) MonitorPeersAndSync(interval time.Duration) {
+// y sincroniza automáticamente si detecta reconexión, hasta que se cancela ctx.
+func (p *Peer) MonitorPeersAndSync(ctx context.Context, interval time.Duration) {
 	ticker := time.NewTicker(interval)
 	defer ticker.Stop()
 
-	for range ticker.C {
-		for _, peerInfo := range p.Peers {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		for _, peerInfo := range p.Peers.Snapshot() {
 			// No sincronizamos con nosotros mismos
-			if peerInfo.Port == p.Port && peerInfo.IP == p.IP {
+			if p.IsSelf(peerInfo) {
 				continue
 			}
 
-			if UpdatePeerStatus(peerInfo) {
-				fmt.Printf("📡 Iniciando sincronización con %s:%s...\n", peerInfo.IP, peerInfo.Port)
-				go p.SyncWithPeer(peerInfo)
+			reconnected := UpdatePeerStatus(peerInfo)
+			if IsPeerOnline(peerInfo) {
+				p.Peers.Touch(peerKey(peerInfo), time.Now())
+			}
+			if reconnected {
+				fmt.Printf("📡 Iniciando sincronización con %s...\n", PeerAddr(peerInfo))
+				p.StartSync(peerInfo)
 			}
 		}
+
+		// ✋ Cambios locales en carpetas receive-only: se señalan, no se envían
+		p.flagLocalChanges()
 	}
 }
 
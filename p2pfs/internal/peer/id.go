@@ -5,14 +5,14 @@ import (
 	"fmt"
 	"net"
 	"sync"
-	"time"
 )
 
 type NodeAnnouncement struct {
-	Type string `json:"type"` // "HELLO", "ASSIGN_ID", "NEW_NODE"
-	IP   string `json:"ip"`
-	Port string `json:"port"`
-	ID   int    `json:"id,omitempty"`
+	Type  string   `json:"type"` // "HELLO", "ASSIGN_ID", "NEW_NODE"
+	IP    string   `json:"ip"`
+	Port  string   `json:"port"`
+	ID    int      `json:"id,omitempty"`
+	Addrs []string `json:"addrs,omitempty"` // Todas las direcciones del nodo
 }
 
 var (
@@ -28,12 +28,18 @@ func ParseAndHandleAnnouncement(data []byte, sender *net.UDPAddr, self *Peer, ge
 		fmt.Println("⚠️ Error al parsear mensaje:", err)
 		return
 	}
+	HandleAnnouncement(msg, self)
+}
 
+// HandleAnnouncement aplica un anuncio ya decodificado, venga del mecanismo
+// de descubrimiento que venga.
+func HandleAnnouncement(msg NodeAnnouncement, self *Peer) {
 	senderKey := net.JoinHostPort(msg.IP, msg.Port)
 
 	switch msg.Type {
 
 	case "HELLO":
+		registerAssignedID(self.Addr(), self.GetID())
 		idMutex.Lock()
 		defer idMutex.Unlock()
 
@@ -45,10 +51,11 @@ func ParseAndHandleAnnouncement(data []byte, sender *net.UDPAddr, self *Peer, ge
 
 			// Responder directamente con ASSIGN_ID
 			assignMsg := NodeAnnouncement{
-				Type: "ASSIGN_ID",
-				IP:   msg.IP,
-				Port: msg.Port,
-				ID:   newID,
+				Type:  "ASSIGN_ID",
+				IP:    msg.IP,
+				Port:  msg.Port,
+				ID:    newID,
+				Addrs: msg.Addrs,
 			}
 			sendUDPMessage(assignMsg, msg.IP)
 
@@ -57,19 +64,11 @@ func ParseAndHandleAnnouncement(data []byte, sender *net.UDPAddr, self *Peer, ge
 		}
 
 	case "ASSIGN_ID":
-		if self.ID == 0 && msg.IP == self.IP && msg.Port == self.Port {
-			self.ID = msg.ID
-			self.LastIDAssigned = time.Now()
-			fmt.Printf("✅ ID %d asignado al nodo local\n", self.ID)
+		if self.IsSelf(PeerInfo{IP: msg.IP, Port: msg.Port}) && self.ClaimID(msg.ID) {
+			fmt.Printf("✅ ID %d asignado al nodo local\n", msg.ID)
 
 			// Difundir nuestra existencia
-			newNode := NodeAnnouncement{
-				Type: "NEW_NODE",
-				IP:   self.IP,
-				Port: self.Port,
-				ID:   self.ID,
-			}
-			BroadcastNewNode(newNode)
+			BroadcastNewNode(self.Announcement("NEW_NODE"))
 		}
 
 	case "NEW_NODE":
@@ -85,11 +84,22 @@ func ParseAndHandleAnnouncement(data []byte, sender *net.UDPAddr, self *Peer, ge
 			}
 
 			// Agregar a lista de peers locales
-			self.AddPeer(PeerInfo{ID: msg.ID, IP: msg.IP, Port: msg.Port})
+			self.AddPeer(PeerInfo{ID: msg.ID, IP: msg.IP, Port: msg.Port, Addrs: msg.Addrs})
 		}
 	}
 }
 
+// Announcement construye un anuncio del nodo local con todas sus direcciones
+func (p *Peer) Announcement(kind string) NodeAnnouncement {
+	return NodeAnnouncement{
+		Type:  kind,
+		IP:    p.IP,
+		Port:  p.Port,
+		ID:    p.GetID(),
+		Addrs: p.Addrs,
+	}
+}
+
 // getNextAvailableID retorna el siguiente ID libre
 func getNextAvailableID() int {
 	max := 0
@@ -104,11 +114,28 @@ func getNextAvailableID() int {
 	return nextID
 }
 
+// registerAssignedID registra un ID conocido (p. ej. cargado de disco)
+// para que no se vuelva a asignar a otro nodo.
+func registerAssignedID(key string, id int) {
+	if id == 0 {
+		return
+	}
+	idMutex.Lock()
+	defer idMutex.Unlock()
+
+	if _, exists := assignedIDs[key]; !exists {
+		assignedIDs[key] = id
+	}
+	if id >= nextID {
+		nextID = id + 1
+	}
+}
+
 // sendUDPMessage envía un mensaje UDP directo a una IP
 func sendUDPMessage(msg NodeAnnouncement, ip string) {
 	addr := &net.UDPAddr{
 		IP:   net.ParseIP(ip),
-		Port: mustParsePort(getEnvOrDefault("DISCOVERY_PORT", "48999")),
+		Port: mustParsePort(BroadcastPort),
 	}
 	conn, err := net.DialUDP("udp", nil, addr)
 	if err != nil {
@@ -120,21 +147,11 @@ func sendUDPMessage(msg NodeAnnouncement, ip string) {
 	conn.Write(data)
 }
 
-// BroadcastNewNode difunde un NEW_NODE por broadcast UDP
+// BroadcastNewNode difunde un NEW_NODE por el mecanismo de descubrimiento activo
 func BroadcastNewNode(msg NodeAnnouncement) {
-	addr := net.UDPAddr{
-		IP:   net.IPv4bcast,
-		Port: mustParsePort(getEnvOrDefault("DISCOVERY_PORT", "48999")),
-	}
-	conn, err := net.DialUDP("udp", nil, &addr)
-	if err != nil {
+	if err := ActiveDiscovery.Announce(msg); err != nil {
 		fmt.Println("Error al emitir NEW_NODE:", err)
-		return
 	}
-	defer conn.Close()
-
-	data, _ := json.Marshal(msg)
-	conn.Write(data)
 }
 
 // Utilidades
@@ -0,0 +1,304 @@
+package peer
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// MDNSService es el tipo de servicio DNS-SD que anuncian los nodos
+const MDNSService = "_p2pfs._tcp.local."
+
+const mdnsTTL = 120
+
+var (
+	mdnsGroupV4 = &net.UDPAddr{IP: net.IPv4(224, 0, 0, 251), Port: 5353}
+	mdnsGroupV6 = &net.UDPAddr{IP: net.ParseIP("ff02::fb"), Port: 5353}
+)
+
+// MDNSDiscovery anuncia el nodo como servicio _p2pfs._tcp por mDNS/DNS-SD.
+// mDNS solo localiza direcciones: el ID se obtiene con el handshake TCP de
+// las semillas, igual que al unirse con --join.
+type MDNSDiscovery struct {
+	IPv6      bool
+	Interface *net.Interface
+
+	mu      sync.Mutex
+	joining bool
+}
+
+func (d *MDNSDiscovery) Name() string {
+	if d.IPv6 {
+		return "mdns6"
+	}
+	return "mdns"
+}
+
+func (d *MDNSDiscovery) network() (string, *net.UDPAddr) {
+	if d.IPv6 {
+		group := *mdnsGroupV6
+		if d.Interface != nil {
+			group.Zone = d.Interface.Name
+		}
+		return "udp6", &group
+	}
+	return "udp4", mdnsGroupV4
+}
+
+// Announce traduce los anuncios del protocolo a mDNS: HELLO pregunta por el
+// servicio y NEW_NODE publica los registros del nodo. ASSIGN_ID no aplica.
+func (d *MDNSDiscovery) Announce(msg NodeAnnouncement) error {
+	var packet []byte
+	var err error
+
+	switch msg.Type {
+	case "HELLO":
+		packet, err = mdnsQuery()
+	case "NEW_NODE":
+		packet, err = mdnsResponse(msg)
+	default:
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return d.send(packet)
+}
+
+func (d *MDNSDiscovery) send(packet []byte) error {
+	network, group := d.network()
+	conn, err := net.DialUDP(network, nil, group)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	_, err = conn.Write(packet)
+	return err
+}
+
+// Listen responde a las consultas por el servicio y registra los nodos
+// que se anuncian, hasta que se cancela ctx.
+func (d *MDNSDiscovery) Listen(ctx context.Context, self *Peer) {
+	network, group := d.network()
+	conn, err := net.ListenMulticastUDP(network, d.Interface, group)
+	if err != nil {
+		fmt.Println("Error al escuchar mDNS:", err)
+		return
+	}
+	defer conn.Close()
+	defer closeOnCancel(ctx, conn)()
+
+	buf := make([]byte, 9000)
+	for {
+		n, _, err := conn.ReadFromUDP(buf)
+		if err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+			continue
+		}
+		query, found := parseMDNS(buf[:n])
+
+		if query {
+			d.answer(self)
+		}
+		for _, ann := range found {
+			d.handleFound(self, ann)
+		}
+	}
+}
+
+// answer publica nuestros registros en respuesta a una consulta
+func (d *MDNSDiscovery) answer(self *Peer) {
+	packet, err := mdnsResponse(self.Announcement("NEW_NODE"))
+	if err != nil {
+		fmt.Println("⚠️ Error al construir respuesta mDNS:", err)
+		return
+	}
+	if err := d.send(packet); err != nil {
+		fmt.Println("⚠️ Error al responder mDNS:", err)
+	}
+}
+
+func (d *MDNSDiscovery) handleFound(self *Peer, ann NodeAnnouncement) {
+	if self.IsSelf(PeerInfo{IP: ann.IP, Port: ann.Port}) {
+		return
+	}
+	if ann.ID != 0 {
+		HandleAnnouncement(ann, self)
+	}
+
+	// Sin ID propio: pedirlo al nodo encontrado mediante el handshake TCP
+	if self.GetID() == 0 && ann.ID != 0 {
+		d.mu.Lock()
+		if d.joining {
+			d.mu.Unlock()
+			return
+		}
+		d.joining = true
+		d.mu.Unlock()
+
+		go func() {
+			self.JoinSeeds([]string{net.JoinHostPort(ann.IP, ann.Port)})
+			d.mu.Lock()
+			d.joining = false
+			d.mu.Unlock()
+		}()
+	}
+}
+
+// mdnsInstance genera el nombre de instancia DNS-SD de un nodo
+func mdnsInstance(ann NodeAnnouncement) string {
+	label := strings.NewReplacer(".", "-", ":", "-", "%", "-").Replace(ann.IP + "-" + ann.Port)
+	return "p2pfs-" + label
+}
+
+func mdnsQuery() ([]byte, error) {
+	service, err := dnsmessage.NewName(MDNSService)
+	if err != nil {
+		return nil, err
+	}
+
+	b := dnsmessage.NewBuilder(nil, dnsmessage.Header{})
+	if err := b.StartQuestions(); err != nil {
+		return nil, err
+	}
+	if err := b.Question(dnsmessage.Question{
+		Name:  service,
+		Type:  dnsmessage.TypePTR,
+		Class: dnsmessage.ClassINET,
+	}); err != nil {
+		return nil, err
+	}
+	return b.Finish()
+}
+
+// mdnsResponse construye los registros PTR, SRV, TXT y A/AAAA del nodo
+func mdnsResponse(ann NodeAnnouncement) ([]byte, error) {
+	instance := mdnsInstance(ann)
+	service, err := dnsmessage.NewName(MDNSService)
+	if err != nil {
+		return nil, err
+	}
+	instanceName, err := dnsmessage.NewName(instance + "." + MDNSService)
+	if err != nil {
+		return nil, err
+	}
+	hostName, err := dnsmessage.NewName(instance + ".local.")
+	if err != nil {
+		return nil, err
+	}
+	port, err := strconv.Atoi(ann.Port)
+	if err != nil {
+		return nil, fmt.Errorf("puerto inválido %q", ann.Port)
+	}
+
+	header := func(name dnsmessage.Name, t dnsmessage.Type) dnsmessage.ResourceHeader {
+		return dnsmessage.ResourceHeader{Name: name, Type: t, Class: dnsmessage.ClassINET, TTL: mdnsTTL}
+	}
+
+	b := dnsmessage.NewBuilder(nil, dnsmessage.Header{Response: true, Authoritative: true})
+	b.EnableCompression()
+	if err := b.StartAnswers(); err != nil {
+		return nil, err
+	}
+	if err := b.PTRResource(header(service, dnsmessage.TypePTR), dnsmessage.PTRResource{PTR: instanceName}); err != nil {
+		return nil, err
+	}
+	if err := b.SRVResource(header(instanceName, dnsmessage.TypeSRV), dnsmessage.SRVResource{Port: uint16(port), Target: hostName}); err != nil {
+		return nil, err
+	}
+	txt := dnsmessage.TXTResource{TXT: []string{
+		"ip=" + ann.IP,
+		"port=" + ann.Port,
+		"id=" + strconv.Itoa(ann.ID),
+		"addrs=" + strings.Join(ann.Addrs, ","),
+	}}
+	if err := b.TXTResource(header(instanceName, dnsmessage.TypeTXT), txt); err != nil {
+		return nil, err
+	}
+
+	if ip := net.ParseIP(ann.IP); ip != nil {
+		if ip4 := ip.To4(); ip4 != nil {
+			var a dnsmessage.AResource
+			copy(a.A[:], ip4)
+			err = b.AResource(header(hostName, dnsmessage.TypeA), a)
+		} else {
+			var aaaa dnsmessage.AAAAResource
+			copy(aaaa.AAAA[:], ip.To16())
+			err = b.AAAAResource(header(hostName, dnsmessage.TypeAAAA), aaaa)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	return b.Finish()
+}
+
+// parseMDNS indica si el paquete pregunta por nuestro servicio y extrae los
+// nodos anunciados en sus registros TXT.
+func parseMDNS(packet []byte) (bool, []NodeAnnouncement) {
+	var p dnsmessage.Parser
+	h, err := p.Start(packet)
+	if err != nil {
+		return false, nil
+	}
+
+	questions, err := p.AllQuestions()
+	if err != nil {
+		return false, nil
+	}
+	if !h.Response {
+		for _, q := range questions {
+			if strings.EqualFold(q.Name.String(), MDNSService) &&
+				(q.Type == dnsmessage.TypePTR || q.Type == dnsmessage.TypeALL) {
+				return true, nil
+			}
+		}
+		return false, nil
+	}
+
+	answers, err := p.AllAnswers()
+	if err != nil {
+		return false, nil
+	}
+	if err := p.SkipAllAuthorities(); err == nil {
+		if extra, err := p.AllAdditionals(); err == nil {
+			answers = append(answers, extra...)
+		}
+	}
+
+	var found []NodeAnnouncement
+	for _, r := range answers {
+		txt, ok := r.Body.(*dnsmessage.TXTResource)
+		if !ok || !strings.HasSuffix(strings.ToLower(r.Header.Name.String()), MDNSService) {
+			continue
+		}
+		ann := NodeAnnouncement{Type: "NEW_NODE"}
+		for _, kv := range txt.TXT {
+			key, value, _ := strings.Cut(kv, "=")
+			switch key {
+			case "ip":
+				ann.IP = value
+			case "port":
+				ann.Port = value
+			case "id":
+				ann.ID, _ = strconv.Atoi(value)
+			case "addrs":
+				if value != "" {
+					ann.Addrs = strings.Split(value, ",")
+				}
+			}
+		}
+		if ann.IP != "" && ann.Port != "" {
+			found = append(found, ann)
+		}
+	}
+	return false, found
+}
@@ -0,0 +1,278 @@
+package peer
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"p2pfs/internal/fs"
+	logger "p2pfs/internal/log"
+	"p2pfs/internal/share"
+	"p2pfs/internal/state"
+)
+
+// Tipos de cambio local en una carpeta receive-only
+const (
+	ChangeAdded    = "added"
+	ChangeModified = "modified"
+	ChangeDeleted  = "deleted"
+)
+
+// LocalChange es un cambio hecho en este nodo sobre una carpeta receive-only
+type LocalChange struct {
+	Share   string    `json:"share"`
+	Path    string    `json:"path"`
+	Kind    string    `json:"kind"`
+	ModTime time.Time `json:"mod_time,omitempty"`
+}
+
+var (
+	flaggedMu sync.Mutex
+	flagged   = make(map[string]string) // "carpeta/ruta" -> tipo ya registrado
+)
+
+// recordReceived anota cómo quedó en disco un archivo recibido para poder
+// detectar después si se editó localmente
+func recordReceived(s *share.Share, rel, local string) {
+	if s.Mode != share.ReceiveOnly {
+		return
+	}
+	info, err := os.Stat(local)
+	if err != nil {
+		return
+	}
+	state.SetReceived(state.FileInfo{Name: s.Key(rel), ModTime: info.ModTime(), Size: info.Size()})
+}
+
+// LocalChanges compara las carpetas receive-only con la última versión
+// recibida de cada archivo
+func LocalChanges() []LocalChange {
+	received := state.ReceivedFiles()
+	changes := []LocalChange{}
+
+	for _, s := range share.All() {
+		if s.Mode != share.ReceiveOnly {
+			continue
+		}
+		files, err := fs.ListFilteredFiles(s.Path, s.Ignored)
+		if err != nil {
+			continue
+		}
+
+		seen := make(map[string]bool)
+		for _, f := range files {
+			if f.IsDir {
+				continue
+			}
+			rel, err := filepath.Rel(s.Path, f.FullPath)
+			if err != nil {
+				continue
+			}
+			rel = filepath.ToSlash(rel)
+			key := s.Key(rel)
+			seen[key] = true
+
+			prev, ok := received[key]
+			switch {
+			case !ok:
+				changes = append(changes, LocalChange{Share: s.Name, Path: rel, Kind: ChangeAdded, ModTime: f.ModTime})
+			case !prev.ModTime.Equal(f.ModTime) || prev.Size != f.Size:
+				changes = append(changes, LocalChange{Share: s.Name, Path: rel, Kind: ChangeModified, ModTime: f.ModTime})
+			}
+		}
+
+		for key := range received {
+			rel := strings.TrimPrefix(key, s.Name+"/")
+			if rel == key || seen[key] || s.Ignored(rel, false) {
+				continue
+			}
+			changes = append(changes, LocalChange{Share: s.Name, Path: rel, Kind: ChangeDeleted})
+		}
+	}
+
+	sort.Slice(changes, func(i, j int) bool {
+		if changes[i].Share != changes[j].Share {
+			return changes[i].Share < changes[j].Share
+		}
+		return changes[i].Path < changes[j].Path
+	})
+	return changes
+}
+
+// flagLocalChanges registra en el log los cambios locales nuevos de las
+// carpetas receive-only; no se propagan a otros nodos
+func (p *Peer) flagLocalChanges() {
+	changes := LocalChanges()
+
+	flaggedMu.Lock()
+	defer flaggedMu.Unlock()
+	current := make(map[string]string, len(changes))
+	for _, c := range changes {
+		key := c.Share + "/" + c.Path
+		current[key] = c.Kind
+		if flagged[key] == c.Kind {
+			continue
+		}
+		fmt.Printf("✋ Cambio local en carpeta receive-only: %s (%s)\n", key, c.Kind)
+		logger.AppendToLocalLog(logger.Operation{
+			Type:      "LOCAL_CHANGE",
+			FileName:  key,
+			From:      p.Addr(),
+			Timestamp: time.Now().Unix(),
+			Message:   fmt.Sprintf("Cambio local (%s) en carpeta receive-only; no se propaga", c.Kind),
+		})
+	}
+	flagged = current
+}
+
+// Revert deshace las diferencias de una carpeta con el resto de nodos: en
+// una send-only reenvía la copia local a los peers (y les pide borrar lo que
+// sobra); en una receive-only descarta los cambios locales. Devuelve cuántos
+// archivos se corrigieron.
+func (p *Peer) Revert(name string) (int, error) {
+	s, ok := share.Get(name)
+	if !ok {
+		return 0, fmt.Errorf("%s: %w", name, share.ErrUnknown)
+	}
+	switch s.Mode {
+	case share.SendOnly:
+		return p.overrideRemotes(s)
+	case share.ReceiveOnly:
+		return p.revertLocal(s)
+	}
+	return 0, fmt.Errorf("la carpeta %s es %s: solo se revierten carpetas send-only o receive-only", s.Name, s.Mode)
+}
+
+// overrideRemotes impone la copia local de una carpeta send-only
+func (p *Peer) overrideRemotes(s *share.Share) (int, error) {
+	tree, err := s.Tree()
+	if err != nil {
+		return 0, err
+	}
+	local := fs.FlattenTreePaths(tree)
+
+	fixed := 0
+	var errs []string
+	for _, info := range p.Peers.Snapshot() {
+		if p.IsSelf(info) || !sharesWith(s, info) {
+			continue
+		}
+		addr, ok := ResolvePeerAddr(info, time.Second)
+		if !ok {
+			errs = append(errs, fmt.Sprintf("%s: no disponible", PeerAddr(info)))
+			continue
+		}
+
+		// Un peer que no ofrece la carpeta (p. ej. receive-only) no deja ver
+		// qué tiene: se le envía todo
+		var remote map[string]fs.FileNode
+		if remoteTree, err := p.RequestFileTree(addr); err == nil && remoteTree != nil {
+			for _, child := range remoteTree.Children {
+				if child.Name == s.Name {
+					remote = fs.FlattenTreePaths(child)
+				}
+			}
+		}
+
+		for rel, f := range local {
+			if r, ok := remote[rel]; ok && r.ModTime.Equal(f.ModTime) {
+				continue
+			}
+			if err := p.sendFile(s.LocalPath(rel), addr, true, Interactive); err != nil {
+				errs = append(errs, fmt.Sprintf("%s: %v", addr, err))
+				continue
+			}
+			fixed++
+		}
+		for rel := range remote {
+			if _, ok := local[rel]; ok {
+				continue
+			}
+			if err := p.sendDelete(addr, s.Key(rel)); err != nil {
+				errs = append(errs, fmt.Sprintf("%s: %v", addr, err))
+				continue
+			}
+			fixed++
+		}
+	}
+
+	logger.AppendToLocalLog(logger.Operation{
+		Type:      "REVERT",
+		FileName:  s.Name,
+		From:      p.Addr(),
+		Timestamp: time.Now().Unix(),
+		Message:   fmt.Sprintf("Copia local impuesta a los peers (%d archivo(s))", fixed),
+	})
+	if len(errs) > 0 {
+		return fixed, fmt.Errorf("%s", strings.Join(errs, "; "))
+	}
+	return fixed, nil
+}
+
+// revertLocal descarta los cambios locales de una carpeta receive-only: lo
+// modificado o borrado se vuelve a descargar y lo añadido se elimina
+func (p *Peer) revertLocal(s *share.Share) (int, error) {
+	fixed := 0
+	var errs []string
+	for _, c := range LocalChanges() {
+		if c.Share != s.Name {
+			continue
+		}
+		key := s.Key(c.Path)
+
+		missing, err := p.fetchFromAny(s, key)
+		if err != nil && c.Kind == ChangeAdded && missing {
+			// Los peers responden que no lo tienen: es un archivo creado aquí
+			err = removeShared(s, c.Path, s.LocalPath(c.Path))
+		}
+		if err != nil {
+			errs = append(errs, fmt.Sprintf("%s: %v", key, err))
+			continue
+		}
+		fixed++
+		logger.AppendToLocalLog(logger.Operation{
+			Type:      "REVERT",
+			FileName:  key,
+			From:      p.Addr(),
+			Timestamp: time.Now().Unix(),
+			Message:   fmt.Sprintf("Cambio local (%s) descartado", c.Kind),
+		})
+	}
+	if len(errs) > 0 {
+		return fixed, fmt.Errorf("%s", strings.Join(errs, "; "))
+	}
+	return fixed, nil
+}
+
+// fetchFromAny descarga un archivo del primer peer de la carpeta que lo
+// tenga, sobrescribiendo la copia local. missing indica que algún peer
+// respondió y que todos los que lo hicieron dijeron no tener el archivo.
+func (p *Peer) fetchFromAny(s *share.Share, key string) (missing bool, err error) {
+	err = fmt.Errorf("ningún peer disponible")
+	reached, failed := false, false
+	for _, info := range p.Peers.Snapshot() {
+		if p.IsSelf(info) || !sharesWith(s, info) {
+			continue
+		}
+		addr, ok := ResolvePeerAddr(info, time.Second)
+		if !ok {
+			continue
+		}
+		reached = true
+		release := acquireSlot(Interactive, 0, addr)
+		err = p.requestRemoteFile(key, addr, true)
+		release()
+		if err == nil {
+			return false, nil
+		}
+		if !errors.Is(err, errRemoteMissing) {
+			failed = true
+		}
+	}
+	return reached && !failed, err
+}
@@ -1,93 +1,183 @@
 package peer
 
 import (
+	"bufio"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net"
 	"os"
 	"path/filepath"
+	"p2pfs/internal/bandwidth"
+	"p2pfs/internal/compress"
 	"p2pfs/internal/fs"
+	"p2pfs/internal/jobs"
 	logger "p2pfs/internal/log"
 	"p2pfs/internal/message"
+	"p2pfs/internal/share"
 	"p2pfs/internal/state"
+	"p2pfs/internal/supervisor"
 	"p2pfs/internal/utils"
 	"strconv"
-	"strings"
+	"sync"
 	"time"
 )
 
 type PeerInfo struct {
-	ID   int
-	IP   string
-	Port string
+	ID       int       `json:"id"`
+	IP       string    `json:"ip"`
+	Port     string    `json:"port"`
+	Addrs    []string  `json:"addrs,omitempty"` // Otras direcciones anunciadas por el peer
+	LastSeen time.Time `json:"last_seen"`       // Última vez que el peer respondió
 }
 
 type Peer struct {
-	ID             int
-	IP             string
-	Port           string
-	Peers          []PeerInfo
-	Conn           net.Conn
-	LastHelloSent  time.Time
-	LastIDAssigned time.Time
+	IP    string
+	Addrs []string // Todas las direcciones locales anunciadas
+	Port  string
+	Peers *Registry
+	Conn  net.Conn
+	// Tasks son las tareas de fondo del nodo; las sincronizaciones que se
+	// lanzan sobre la marcha corren en ellas para detenerse con el nodo
+	Tasks *supervisor.Supervisor
+
+	mu             sync.RWMutex
+	id             int
+	lastHelloSent  time.Time
+	lastIDAssigned time.Time
 }
 
 func NewPeer(id int, port string, peers []PeerInfo) *Peer {
 	return &Peer{
-		ID:    id,
+		id:    id,
 		IP:    GetLocalIP(),
+		Addrs: GetLocalIPs(),
 		Port:  port,
-		Peers: peers,
+		Peers: NewRegistry(peers...),
 	}
 }
 
-func (p *Peer) AddPeer(info PeerInfo) {
-	for _, existing := range p.Peers {
-		if existing.IP == info.IP && existing.Port == info.Port {
-			return
-		}
+// GetID retorna el ID del nodo local (0 si aún no tiene)
+func (p *Peer) GetID() int {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	return p.id
+}
+
+// SetID fija el ID del nodo local
+func (p *Peer) SetID(id int) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.id = id
+	p.lastIDAssigned = time.Now()
+}
+
+// ClaimID fija el ID solo si el nodo aún no tiene uno. Retorna true si lo fijó.
+func (p *Peer) ClaimID(id int) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.id != 0 || id == 0 {
+		return false
 	}
-	p.Peers = append(p.Peers, info)
+	p.id = id
+	p.lastIDAssigned = time.Now()
+	return true
+}
+
+// LastIDAssigned retorna cuándo se fijó el ID del nodo local
+func (p *Peer) LastIDAssigned() time.Time {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	return p.lastIDAssigned
+}
+
+// LastHelloSent retorna cuándo se emitió el último HELLO
+func (p *Peer) LastHelloSent() time.Time {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	return p.lastHelloSent
+}
+
+func (p *Peer) markHelloSent() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.lastHelloSent = time.Now()
+}
+
+func (p *Peer) AddPeer(info PeerInfo) {
+	p.Peers.Add(info)
 }
 
 func (p *Peer) FindPeerByID(id int) *PeerInfo {
-	for _, peer := range p.Peers {
-		if peer.ID == id {
-			return &peer
-		}
+	if info, found := p.Peers.FindByID(id); found {
+		return &info
 	}
 	return nil
 }
 
-
-func (p *Peer) StartListener() {
-	ln, err := net.Listen("tcp", ":"+p.Port)
+// StartListener atiende las conexiones de otros nodos hasta que se cancela
+// ctx; entonces deja de aceptar y espera a las que están en curso
+func (p *Peer) StartListener(ctx context.Context) {
+	// Sin host: escucha en todas las interfaces, IPv4 e IPv6
+	ln, err := net.Listen("tcp", net.JoinHostPort("", p.Port))
 	if err != nil {
 		fmt.Println("Error al iniciar listener:", err)
 		return
 	}
 	defer ln.Close()
+	stop := closeOnCancel(ctx, ln)
+	defer stop()
 
-	fmt.Println("Nodo", p.ID, "escuchando en puerto", p.Port)
+	fmt.Println("Nodo", p.GetID(), "escuchando en puerto", p.Port)
 
+	var wg sync.WaitGroup
+	defer wg.Wait()
 	for {
 		conn, err := ln.Accept()
 		if err != nil {
+			if ctx.Err() != nil {
+				return
+			}
 			fmt.Println("Error al aceptar conexión:", err)
 			continue
 		}
-		go p.handleConnection(conn)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			p.handleConnection(conn)
+		}()
 	}
 }
 
+// transferPrefix es el principio de un mensaje TRANSFER: el tipo es el
+// primer campo de message.Message, así que se reconoce antes de leerlo entero
+const transferPrefix = `{"type":"TRANSFER"`
 
 func (p *Peer) handleConnection(conn net.Conn) {
 	defer conn.Close()
 
-	data, err := io.ReadAll(conn)
+	// Un archivo que llega se sigue como transferencia desde el primer
+	// byte, para que se vea su avance y se pueda cancelar
+	in := bufio.NewReader(conn)
+	ctx := transfersCtx
+	var tr *tracker
+	if head, _ := in.Peek(len(transferPrefix)); string(head) == transferPrefix {
+		tr = trackTransfer("recv", "", remoteHost(conn), 0)
+		ctx = tr.ctx
+		defer closeOnCancel(ctx, conn)()
+	}
+	var r io.Reader = bandwidth.Reader(ctx, in, remoteHost(conn))
+	if tr != nil {
+		r = tr.reader(r)
+	}
+
+	data, err := io.ReadAll(r)
 	if err != nil {
+		if tr != nil {
+			tr.finish(tr.err(err))
+		}
 		fmt.Println("❌ Error al leer conexión:", err)
 		return
 	}
@@ -99,108 +189,152 @@ func (p *Peer) handleConnection(conn net.Conn) {
 
 	var msg message.Message
 	if err := json.Unmarshal(data, &msg); err != nil {
+		if tr != nil {
+			tr.finish(err)
+		}
 		fmt.Println("⚠️ Entrada no válida como JSON. Ignorando.")
 		return
 	}
 
 	switch msg.Type {
+	case "HELLO":
+		p.handleHello(conn, data)
+
 	case "LIST":
-		p.handleList(conn)
+		p.handleList(conn, msg)
 
 	case "REQUEST_FILE":
 		p.handleRequestFile(conn, msg)
 
 	case "TRANSFER":
-		destPath := filepath.Join("shared", msg.FileName)
-		remoteTime := time.Unix(msg.Timestamp, 0)
-		if msg.Timestamp == 0 {
-			remoteTime = time.Now()
-		}
-		if info, err := os.Stat(destPath); err == nil {
-			if info.ModTime().After(remoteTime) {
-				fmt.Printf("⚠️ Archivo local más reciente (%s), se ignora transferencia\n", msg.FileName)
-				logger.AppendToLocalLog(logger.Operation{
-					Type:      "TIMESTAMP_CONFLICT",
-					FileName:  msg.FileName,
-					From:      conn.RemoteAddr().String(),
-					Timestamp: time.Now().Unix(),
-					Message:   "Archivo local más reciente. Transferencia ignorada.",
-				})
-				return
-			}
-		}
-		if err := os.WriteFile(destPath, msg.Data, 0644); err != nil {
-			fmt.Printf("❌ Error al guardar archivo %s: %v\n", msg.FileName, err)
-			return
-		}
-		fmt.Printf("📥 Archivo %s recibido y guardado\n", msg.FileName)
-		logger.AppendToLocalLog(logger.Operation{
-			Type:      "TRANSFER",
-			FileName:  msg.FileName,
-			From:      conn.RemoteAddr().String(),
-			Timestamp: time.Now().Unix(),
-			Message:   "Archivo recibido exitosamente vía TRANSFER",
-		})
+		p.handleTransfer(conn, msg, tr)
+
+	case "DELETE":
+		p.handleDelete(conn, msg)
+
+	case "MKDIR":
+		p.handleMkdir(conn, msg)
+
+	case "CHUNKS":
+		p.handleChunks(conn, msg)
+
+	case "REQUEST_CHUNK":
+		p.handleRequestChunk(conn, msg)
+
+	case "INVENTORY":
+		p.handleInventory(conn, msg)
 
 	default:
 		fmt.Println("⚠️ Tipo de mensaje no reconocido:", msg.Type)
 	}
 }
 
-
+// SendFile envía un archivo o carpeta a addr; si falla se encola para reintentar
 func (p *Peer) SendFile(filePath, addr string) error {
-	const maxRetries = 3
-	const timeout = 5 * time.Second
+	return p.sendFile(filePath, addr, false, Interactive)
+}
 
-	if p.ID == 0 {
+// sendFile envía el archivo cuando el planificador le da turno según prio;
+// con force el receptor lo sobrescribe aunque su copia sea más reciente
+func (p *Peer) sendFile(filePath, addr string, force bool, prio Priority) error {
+	if p.GetID() == 0 {
 		return fmt.Errorf("nodo sin ID asignado")
 	}
 
-	parts := strings.Split(addr, ":")
-	if len(parts) != 2 {
+	peerInfo, err := parsePeerAddr(addr)
+	if err != nil {
 		return fmt.Errorf("dirección inválida: %s", addr)
 	}
-	peerInfo := PeerInfo{IP: parts[0], Port: parts[1]}
+
+	info, err := os.Lstat(filePath)
+	if err != nil {
+		return fmt.Errorf("no se pudo acceder al archivo: %v", err)
+	}
+
+	// En la red el archivo se nombra "carpeta/ruta"; lo que está fuera de
+	// las carpetas compartidas va a la carpeta por defecto
+	s, rel, ok := share.Locate(filePath)
+	if !ok {
+		if s, ok = share.Default(); !ok {
+			return fmt.Errorf("no hay carpetas compartidas configuradas")
+		}
+		rel = filepath.Base(filePath)
+	}
+	// Sin symlinks los enlaces se siguen y se envía su contenido
+	if info.Mode()&os.ModeSymlink != 0 && !s.Symlinks {
+		if info, err = os.Stat(filePath); err != nil {
+			return fmt.Errorf("no se pudo acceder al archivo: %v", err)
+		}
+	}
+	switch {
+	case rel == "":
+		return fmt.Errorf("no se puede enviar la carpeta compartida %s completa", s.Name)
+	case !s.CanSend():
+		return fmt.Errorf("la carpeta %s es solo de recepción", s.Name)
+	case !sharesWith(s, p.lookupPeer(addr)):
+		return fmt.Errorf("la carpeta %s no se comparte con %s", s.Name, addr)
+	case s.Ignored(rel, info.IsDir()):
+		return fmt.Errorf("%s está ignorado en %s", rel, s.Name)
+	}
 
 	if !CheckPeerAlive(peerInfo) {
 		logger.AppendToLocalLog(logger.Operation{
 			Type:      "PEER_UNAVAILABLE",
 			FileName:  filepath.Base(filePath),
-			From:      GetLocalIP() + ":" + p.Port,
+			From:      p.Addr(),
 			Timestamp: time.Now().Unix(),
-			Message:   fmt.Sprintf("Peer %s:%s no responde", peerInfo.IP, peerInfo.Port),
+			Message:   fmt.Sprintf("Peer %s no responde", addr),
 		})
-		return fmt.Errorf("peer %s:%s no disponible", peerInfo.IP, peerInfo.Port)
+		err := fmt.Errorf("peer %s no disponible", addr)
+		p.queueJob(jobs.Job{Type: jobs.Transfer, FileName: filePath, To: addr, Force: force}, err)
+		return err
 	}
 
-	info, err := os.Stat(filePath)
-	if err != nil {
-		return fmt.Errorf("no se pudo acceder al archivo: %v", err)
+	if info.IsDir() {
+		return p.sendDir(s, rel, addr, force, prio)
 	}
 
 	originalPath := filePath
-	filename := filepath.Base(filePath)
+	filename := s.Key(rel)
+	m, _, err := fs.ReadMeta(filePath, s.Symlinks)
+	if err != nil {
+		return fmt.Errorf("no se pudo acceder al archivo: %v", err)
+	}
+	meta := &m
 
-	if info.IsDir() {
-		tmpZip := filepath.Join(os.TempDir(), info.Name()+".zip")
-		if err := utils.ZipFolder(filePath, tmpZip); err != nil {
-			return fmt.Errorf("error al comprimir: %v", err)
+	var content []byte
+	if meta.Link == "" {
+		if content, err = os.ReadFile(filePath); err != nil {
+			return fmt.Errorf("no se pudo leer el archivo: %v", err)
 		}
-		filePath = tmpZip
-		defer os.Remove(tmpZip)
-		filename = info.Name() + ".zip"
 	}
 
-	hash, err := utils.CalculateSHA256(filePath)
-	if err != nil {
-		return fmt.Errorf("error hash: %v", err)
-	}
+	// Se comprime con lo que el peer anunció que acepta
+	payload, encoding := encodeData(filename, content, peerEncodings(addr))
+	packet, _ := json.Marshal(message.Message{
+		Type:      "TRANSFER",
+		From:      strconv.Itoa(p.GetID()),
+		FileName:  filename,
+		Data:      payload,
+		Hash:      utils.HashBytes(content),
+		Encoding:  encoding,
+		RawSize:   int64(len(content)),
+		Force:     force,
+		Meta:      meta,
+		Timestamp: info.ModTime().Unix(),
+	})
+
+	release := acquireSlot(prio, int64(len(content)), addr)
+	defer release()
+	tr := trackTransfer("send", filename, addr, int64(len(content)))
+	tr.setTotal(int64(len(packet)))
 
 	var lastErr error
-	for attempt := 1; attempt <= maxRetries; attempt++ {
+	for attempt := 1; attempt <= MaxRetries && tr.ctx.Err() == nil; attempt++ {
 		fmt.Printf("🔁 Intento %d para enviar %s...\n", attempt, filename)
+		tr.restart()
 
-		ctx, cancel := context.WithTimeout(context.Background(), timeout)
+		ctx, cancel := context.WithTimeout(tr.ctx, DialTimeout)
 		dialer := net.Dialer{}
 		conn, err := dialer.DialContext(ctx, "tcp", addr)
 		cancel()
@@ -210,170 +344,218 @@ func (p *Peer) SendFile(filePath, addr string) error {
 			logger.AppendToLocalLog(logger.Operation{
 				Type:      "SEND_FAIL",
 				FileName:  filename,
-				From:      GetLocalIP() + ":" + p.Port,
+				From:      p.Addr(),
 				Timestamp: time.Now().Unix(),
 				Message:   fmt.Sprintf("Falló intento %d: %v", attempt, err),
 			})
-			time.Sleep(time.Second * time.Duration(attempt))
+			select {
+			case <-time.After(time.Second * time.Duration(attempt)):
+			case <-tr.ctx.Done():
+			}
 			continue
 		}
-		defer conn.Close()
 
-		file, err := os.Open(filePath)
-		if err != nil {
-			lastErr = err
-			break
+		// El receptor lee hasta EOF: cerrar la escritura marca el fin del
+		// mensaje; su respuesta dice qué compresiones acepta
+		stop := closeOnCancel(tr.ctx, conn)
+		_, err = bandwidth.Writer(tr.ctx, tr.writer(conn), hostOf(addr)).Write(packet)
+		if err == nil {
+			err = closeWrite(conn)
 		}
-
-		_, err = fmt.Fprintf(conn, filename+"\n"+hash+"\n")
-		if err != nil {
-			lastErr = err
-			file.Close()
-			continue
+		if err == nil {
+			readAck(conn, addr)
 		}
-
-		_, err = io.Copy(conn, file)
-		file.Close()
+		stop()
+		conn.Close()
 		if err != nil {
 			lastErr = err
 			continue
 		}
+		tr.finish(nil)
 
 		logger.AppendToLocalLog(logger.Operation{
 			Type:      "TRANSFER",
 			FileName:  filename,
-			From:      GetLocalIP() + ":" + p.Port,
+			From:      p.Addr(),
 			Timestamp: time.Now().Unix(),
-			Message:   fmt.Sprintf("Enviado con éxito a %s", addr),
+			Message:   fmt.Sprintf("Enviado con éxito a %s%s", addr, describeEncoding(encoding, len(content), len(payload))),
 		})
-		fmt.Printf("📤 %s enviado exitosamente\n", filename)
+		fmt.Printf("📤 %s enviado exitosamente%s\n", filename, describeEncoding(encoding, len(content), len(payload)))
+		// Un reintento pendiente de este archivo ya no hace falta
+		jobs.Resolve(jobs.Transfer, addr, originalPath)
 		return nil
 	}
 
+	lastErr = tr.err(lastErr)
+	tr.finish(lastErr)
+	if errors.Is(lastErr, ErrCancelled) {
+		return lastErr
+	}
+
 	// Todos los intentos fallaron: registrar y reintentar más tarde
 	logger.AppendToLocalLog(logger.Operation{
 		Type:      "SEND_FAIL",
 		FileName:  filename,
-		From:      GetLocalIP() + ":" + p.Port,
+		From:      p.Addr(),
 		Timestamp: time.Now().Unix(),
-		Message:   fmt.Sprintf("Falló tras %d intentos. Último error: %v", maxRetries, lastErr),
+		Message:   fmt.Sprintf("Falló tras %d intentos. Último error: %v", MaxRetries, lastErr),
 	})
 
-	// Agregar a la cola de reintentos con estructura correcta
-	state.AddPendingTask(state.PendingTask{
-		Type:      "TRANSFER",
-		FileName:  originalPath,
-		From:      GetLocalIP() + ":" + p.Port,
-		To:        addr,
-		Retries:   maxRetries,
-		Timestamp: time.Now().Unix(),
-	})
+	p.queueJob(jobs.Job{Type: jobs.Transfer, FileName: originalPath, To: addr, Force: force}, lastErr)
 
-	return fmt.Errorf("falló el envío tras %d intentos: %v", maxRetries, lastErr)
+	return fmt.Errorf("falló el envío tras %d intentos: %v", MaxRetries, lastErr)
 }
 
 func (p *Peer) handleRequestFile(conn net.Conn, msg message.Message) {
-	path := filepath.Join("shared", msg.FileName)
-	f, err := os.Open(path)
+	s, _, path, err := p.incomingShare(conn, msg)
+	if err == nil && !s.CanSend() {
+		err = fmt.Errorf("la carpeta %s es solo de recepción", s.Name)
+	}
+	var meta fs.Meta
+	var data []byte
+	notFound := false
+	if err == nil {
+		if meta, _, err = fs.ReadMeta(path, s.Symlinks); err != nil {
+			notFound = os.IsNotExist(err)
+			err = fmt.Errorf("no se pudo abrir el archivo")
+		} else if meta.Link == "" {
+			if data, err = os.ReadFile(path); err != nil {
+				err = fmt.Errorf("no se pudo leer el archivo")
+			}
+		}
+	}
 	if err != nil {
 		logger.AppendToLocalLog(logger.Operation{
 			Type:      "REQUEST_FAIL",
 			FileName:  msg.FileName,
 			From:      conn.RemoteAddr().String(),
 			Timestamp: time.Now().Unix(),
-			Message:   "Archivo no encontrado",
+			Message:   err.Error(),
 		})
 		resp := message.Message{
 			Type:     "ERROR",
-			From:     strconv.Itoa(p.ID),
+			From:     strconv.Itoa(p.GetID()),
 			FileName: msg.FileName,
-			Data:     []byte("no se pudo abrir el archivo"),
+			Data:     []byte(err.Error()),
+			NotFound: notFound,
 		}
 		data, _ := json.Marshal(resp)
 		conn.Write(data)
 		return
 	}
-	defer f.Close()
-
-	data, err := io.ReadAll(f)
-	if err != nil {
-		fmt.Println("❌ Error al leer archivo:", err)
-		return
-	}
-
-	_, err = utils.CalculateSHA256(path)
-	if err != nil {
-		fmt.Println("❌ Error al calcular hash:", err)
-	}
 
+	payload, encoding := encodeData(msg.FileName, data, msg.Accept)
 	resp := message.Message{
 		Type:      "TRANSFER",
-		From:      strconv.Itoa(p.ID),
+		From:      strconv.Itoa(p.GetID()),
 		FileName:  msg.FileName,
-		Data:      data,
-		Timestamp: time.Now().Unix(),
+		Data:      payload,
+		Hash:      utils.HashBytes(data),
+		Encoding:  encoding,
+		RawSize:   int64(len(data)),
+		Accept:    compress.Supported,
+		Meta:      &meta,
+		Timestamp: meta.ModTime.Unix(),
 	}
 	packet, _ := json.Marshal(resp)
-	conn.Write(packet)
+	tr := trackTransfer("serve", msg.FileName, conn.RemoteAddr().String(), int64(len(data)))
+	tr.setTotal(int64(len(packet)))
+	stop := closeOnCancel(tr.ctx, conn)
+	_, err = bandwidth.Writer(tr.ctx, tr.writer(conn), remoteHost(conn)).Write(packet)
+	stop()
+	err = tr.err(err)
+	tr.finish(err)
+	if err != nil {
+		fmt.Printf("⚠️ Envío de %s a %s interrumpido: %v\n", msg.FileName, conn.RemoteAddr(), err)
+		return
+	}
 
 	logger.AppendToLocalLog(logger.Operation{
 		Type:      "REQUEST_TRANSFER",
 		FileName:  msg.FileName,
 		From:      conn.RemoteAddr().String(),
 		Timestamp: time.Now().Unix(),
-		Message:   "Archivo enviado por solicitud remota",
+		Message:   "Archivo enviado por solicitud remota" + describeEncoding(encoding, len(data), len(payload)),
 	})
 }
 
+// RequestRemoteFile descarga "carpeta/ruta" de addr a la misma carpeta local
 func (p *Peer) RequestRemoteFile(fileName, addr string) error {
-	parts := strings.Split(addr, ":")
-	if len(parts) != 2 {
+	release := acquireSlot(Interactive, 0, addr)
+	defer release()
+	return p.requestRemoteFile(fileName, addr, false)
+}
+
+// requestRemoteFile descarga el archivo; con force sobrescribe la copia
+// local aunque sea más reciente
+func (p *Peer) requestRemoteFile(fileName, addr string, force bool) error {
+	peerInfo, err := parsePeerAddr(addr)
+	if err != nil {
 		return fmt.Errorf("dirección inválida: %s", addr)
 	}
-	peerInfo := PeerInfo{IP: parts[0], Port: parts[1]}
+
+	s, rel, dest, err := share.Resolve(fileName)
+	switch {
+	case err != nil:
+		return err
+	case rel == "":
+		return fmt.Errorf("falta la ruta dentro de %s", s.Name)
+	case !s.CanReceive():
+		return fmt.Errorf("la carpeta %s es solo de envío", s.Name)
+	case !sharesWith(s, p.lookupPeer(addr)):
+		return fmt.Errorf("la carpeta %s no se comparte con %s", s.Name, addr)
+	case s.Ignored(rel, false):
+		return fmt.Errorf("%s está ignorado en %s", rel, s.Name)
+	}
 
 	if !CheckPeerAlive(peerInfo) {
 		logger.AppendToLocalLog(logger.Operation{
 			Type:      "PEER_UNAVAILABLE",
 			FileName:  fileName,
-			From:      GetLocalIP() + ":" + p.Port,
+			From:      p.Addr(),
 			Timestamp: time.Now().Unix(),
-			Message:   fmt.Sprintf("Peer %s:%s no responde", peerInfo.IP, peerInfo.Port),
+			Message:   fmt.Sprintf("Peer %s no responde", addr),
 		})
-		return fmt.Errorf("peer %s:%s no disponible", peerInfo.IP, peerInfo.Port)
+		return fmt.Errorf("peer %s no disponible", addr)
 	}
 
-	conn, err := net.Dial("tcp", addr)
-	if err != nil {
-		return fmt.Errorf("error de conexión: %v", err)
-	}
-	defer conn.Close()
-
-	msg := message.Message{
+	tr := trackTransfer("fetch", fileName, addr, 0)
+	resp, err := p.roundTripContext(tr.ctx, addr, message.Message{
 		Type:     "REQUEST_FILE",
-		From:     strconv.Itoa(p.ID),
+		From:     strconv.Itoa(p.GetID()),
 		FileName: fileName,
-	}
-	data, _ := json.Marshal(msg)
-	conn.Write(data)
-
-	response, err := io.ReadAll(conn)
+		Accept:   compress.Supported,
+	}, tr)
+	err = tr.err(err)
+	tr.finish(err)
 	if err != nil {
-		return fmt.Errorf("error al recibir respuesta: %v", err)
+		return err
 	}
+	learnEncodings(addr, resp.Accept)
 
-	var resp message.Message
-	if err := json.Unmarshal(response, &resp); err != nil {
-		return fmt.Errorf("respuesta no válida: %v", err)
+	if resp.Type == "ERROR" {
+		if resp.NotFound {
+			return fmt.Errorf("%s: %s: %w", addr, resp.Data, errRemoteMissing)
+		}
+		return fmt.Errorf("%s: %s", addr, resp.Data)
 	}
-
-	if resp.Type != "TRANSFER" || len(resp.Data) == 0 {
+	// Sin metadatos (nodos anteriores) no se distingue un archivo vacío de un error
+	if resp.Type != "TRANSFER" || (len(resp.Data) == 0 && resp.Meta == nil) {
 		return fmt.Errorf("respuesta inválida o archivo vacío")
 	}
+	if resp.Data, err = decodeData(resp); err != nil {
+		return err
+	}
+	if resp.Hash != "" && utils.HashBytes(resp.Data) != resp.Hash {
+		return fmt.Errorf("hash incorrecto para %s", fileName)
+	}
 
-	dest := filepath.Join("shared", "recibido-"+fileName)
-	if info, err := os.Stat(dest); err == nil && resp.Timestamp > 0 {
-		if info.ModTime().After(time.Unix(resp.Timestamp, 0)) {
+	remoteTime := time.Unix(resp.Timestamp, 0)
+	if resp.Meta != nil && !resp.Meta.ModTime.IsZero() {
+		remoteTime = resp.Meta.ModTime
+	}
+	if info, err := os.Lstat(dest); err == nil && resp.Timestamp > 0 && !force {
+		if info.ModTime().After(remoteTime) {
 			logger.AppendToLocalLog(logger.Operation{
 				Type:      "TIMESTAMP_CONFLICT",
 				FileName:  fileName,
@@ -385,9 +567,10 @@ func (p *Peer) RequestRemoteFile(fileName, addr string) error {
 		}
 	}
 
-	if err := os.WriteFile(dest, resp.Data, 0644); err != nil {
+	if err := writeShared(s, rel, dest, resp.Data, resp.Meta); err != nil {
 		return fmt.Errorf("error al guardar archivo: %v", err)
 	}
+	recordReceived(s, rel, dest)
 
 	logger.AppendToLocalLog(logger.Operation{
 		Type:      "REQUEST_RECV",
@@ -399,135 +582,223 @@ func (p *Peer) RequestRemoteFile(fileName, addr string) error {
 	fmt.Printf("✅ Archivo %s recibido desde %s\n", fileName, addr)
 
 	if resp.Timestamp > 0 {
-		modTime := time.Unix(resp.Timestamp, 0)
-		entries := state.FileCache[peerInfo.IP]
-		found := false
-		for i, f := range entries {
-			if f.Name == fileName {
-				entries[i].ModTime = modTime
-				found = true
-				break
-			}
-		}
-		if !found {
-			entries = append(entries, state.FileInfo{
-				Name:    fileName,
-				ModTime: modTime,
-			})
-		}
-		state.FileCache[peerInfo.IP] = entries
-		state.SaveState()
+		state.SetFileCacheEntry(CacheKey(p.lookupPeer(addr)), state.FileInfo{
+			Name:    fileName,
+			ModTime: remoteTime,
+		})
 	}
 
 	return nil
 }
 
-func (p *Peer) RetryWorker(interval time.Duration) {
-	ticker := time.NewTicker(interval)
-	defer ticker.Stop()
+// errSyncBusy indica que ya había una sincronización en curso con el peer
+var errSyncBusy = errors.New("ya hay una sincronización en curso")
 
-	for range ticker.C {
-		state.LoadState() // 🧠 Asegura que tienes la última versión del estado
-
-		tasks := state.RetryQueue
-		if len(tasks) == 0 {
-			continue
-		}
+// errRemoteMissing indica que el peer respondió que no tiene el archivo pedido
+var errRemoteMissing = errors.New("el peer no tiene el archivo")
 
-		fmt.Printf("🔁 Reintentando %d tarea(s) fallidas...\n", len(tasks))
+// syncing son los peers con una sincronización en curso y downloading
+// los archivos que alguna de ellas está descargando
+var (
+	syncingMu   sync.Mutex
+	syncing     = make(map[string]bool)
+	downloading = make(map[string]bool)
+)
 
-		var updated []state.PendingTask
-		for _, task := range tasks {
-			if task.Type != "TRANSFER" {
-				updated = append(updated, task)
-				continue
-			}
+// startDownload reserva name para una sincronización; false si otra ya lo
+// está descargando
+func startDownload(name string) bool {
+	syncingMu.Lock()
+	defer syncingMu.Unlock()
+	if downloading[name] {
+		return false
+	}
+	downloading[name] = true
+	return true
+}
 
-			info, err := os.Stat(task.FileName)
-			if os.IsNotExist(err) {
-				logger.AppendToLocalLog(logger.Operation{
-					Type:      "RETRY_SKIPPED",
-					FileName:  filepath.Base(task.FileName),
-					From:      GetLocalIP() + ":" + p.Port,
-					Timestamp: time.Now().Unix(),
-					Message:   "Archivo eliminado. Reintento omitido.",
-				})
-				continue
-			}
+func endDownload(name string) {
+	syncingMu.Lock()
+	delete(downloading, name)
+	syncingMu.Unlock()
+}
 
-			if info.ModTime().Unix() > task.Timestamp {
-				logger.AppendToLocalLog(logger.Operation{
-					Type:      "RETRY_SKIPPED",
-					FileName:  filepath.Base(task.FileName),
-					From:      GetLocalIP() + ":" + p.Port,
-					Timestamp: time.Now().Unix(),
-					Message:   "Archivo modificado tras el fallo. Reintento omitido.",
-				})
-				continue
-			}
+// StartSync sincroniza con el peer en segundo plano como una tarea más del
+// nodo, de modo que al detenerlo deja de empezar descargas y se la espera
+func (p *Peer) StartSync(peerInfo PeerInfo) {
+	run := func(ctx context.Context) { p.SyncWithPeer(ctx, peerInfo) }
+	if p.Tasks == nil {
+		go run(context.Background())
+		return
+	}
+	p.Tasks.Go("sincronización con "+PeerAddr(peerInfo), run)
+}
 
-			if err := p.SendFile(task.FileName, task.To); err != nil {
-				task.Retries++
-				updated = append(updated, task)
-			}
-		}
+// tasksContext es el contexto de las tareas de fondo del nodo
+func (p *Peer) tasksContext() context.Context {
+	if p.Tasks == nil {
+		return context.Background()
+	}
+	return p.Tasks.Context()
+}
 
-		state.RetryQueue = updated
-		state.SaveState()
+// SyncWithPeer descarga lo que el peer tiene más reciente. Las descargas
+// pasan por el planificador en segundo plano, de modo que las de varios
+// peers comparten el límite de transferencias y los archivos pequeños
+// llegan primero. Si algo falla, la sincronización se encola para
+// reintentarla. Al cancelar ctx no empieza más descargas.
+func (p *Peer) SyncWithPeer(ctx context.Context, peerInfo PeerInfo) {
+	addr := PeerAddr(peerInfo)
+	err := p.syncWithPeer(ctx, peerInfo)
+	if errors.Is(err, errSyncBusy) || errors.Is(err, ErrShutdown) {
+		// La que está en curso encolará lo que falle; una interrumpida al
+		// detener el nodo se repite al reconectar
+		return
 	}
+	if err != nil {
+		p.queueJob(jobs.Job{Type: jobs.Sync, To: addr}, err)
+		return
+	}
+	jobs.Resolve(jobs.Sync, addr, "")
 }
 
-func (p *Peer) SyncWithPeer(peerInfo PeerInfo) {
-	addr := net.JoinHostPort(peerInfo.IP, peerInfo.Port)
+func (p *Peer) syncWithPeer(ctx context.Context, peerInfo PeerInfo) error {
+	addr := PeerAddr(peerInfo)
+	syncingMu.Lock()
+	if syncing[addr] {
+		syncingMu.Unlock()
+		fmt.Printf("⏳ Ya hay una sincronización en curso con %s\n", addr)
+		return errSyncBusy
+	}
+	syncing[addr] = true
+	syncingMu.Unlock()
+	defer func() {
+		syncingMu.Lock()
+		delete(syncing, addr)
+		syncingMu.Unlock()
+	}()
 	fmt.Printf("🔁 Sincronizando con %s...\n", addr)
 
 	remoteTree, err := p.RequestFileTree(addr)
-	if err != nil || remoteTree == nil {
-		fmt.Printf("❌ No se pudo obtener árbol remoto: %v\n", err)
-		return
+	if err == nil && remoteTree == nil {
+		err = fmt.Errorf("respuesta sin árbol de archivos")
 	}
-
-	remoteFiles := fs.FlattenTree(*remoteTree)
-	remoteMap := make(map[string]time.Time)
-	for _, f := range remoteFiles {
-		remoteMap[f.Name] = f.ModTime
+	if err != nil {
+		fmt.Printf("❌ No se pudo obtener árbol remoto: %v\n", err)
+		return fmt.Errorf("no se pudo obtener el árbol remoto: %v", err)
 	}
 
-	cached := state.FileCache[peerInfo.IP]
+	cached := state.GetFileCache(CacheKey(peerInfo))
 	cacheMap := make(map[string]time.Time)
 	for _, f := range cached {
 		cacheMap[f.Name] = f.ModTime
 	}
+	// Lo descargado se anota aparte y se une a la caché al terminar
+	var (
+		fetchedMu sync.Mutex
+		fetched   = make(map[string]time.Time)
+		failed    int
+		wg        sync.WaitGroup
+	)
+
+	// El árbol remoto tiene una rama por carpeta compartida; solo se
+	// sincronizan las que existen aquí, aceptan cambios e incluyen al peer
+	for _, remoteShare := range remoteTree.Children {
+		s, ok := share.Get(remoteShare.Name)
+		if !ok || !s.CanReceive() || !sharesWith(s, peerInfo) {
+			continue
+		}
+		placed := p.placedHere(s)
 
-	localFiles, err := fs.ListFiles("shared")
-	if err != nil {
-		fmt.Println("❌ Error al listar archivos locales:", err)
-		return
-	}
-	localMap := make(map[string]time.Time)
-	for _, f := range localFiles {
-		localMap[f.Name] = f.ModTime
-	}
-
-	for name, remoteTime := range remoteMap {
-		cachedTime, seen := cacheMap[name]
-		if !seen || remoteTime.After(cachedTime) {
-			fmt.Printf("📥 Descargando archivo actualizado: %s\n", name)
-			if err := p.RequestRemoteFile(name, addr); err != nil {
-				fmt.Printf("⚠️ Fallo al sincronizar %s: %v\n", name, err)
+		for rel, f := range fs.FlattenTreePaths(remoteShare) {
+			if s.Ignored(rel, false) {
 				continue
 			}
-			logger.AppendToLocalLog(logger.Operation{
-				Type:      "SYNC_FILE",
-				FileName:  name,
-				From:      addr,
-				Timestamp: time.Now().Unix(),
-				Message:   "Archivo sincronizado tras reconexión",
-			})
-			cacheMap[name] = remoteTime
+			// Con factor de replicación solo se traen los archivos asignados
+			// a este nodo y las novedades de los que ya guarda
+			if _, err := os.Lstat(s.LocalPath(rel)); err != nil && !placed(rel) {
+				continue
+			}
+			// Un enlace que sale de la carpeta no se puede replicar
+			if f.Link != "" && !fs.LinkInside(rel, f.Link) {
+				continue
+			}
+			name := s.Key(rel)
+			cachedTime, seen := cacheMap[name]
+			if seen && !f.ModTime.After(cachedTime) {
+				continue
+			}
+			// Las fechas se conservan al replicar: si coinciden, ya está al
+			// día. La de un enlace no se puede fijar, así que se compara su destino.
+			if local, err := os.Lstat(s.LocalPath(rel)); err == nil && local.ModTime().Equal(f.ModTime) {
+				cacheMap[name] = f.ModTime
+				continue
+			}
+			if target, err := os.Readlink(s.LocalPath(rel)); err == nil && f.Link != "" && target == f.Link {
+				cacheMap[name] = f.ModTime
+				continue
+			}
+			if ctx.Err() != nil {
+				break
+			}
+			wg.Add(1)
+			go func(s *share.Share, rel, name string, f fs.FileNode) {
+				defer wg.Done()
+				release := acquireSlotContext(ctx, Background, f.Size, addr)
+				defer release()
+				if ctx.Err() != nil {
+					return
+				}
+				// Mientras esperaba turno otra sincronización pudo traerlo
+				if !startDownload(name) {
+					return
+				}
+				defer endDownload(name)
+				if local, err := os.Lstat(s.LocalPath(rel)); err == nil && local.ModTime().Equal(f.ModTime) {
+					return
+				}
+				fmt.Printf("📥 Descargando archivo actualizado: %s\n", name)
+				// Los archivos grandes se reparten entre todos los peers que
+				// los tengan; cada bloque espera su propio turno
+				fetch := func() error { return p.requestRemoteFile(name, addr, false) }
+				if f.Size >= SwarmMinSize && f.Link == "" {
+					release()
+					fetch = func() error { return p.swarmFetch(name, p.swarmSources(s, addr), false) }
+				}
+				err := fetch()
+				if errors.Is(err, ErrCancelled) {
+					// Cancelada por el usuario: no cuenta como fallo
+					return
+				}
+				if err != nil {
+					fmt.Printf("⚠️ Fallo al sincronizar %s: %v\n", name, err)
+					fetchedMu.Lock()
+					failed++
+					fetchedMu.Unlock()
+					return
+				}
+				logger.AppendToLocalLog(logger.Operation{
+					Type:      "SYNC_FILE",
+					FileName:  name,
+					From:      addr,
+					Timestamp: time.Now().Unix(),
+					Message:   "Archivo sincronizado tras reconexión",
+				})
+				fetchedMu.Lock()
+				fetched[name] = f.ModTime
+				fetchedMu.Unlock()
+			}(s, rel, name, f)
+		}
+		wg.Wait()
+
+		if s.EmptyDirs {
+			createEmptyDirs(s, remoteShare)
 		}
 	}
 
+	for name, mod := range fetched {
+		cacheMap[name] = mod
+	}
 	var updated []state.FileInfo
 	for name, mod := range cacheMap {
 		updated = append(updated, state.FileInfo{
@@ -535,64 +806,64 @@ func (p *Peer) SyncWithPeer(peerInfo PeerInfo) {
 			ModTime: mod,
 		})
 	}
-	state.FileCache[peerInfo.IP] = updated
-	state.SaveState()
+	state.UpdateFileCache(CacheKey(peerInfo), updated)
+	if ctx.Err() != nil {
+		fmt.Printf("⏹️ Sincronización con %s interrumpida\n", addr)
+		return ErrShutdown
+	}
+	if failed > 0 {
+		fmt.Printf("⚠️ Sincronización con %s incompleta: %d archivo(s) fallaron\n", addr, failed)
+		return fmt.Errorf("%d archivo(s) no se pudieron descargar", failed)
+	}
 	fmt.Printf("✅ Sincronización completa con %s\n", addr)
+	return nil
 }
 
-func (p *Peer) handleList(conn net.Conn) {
-	tree, err := fs.BuildFileTree("shared")
-	if err != nil {
-		return
+// createEmptyDirs crea las carpetas vacías del árbol remoto que faltan aquí
+func createEmptyDirs(s *share.Share, tree fs.FileNode) {
+	for rel, dir := range fs.FlattenTreeDirs(tree) {
+		if len(dir.Children) > 0 || s.Ignored(rel, true) {
+			continue
+		}
+		local := s.LocalPath(rel)
+		if _, err := os.Lstat(local); err == nil {
+			continue
+		}
+		if err := os.MkdirAll(local, 0755); err != nil {
+			fmt.Printf("⚠️ No se pudo crear la carpeta %s: %v\n", s.Key(rel), err)
+			continue
+		}
+		fs.ApplyMeta(local, fs.Meta{Mode: dir.Mode, ModTime: dir.ModTime})
+		fmt.Printf("📁 Carpeta vacía creada: %s\n", s.Key(rel))
 	}
+}
+
+// handleList responde con las carpetas que el remitente puede leer
+func (p *Peer) handleList(conn net.Conn, msg message.Message) {
+	id := p.senderID(conn, msg)
+	host := remoteHost(conn)
+	tree := share.Tree(func(s *share.Share) bool {
+		return s.CanSend() && s.Allows(id, "", host)
+	})
 	resp := message.Message{
 		Type:     "LIST",
-		From:     strconv.Itoa(p.ID),
+		From:     strconv.Itoa(p.GetID()),
 		FileTree: &tree,
+		Accept:   compress.Supported,
 	}
 	data, _ := json.Marshal(resp)
 	conn.Write(data)
 }
 
 func (p *Peer) RequestFileTree(addr string) (*fs.FileNode, error) {
-	conn, err := net.Dial("tcp", addr)
-	if err != nil {
-		return nil, err
-	}
-	defer conn.Close()
-
-	msg := message.Message{
+	resp, err := p.roundTrip(addr, message.Message{
 		Type: "LIST",
-		From: strconv.Itoa(p.ID),
-	}
-	data, _ := json.Marshal(msg)
-	conn.Write(data)
-
-	response, err := io.ReadAll(conn)
+		From: strconv.Itoa(p.GetID()),
+	})
 	if err != nil {
 		return nil, err
 	}
-
-	var resp message.Message
-	if err := json.Unmarshal(response, &resp); err != nil {
-		return nil, err
-	}
+	learnEncodings(addr, resp.Accept)
 
 	return resp.FileTree, nil
 }
-
-func GetLocalIP() string {
-	addrs, err := net.InterfaceAddrs()
-	if err != nil {
-		return "127.0.0.1"
-	}
-	for _, addr := range addrs {
-		if ipnet, ok := addr.(*net.IPNet); ok && !ipnet.IP.IsLoopback() {
-			if ipnet.IP.To4() != nil {
-				return ipnet.IP.String()
-			}
-		}
-	}
-	return "127.0.0.1"
-}
-
@@ -1,23 +1,38 @@
 package peer
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"net"
 	"os"
+	"p2pfs/internal/fs"
+	logger "p2pfs/internal/log"
+	"path/filepath"
+	"time"
 )
 
-// SavePeersToFile guarda la lista de peers en un archivo JSON
+// PeersFile es la ruta donde se persiste la lista de peers conocidos
+var PeersFile = "config/peers.json"
+
+// PeerExpiry es el tiempo que un peer puede seguir inalcanzable antes de olvidarlo
+var PeerExpiry = 72 * time.Hour
+
+// SavePeersToFile guarda la lista de peers en un archivo JSON. Se escribe
+// en un temporal que sustituye al anterior, para que una caída a mitad no
+// deje la lista truncada.
 func SavePeersToFile(peers []PeerInfo, filename string) error {
-	file, err := os.Create(filename)
-	if err != nil {
-		return fmt.Errorf("no se pudo crear el archivo: %w", err)
+	if err := os.MkdirAll(filepath.Dir(filename), 0755); err != nil {
+		return fmt.Errorf("no se pudo crear el directorio: %w", err)
 	}
-	defer file.Close()
 
-	encoder := json.NewEncoder(file)
-	encoder.SetIndent("", "  ")
-	if err := encoder.Encode(peers); err != nil {
-		return fmt.Errorf("no se pudo escribir el archivo JSON: %w", err)
+	data, err := json.MarshalIndent(peers, "", "  ")
+	if err != nil {
+		return fmt.Errorf("no se pudo codificar la lista de peers: %w", err)
+	}
+	if err := fs.WriteFileAtomic(filename, append(data, '\n'), 0644); err != nil {
+		return fmt.Errorf("no se pudo escribir el archivo: %w", err)
 	}
 	return nil
 }
@@ -38,3 +53,103 @@ func LoadPeersFromFile(filename string) ([]PeerInfo, error) {
 	return peers, nil
 }
 
+// LoadKnownPeers carga los peers persistidos, descarta los expirados y
+// contacta directamente a los restantes antes de recurrir al broadcast.
+// Retorna cuántos peers respondieron.
+func (p *Peer) LoadKnownPeers(filename string) int {
+	peers, err := LoadPeersFromFile(filename)
+	if err != nil {
+		if !errors.Is(err, os.ErrNotExist) {
+			fmt.Println("⚠️ No se pudo cargar la lista de peers:", err)
+		}
+		return 0
+	}
+
+	now := time.Now()
+	reachable := 0
+	for _, info := range peers {
+		key := net.JoinHostPort(info.IP, info.Port)
+
+		// Nuestra propia entrada: recuperar el ID de la ejecución anterior
+		if p.IsSelf(info) {
+			if p.ClaimID(info.ID) {
+				registerAssignedID(key, info.ID)
+				fmt.Printf("♻️ ID %d recuperado de %s\n", info.ID, filename)
+			}
+			continue
+		}
+
+		// Las entradas escritas a mano no tienen last_seen: se cuentan desde ahora
+		if info.LastSeen.IsZero() {
+			info.LastSeen = now
+		}
+		if now.Sub(info.LastSeen) > PeerExpiry {
+			fmt.Printf("⌛ Peer %s expirado, se descarta\n", key)
+			continue
+		}
+
+		if CheckPeerAlive(info) {
+			info.LastSeen = now
+			reachable++
+			fmt.Printf("✅ Peer conocido %s responde\n", key)
+		}
+
+		registerAssignedID(key, info.ID)
+		p.AddPeer(info)
+	}
+
+	fmt.Printf("📒 %d peer(s) cargados de %s, %d en línea\n", p.Peers.Len(), filename, reachable)
+	return reachable
+}
+
+// SaveKnownPeers olvida los peers que llevan más de PeerExpiry sin
+// responder y guarda el resto en disco.
+func (p *Peer) SaveKnownPeers(filename string) error {
+	now := time.Now()
+	var kept []PeerInfo
+	for _, info := range p.Peers.Snapshot() {
+		key := peerKey(info)
+
+		if p.IsSelf(info) {
+			info.ID = p.GetID()
+			info.Addrs = p.Addrs
+			info.LastSeen = now
+			kept = append(kept, info)
+			continue
+		}
+
+		if now.Sub(info.LastSeen) > PeerExpiry {
+			fmt.Printf("⌛ Peer %s sin respuesta desde %s, se elimina\n", key, info.LastSeen.Format(time.RFC3339))
+			logger.AppendToLocalLog(logger.Operation{
+				Type:      "PEER_EXPIRED",
+				From:      key,
+				Timestamp: now.Unix(),
+				Message:   fmt.Sprintf("Sin respuesta desde %s", info.LastSeen.Format(time.RFC3339)),
+			})
+			p.Peers.Remove(key)
+			forgetPeerHealth(key)
+			continue
+		}
+		kept = append(kept, info)
+	}
+
+	return SavePeersToFile(kept, filename)
+}
+
+// PersistPeers guarda periódicamente la lista de peers conocidos hasta que
+// se cancela ctx.
+func (p *Peer) PersistPeers(ctx context.Context, filename string, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		if err := p.SaveKnownPeers(filename); err != nil {
+			fmt.Println("⚠️ Error al guardar peers:", err)
+		}
+	}
+}
@@ -0,0 +1,205 @@
+package peer
+
+import (
+	"net"
+	"sync"
+	"time"
+)
+
+// Tipos de evento de membresía
+const (
+	PeerAdded   = "ADDED"
+	PeerUpdated = "UPDATED"
+	PeerRemoved = "REMOVED"
+)
+
+// PeerEvent notifica un cambio en la lista de peers
+type PeerEvent struct {
+	Type string
+	Peer PeerInfo
+}
+
+// Registry es la lista de peers conocidos, segura para uso concurrente.
+// Los peers se identifican por su dirección principal ip:puerto.
+type Registry struct {
+	mu      sync.RWMutex
+	peers   map[string]PeerInfo
+	order   []string // orden de llegada, para snapshots estables
+	subs    map[int]chan PeerEvent
+	nextSub int
+}
+
+// NewRegistry crea un registro con los peers iniciales dados
+func NewRegistry(initial ...PeerInfo) *Registry {
+	r := &Registry{
+		peers: make(map[string]PeerInfo),
+		subs:  make(map[int]chan PeerEvent),
+	}
+	for _, info := range initial {
+		r.Add(info)
+	}
+	return r
+}
+
+func peerKey(info PeerInfo) string {
+	return net.JoinHostPort(info.IP, info.Port)
+}
+
+// Add registra un peer o, si ya existe, completa su ID y sus direcciones.
+// Retorna true si el peer es nuevo.
+func (r *Registry) Add(info PeerInfo) bool {
+	key := peerKey(info)
+
+	r.mu.Lock()
+	existing, found := r.peers[key]
+	if !found {
+		if info.LastSeen.IsZero() {
+			info.LastSeen = time.Now()
+		}
+		info.Addrs = append([]string(nil), info.Addrs...)
+		r.peers[key] = info
+		r.order = append(r.order, key)
+		r.mu.Unlock()
+		r.publish(PeerEvent{Type: PeerAdded, Peer: info})
+		return true
+	}
+
+	changed := false
+	if existing.ID == 0 && info.ID != 0 {
+		existing.ID = info.ID
+		changed = true
+	}
+	if merged := mergeHosts(append([]string(nil), existing.Addrs...), info.Addrs...); len(merged) != len(existing.Addrs) {
+		existing.Addrs = merged
+		changed = true
+	}
+	r.peers[key] = existing
+	r.mu.Unlock()
+
+	if changed {
+		r.publish(PeerEvent{Type: PeerUpdated, Peer: existing})
+	}
+	return false
+}
+
+// Update modifica un peer existente con fn. Retorna false si no existe.
+func (r *Registry) Update(key string, fn func(info *PeerInfo)) bool {
+	r.mu.Lock()
+	info, found := r.peers[key]
+	if !found {
+		r.mu.Unlock()
+		return false
+	}
+	fn(&info)
+	r.peers[key] = info
+	r.mu.Unlock()
+
+	r.publish(PeerEvent{Type: PeerUpdated, Peer: info})
+	return true
+}
+
+// Touch marca que el peer respondió, sin notificar a los suscriptores
+func (r *Registry) Touch(key string, seen time.Time) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if info, found := r.peers[key]; found && seen.After(info.LastSeen) {
+		info.LastSeen = seen
+		r.peers[key] = info
+	}
+}
+
+// Remove elimina un peer. Retorna false si no existía.
+func (r *Registry) Remove(key string) bool {
+	r.mu.Lock()
+	info, found := r.peers[key]
+	if !found {
+		r.mu.Unlock()
+		return false
+	}
+	delete(r.peers, key)
+	for i, k := range r.order {
+		if k == key {
+			r.order = append(r.order[:i], r.order[i+1:]...)
+			break
+		}
+	}
+	r.mu.Unlock()
+
+	r.publish(PeerEvent{Type: PeerRemoved, Peer: info})
+	return true
+}
+
+// Get retorna el peer con la dirección principal dada
+func (r *Registry) Get(key string) (PeerInfo, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	info, found := r.peers[key]
+	return info, found
+}
+
+// FindByID retorna el primer peer con el ID dado
+func (r *Registry) FindByID(id int) (PeerInfo, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	for _, key := range r.order {
+		if r.peers[key].ID == id {
+			return r.peers[key], true
+		}
+	}
+	return PeerInfo{}, false
+}
+
+// Snapshot retorna una copia de la lista de peers en orden de llegada
+func (r *Registry) Snapshot() []PeerInfo {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	list := make([]PeerInfo, 0, len(r.order))
+	for _, key := range r.order {
+		info := r.peers[key]
+		info.Addrs = append([]string(nil), info.Addrs...)
+		list = append(list, info)
+	}
+	return list
+}
+
+// Len retorna cuántos peers hay registrados
+func (r *Registry) Len() int {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return len(r.peers)
+}
+
+// Subscribe entrega los cambios de membresía por un canal. Si el
+// suscriptor no consume a tiempo, los eventos se descartan en vez de
+// bloquear al registro. La función retornada cancela la suscripción.
+func (r *Registry) Subscribe() (<-chan PeerEvent, func()) {
+	ch := make(chan PeerEvent, 32)
+
+	r.mu.Lock()
+	id := r.nextSub
+	r.nextSub++
+	r.subs[id] = ch
+	r.mu.Unlock()
+
+	cancel := func() {
+		r.mu.Lock()
+		defer r.mu.Unlock()
+		if _, ok := r.subs[id]; ok {
+			delete(r.subs, id)
+			close(ch)
+		}
+	}
+	return ch, cancel
+}
+
+func (r *Registry) publish(ev PeerEvent) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	for _, ch := range r.subs {
+		select {
+		case ch <- ev:
+		default:
+		}
+	}
+}
@@ -0,0 +1,139 @@
+package peer
+
+import (
+	"runtime"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+func testPeer(n int) PeerInfo {
+	return PeerInfo{ID: n, IP: "127.0.0.1", Port: strconv.Itoa(20000 + n)}
+}
+
+// Muchas goroutines añaden, tocan, actualizan y quitan peers mientras otras
+// leen snapshots y un suscriptor consume los eventos; con -race no debe
+// haber carreras y al final solo quedan los peers que no se quitaron
+func TestRegistryConcurrent(t *testing.T) {
+	const (
+		writers = 8
+		perW    = 200
+	)
+	r := NewRegistry()
+
+	events, unsubscribe := r.Subscribe()
+	drained := make(chan struct{})
+	go func() {
+		defer close(drained)
+		for range events {
+		}
+	}()
+
+	stop := make(chan struct{})
+	var readers sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		readers.Add(1)
+		go func() {
+			defer readers.Done()
+			for {
+				select {
+				case <-stop:
+					return
+				default:
+				}
+				for _, info := range r.Snapshot() {
+					// La copia es del llamador: modificarla no toca el registro
+					info.Addrs = append(info.Addrs, "192.0.2.1")
+				}
+				r.Len()
+				r.FindByID(1)
+				// Con una sola CPU, lectores sin pausa dejan sin turno a los escritores
+				runtime.Gosched()
+			}
+		}()
+	}
+
+	var writersWG sync.WaitGroup
+	for w := 0; w < writers; w++ {
+		writersWG.Add(1)
+		go func(w int) {
+			defer writersWG.Done()
+			for i := 0; i < perW; i++ {
+				info := testPeer(w*perW + i)
+				key := peerKey(info)
+				r.Add(info)
+				r.Touch(key, time.Now())
+				r.Update(key, func(p *PeerInfo) { p.Addrs = append(p.Addrs, "198.51.100.1") })
+				if i%2 == 0 {
+					if !r.Remove(key) {
+						t.Errorf("Remove(%s) = false, se acababa de añadir", key)
+					}
+				}
+			}
+		}(w)
+	}
+	writersWG.Wait()
+	close(stop)
+	readers.Wait()
+	unsubscribe()
+	<-drained
+
+	if got, want := r.Len(), writers*perW/2; got != want {
+		t.Fatalf("Len() = %d, want %d", got, want)
+	}
+	snap := r.Snapshot()
+	if len(snap) != r.Len() {
+		t.Fatalf("Snapshot() tiene %d peers, Len() = %d", len(snap), r.Len())
+	}
+	for _, info := range snap {
+		if len(info.Addrs) != 1 {
+			t.Fatalf("%s tiene direcciones %v; las copias de Snapshot no deben afectar al registro", peerKey(info), info.Addrs)
+		}
+	}
+}
+
+// Añadir un peer ya conocido completa su ID sin duplicarlo
+func TestRegistryAddMerges(t *testing.T) {
+	r := NewRegistry()
+	info := testPeer(1)
+	info.ID = 0
+
+	if !r.Add(info) {
+		t.Fatal("el primer Add debería registrar un peer nuevo")
+	}
+	info.ID = 7
+	if r.Add(info) {
+		t.Fatal("el segundo Add no debería registrar otro peer")
+	}
+	got, ok := r.Get(peerKey(info))
+	if !ok || got.ID != 7 {
+		t.Fatalf("Get() = %+v, %v; se esperaba ID 7", got, ok)
+	}
+	if r.Len() != 1 {
+		t.Fatalf("Len() = %d, want 1", r.Len())
+	}
+}
+
+// Los suscriptores reciben alta, cambio y baja en orden
+func TestRegistryEvents(t *testing.T) {
+	r := NewRegistry()
+	events, unsubscribe := r.Subscribe()
+	defer unsubscribe()
+
+	info := testPeer(1)
+	r.Add(info)
+	r.Update(peerKey(info), func(p *PeerInfo) { p.ID = 2 })
+	r.Remove(peerKey(info))
+
+	for _, want := range []string{PeerAdded, PeerUpdated, PeerRemoved} {
+		select {
+		case ev := <-events:
+			if ev.Type != want {
+				t.Fatalf("evento %s, want %s", ev.Type, want)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("no llegó el evento %s", want)
+		}
+	}
+}
@@ -0,0 +1,285 @@
+package peer
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+
+	"p2pfs/internal/compress"
+	"p2pfs/internal/fs"
+	logger "p2pfs/internal/log"
+	"p2pfs/internal/message"
+	"p2pfs/internal/placement"
+	"p2pfs/internal/share"
+)
+
+// RepairAfter es el tiempo sin responder tras el que un nodo se da por
+// perdido y se reponen en otros las copias que guardaba
+var RepairAfter = time.Hour
+
+// inventory es lo último que se supo de lo que guarda un peer
+type inventory struct {
+	tree *fs.FileNode
+	free map[string]int64 // carpetas que acepta copias y su espacio libre
+	full bool             // false si el peer solo respondió a LIST
+}
+
+var (
+	inventoriesMu sync.Mutex
+	inventories   = make(map[string]inventory)
+)
+
+func lastInventory(addr string) (inventory, bool) {
+	inventoriesMu.Lock()
+	defer inventoriesMu.Unlock()
+	inv, ok := inventories[addr]
+	return inv, ok
+}
+
+// requestInventory pide a addr todo lo que guarda de las carpetas que
+// comparte con este nodo, sea cual sea su modo. Los nodos anteriores no
+// lo entienden: de ellos vale su respuesta a LIST.
+func (p *Peer) requestInventory(addr string) (inventory, error) {
+	resp, err := p.roundTrip(addr, message.Message{Type: "INVENTORY", From: strconv.Itoa(p.GetID())})
+	inv := inventory{tree: resp.FileTree, free: resp.Free, full: true}
+	if err != nil || resp.Type != "INVENTORY" {
+		tree, lerr := p.RequestFileTree(addr)
+		if lerr != nil {
+			return inv, lerr
+		}
+		inv = inventory{tree: tree}
+	}
+	learnEncodings(addr, resp.Accept)
+	inventoriesMu.Lock()
+	inventories[addr] = inv
+	inventoriesMu.Unlock()
+	return inv, nil
+}
+
+// handleInventory responde con el árbol de todas las carpetas que el
+// remitente puede ver y el espacio libre de las que aceptan copias
+func (p *Peer) handleInventory(conn net.Conn, msg message.Message) {
+	id := p.senderID(conn, msg)
+	host := remoteHost(conn)
+	tree := share.Tree(func(s *share.Share) bool {
+		return s.Allows(id, "", host)
+	})
+	free := make(map[string]int64)
+	for _, s := range share.All() {
+		if s.CanReceive() && s.Allows(id, "", host) {
+			free[s.Name] = fs.FreeSpace(s.Path)
+		}
+	}
+	data, _ := json.Marshal(message.Message{
+		Type:     "INVENTORY",
+		From:     strconv.Itoa(p.GetID()),
+		FileTree: &tree,
+		Free:     free,
+		Accept:   compress.Supported,
+	})
+	conn.Write(data)
+}
+
+// replicaNode es un nodo que comparte una carpeta
+type replicaNode struct {
+	placement.Node
+	online  bool
+	accepts bool // acepta copias de otros nodos
+}
+
+// replicaNodes devuelve este nodo y los peers con ID que comparten s,
+// salvo los que llevan más de RepairAfter sin responder
+func (p *Peer) replicaNodes(s *share.Share) []replicaNode {
+	nodes := []replicaNode{{
+		Node:    placement.Node{ID: p.GetID(), Addr: p.Addr(), Free: fs.FreeSpace(s.Path), Self: true},
+		online:  true,
+		accepts: s.CanReceive(),
+	}}
+	seen := map[int]bool{p.GetID(): true}
+	for _, info := range p.Peers.Snapshot() {
+		if info.ID == 0 || seen[info.ID] || p.IsSelf(info) || !sharesWith(s, info) {
+			continue
+		}
+		online := IsPeerOnline(info)
+		if !online && time.Since(info.LastSeen) > RepairAfter {
+			continue
+		}
+		seen[info.ID] = true
+		addr := PeerAddr(info)
+		n := replicaNode{Node: placement.Node{ID: info.ID, Addr: addr}, online: online, accepts: true}
+		// Sin inventario se supone que acepta copias
+		if inv, ok := lastInventory(addr); ok && inv.full {
+			n.Free, n.accepts = inv.free[s.Name]
+		}
+		nodes = append(nodes, n)
+	}
+	return nodes
+}
+
+// placedHere devuelve una función que indica si, según la política de la
+// carpeta, este nodo es uno de los que deben guardar rel. Sin factor de
+// replicación lo guardan todos.
+func (p *Peer) placedHere(s *share.Share) func(rel string) bool {
+	if s.Replicas <= 0 {
+		return func(string) bool { return true }
+	}
+	var candidates []placement.Node
+	for _, n := range p.replicaNodes(s) {
+		if n.accepts {
+			candidates = append(candidates, n.Node)
+		}
+	}
+	return func(rel string) bool {
+		ranked := placement.Rank(s.Key(rel), candidates, s.Placement)
+		for i := 0; i < len(ranked) && i < s.Replicas; i++ {
+			if ranked[i].Self {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+// RepairResult resume una pasada del reparador por una carpeta
+type RepairResult struct {
+	Share   string   `json:"share"`
+	Files   int      `json:"files"`   // archivos locales revisados
+	Missing int      `json:"missing"` // con menos copias de las debidas
+	Sent    int      `json:"sent"`    // copias enviadas
+	Errors  []string `json:"errors,omitempty"`
+}
+
+// RepairWorker comprueba periódicamente el factor de replicación hasta que
+// se cancela ctx
+func (p *Peer) RepairWorker(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		if p.GetID() != 0 {
+			p.Repair()
+		}
+	}
+}
+
+// Repair revisa las carpetas con factor de replicación y envía copias de
+// los archivos locales que están en menos nodos de los debidos
+func (p *Peer) Repair() []RepairResult {
+	results := []RepairResult{}
+	for _, s := range share.All() {
+		if s.Replicas <= 0 || !s.CanSend() {
+			continue
+		}
+		res := p.repairShare(s)
+		if res.Missing > 0 || len(res.Errors) > 0 {
+			fmt.Printf("🩹 %s: %d archivo(s) con copias de menos, %d copia(s) enviadas\n", s.Name, res.Missing, res.Sent)
+		}
+		results = append(results, res)
+	}
+	return results
+}
+
+// repairShare repara una carpeta. De cada archivo local se cuentan los
+// nodos que tienen la misma versión: los peers en línea se consultan y de
+// los que no responden pero aún no se dan por perdidos vale lo último que
+// se supo. Si faltan copias, solo actúa el primero de los que la tienen
+// por hash, para que no se envíen varias veces, y las envía a
+// los siguientes nodos del orden de la política que estén en línea.
+func (p *Peer) repairShare(s *share.Share) RepairResult {
+	res := RepairResult{Share: s.Name}
+	tree, err := fs.BuildFileTree(s.Path, fs.TreeOptions{Skip: s.Ignored, Links: s.Symlinks})
+	if err != nil {
+		res.Errors = append(res.Errors, err.Error())
+		return res
+	}
+
+	holdings := make(map[int]map[string]fs.FileNode)
+	for _, n := range p.replicaNodes(s) {
+		if n.Self {
+			continue
+		}
+		inv, err := p.requestInventory(n.Addr)
+		if err != nil {
+			var ok bool
+			if inv, ok = lastInventory(n.Addr); !ok {
+				continue
+			}
+		}
+		if inv.tree == nil {
+			continue
+		}
+		for _, child := range inv.tree.Children {
+			if child.Name == s.Name {
+				holdings[n.ID] = fs.FlattenTreePaths(child)
+			}
+		}
+	}
+	// Ya con el espacio libre de cada peer al día
+	nodes := p.replicaNodes(s)
+
+	for rel, f := range fs.FlattenTreePaths(tree) {
+		res.Files++
+		key := s.Key(rel)
+		// Cuentan como copias las de cualquier nodo, acepte o no copias de
+		// otros (p. ej. este mismo si es send-only); los que responden
+		// actúan y los que aceptan copias pueden recibirlas
+		var holders, acting, targets []placement.Node
+		newer := false
+		for _, n := range nodes {
+			remote, ok := holdings[n.ID][rel]
+			switch {
+			case n.Self:
+				holders = append(holders, n.Node)
+				acting = append(acting, n.Node)
+			case ok && remote.ModTime.After(f.ModTime) && remote.Link == "" && f.Link == "":
+				newer = true
+			case ok && (remote.ModTime.Equal(f.ModTime) || (f.Link != "" && remote.Link == f.Link)):
+				holders = append(holders, n.Node)
+				if n.online {
+					acting = append(acting, n.Node)
+				}
+			case n.online && n.accepts:
+				targets = append(targets, n.Node)
+			}
+		}
+		// Si otro nodo tiene una versión más reciente la traerá la sincronización
+		need := s.Replicas - len(holders)
+		if newer || need <= 0 {
+			continue
+		}
+		res.Missing++
+		// Quién actúa se decide siempre por hash: el espacio libre que
+		// conoce cada nodo puede diferir y todos deben elegir al mismo
+		if ranked := placement.Rank(key, acting, placement.Hash); !ranked[0].Self {
+			continue
+		}
+		for _, t := range placement.Rank(key, targets, s.Placement) {
+			if need == 0 {
+				break
+			}
+			if err := p.sendFile(s.LocalPath(rel), t.Addr, false, Background); err != nil {
+				res.Errors = append(res.Errors, fmt.Sprintf("%s → %s: %v", key, t.Addr, err))
+				continue
+			}
+			need--
+			res.Sent++
+			logger.AppendToLocalLog(logger.Operation{
+				Type:      "REPAIR",
+				FileName:  key,
+				From:      p.Addr(),
+				Timestamp: time.Now().Unix(),
+				Message:   fmt.Sprintf("Copia repuesta en %s (%d de %d)", t.Addr, s.Replicas-need, s.Replicas),
+			})
+		}
+	}
+	return res
+}
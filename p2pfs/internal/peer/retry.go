@@ -0,0 +1,179 @@
+package peer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"p2pfs/internal/jobs"
+	logger "p2pfs/internal/log"
+	"p2pfs/internal/share"
+)
+
+// queueJob encola un trabajo que falló para reintentarlo más tarde. Si ya
+// estaba en la cola (p. ej. porque el que falla es su propio reintento) no
+// se duplica.
+func (p *Peer) queueJob(job jobs.Job, err error) {
+	if err != nil {
+		job.LastError = err.Error()
+	}
+	job, created := jobs.Add(job)
+	if !created {
+		return
+	}
+	fmt.Printf("🕓 %s encolado para reintentar (%s)\n", describeJob(job), job.ID)
+	logger.AppendToLocalLog(logger.Operation{
+		Type:      "RETRY_QUEUED",
+		FileName:  job.FileName,
+		From:      p.Addr(),
+		Timestamp: time.Now().Unix(),
+		Message:   fmt.Sprintf("%s hacia %s: %s", job.Type, job.To, job.LastError),
+	})
+}
+
+// describeJob resume un trabajo para los mensajes
+func describeJob(job jobs.Job) string {
+	switch job.Type {
+	case jobs.Sync:
+		return fmt.Sprintf("Sincronización con %s", job.To)
+	case jobs.Delete:
+		return fmt.Sprintf("Borrado de %s en %s", job.FileName, job.To)
+	}
+	return fmt.Sprintf("Envío de %s a %s", job.FileName, job.To)
+}
+
+// RetryWorker reintenta periódicamente los trabajos a los que les toca
+// hasta que se cancela ctx
+func (p *Peer) RetryWorker(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		if p.GetID() != 0 {
+			p.runJobs(ctx, false)
+		}
+	}
+}
+
+// RetryPending reintenta ahora todos los trabajos pendientes, les toque o
+// no, y devuelve cuántos siguen en la cola sin contar los fallidos
+func (p *Peer) RetryPending() int {
+	p.runJobs(p.tasksContext(), true)
+	pending, _ := jobs.Counts()
+	return pending
+}
+
+// runJobs ejecuta a la vez los trabajos que tocan (todos los pendientes con
+// all); los envíos respetan el planificador de transferencias
+func (p *Peer) runJobs(ctx context.Context, all bool) {
+	due := jobs.Due(all)
+	if len(due) == 0 {
+		return
+	}
+	fmt.Printf("🔁 Reintentando %d trabajo(s)...\n", len(due))
+
+	var wg sync.WaitGroup
+	for _, job := range due {
+		wg.Add(1)
+		go func(job jobs.Job) {
+			defer wg.Done()
+			p.runJob(ctx, job)
+		}(job)
+	}
+	wg.Wait()
+}
+
+// runJob hace un intento de un trabajo y anota el resultado en la cola
+func (p *Peer) runJob(ctx context.Context, job jobs.Job) {
+	if reason := p.jobObsolete(job); reason != "" {
+		jobs.Done(job.ID)
+		logger.AppendToLocalLog(logger.Operation{
+			Type:      "RETRY_SKIPPED",
+			FileName:  job.FileName,
+			From:      p.Addr(),
+			Timestamp: time.Now().Unix(),
+			Message:   reason + ". Reintento omitido.",
+		})
+		return
+	}
+
+	var err error
+	switch job.Type {
+	case jobs.Transfer:
+		err = p.sendFile(job.FileName, job.To, job.Force, Background)
+	case jobs.Delete:
+		err = p.sendDelete(job.To, job.FileName)
+	case jobs.Sync:
+		err = p.syncWithPeer(ctx, p.lookupPeer(job.To))
+	default:
+		err = fmt.Errorf("tipo de trabajo desconocido %q", job.Type)
+	}
+	if errors.Is(err, ErrCancelled) {
+		// El usuario canceló la transferencia: no se vuelve a intentar
+		jobs.Cancel(job.ID)
+		return
+	}
+	if errors.Is(err, errSyncBusy) {
+		// La sincronización en curso hace lo mismo: se vuelve a mirar más
+		// tarde sin gastar un intento
+		jobs.Postpone(job.ID, jobs.Backoff)
+		return
+	}
+	if errors.Is(err, ErrShutdown) {
+		// Interrumpido al detener el nodo: no cuenta como intento y vuelve a
+		// quedar pendiente al arrancar
+		return
+	}
+	if err == nil {
+		jobs.Done(job.ID)
+		fmt.Printf("✅ %s completado tras %d reintento(s)\n", describeJob(job), job.Attempts+1)
+		logger.AppendToLocalLog(logger.Operation{
+			Type:      "RETRY_OK",
+			FileName:  job.FileName,
+			From:      p.Addr(),
+			Timestamp: time.Now().Unix(),
+			Message:   fmt.Sprintf("%s hacia %s completado", job.Type, job.To),
+		})
+		return
+	}
+
+	job, ok := jobs.Failed(job.ID, err)
+	if !ok || job.Status != jobs.Dead {
+		return
+	}
+	fmt.Printf("💀 %s abandonado tras %d intentos: %v\n", describeJob(job), job.Attempts, err)
+	logger.AppendToLocalLog(logger.Operation{
+		Type:      "RETRY_DEAD",
+		FileName:  job.FileName,
+		From:      p.Addr(),
+		Timestamp: time.Now().Unix(),
+		Message:   fmt.Sprintf("%s hacia %s abandonado tras %d intentos: %v", job.Type, job.To, job.Attempts, err),
+	})
+}
+
+// jobObsolete indica por qué un trabajo ya no tiene sentido, si es así:
+// el archivo a enviar ya no existe o el borrado se deshizo al volver a
+// crear el archivo
+func (p *Peer) jobObsolete(job jobs.Job) string {
+	switch job.Type {
+	case jobs.Transfer:
+		if _, err := os.Lstat(job.FileName); os.IsNotExist(err) {
+			return "Archivo eliminado"
+		}
+	case jobs.Delete:
+		if _, _, path, err := share.Resolve(job.FileName); err == nil {
+			if _, err := os.Lstat(path); err == nil {
+				return "El archivo se volvió a crear"
+			}
+		}
+	}
+	return ""
+}
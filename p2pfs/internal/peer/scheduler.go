@@ -0,0 +1,130 @@
+package peer
+
+import (
+	"context"
+	"sync"
+)
+
+// Priority ordena las transferencias en espera
+type Priority int
+
+const (
+	// Background son las de la sincronización y los reintentos
+	Background Priority = iota
+	// Interactive son las que pide el usuario (CLI, GUI, API)
+	Interactive
+)
+
+var (
+	// MaxTransfers es el máximo de transferencias simultáneas
+	MaxTransfers = 4
+	// MaxPeerTransfers es el máximo de transferencias simultáneas con un mismo peer
+	MaxPeerTransfers = 2
+)
+
+// waiter es una transferencia esperando turno
+type waiter struct {
+	prio  Priority
+	size  int64
+	peer  string
+	seq   int
+	ready chan struct{}
+}
+
+// before indica si w debe pasar antes que o: primero las del usuario,
+// luego las más pequeñas y, a igualdad, por orden de llegada
+func (w *waiter) before(o *waiter) bool {
+	if w.prio != o.prio {
+		return w.prio > o.prio
+	}
+	if w.size != o.size {
+		return w.size < o.size
+	}
+	return w.seq < o.seq
+}
+
+var (
+	schedMu     sync.Mutex
+	running     int
+	peerRunning = make(map[string]int)
+	waiting     []*waiter
+	nextSeq     int
+)
+
+// QueuedTransfers devuelve cuántas transferencias esperan turno
+func QueuedTransfers() int {
+	schedMu.Lock()
+	defer schedMu.Unlock()
+	return len(waiting)
+}
+
+// acquireSlot espera turno para una transferencia de size bytes con peer
+// (host:puerto o host) y devuelve la función que lo libera. Si el nodo se
+// detiene mientras espera, deja de esperar: la transferencia fallará al
+// empezar con ErrShutdown.
+func acquireSlot(prio Priority, size int64, peer string) func() {
+	return acquireSlotContext(transfersCtx, prio, size, peer)
+}
+
+// acquireSlotContext es acquireSlot para una transferencia ya en marcha:
+// deja de esperar también si se cancela ctx
+func acquireSlotContext(ctx context.Context, prio Priority, size int64, peer string) func() {
+	schedMu.Lock()
+	nextSeq++
+	w := &waiter{prio: prio, size: size, peer: peer, seq: nextSeq, ready: make(chan struct{})}
+	waiting = append(waiting, w)
+	dispatch()
+	schedMu.Unlock()
+
+	select {
+	case <-w.ready:
+	case <-ctx.Done():
+		schedMu.Lock()
+		for i, other := range waiting {
+			if other == w {
+				waiting = append(waiting[:i], waiting[i+1:]...)
+				schedMu.Unlock()
+				return func() {}
+			}
+		}
+		// Le dieron turno a la vez: se libera como cualquier otro
+		schedMu.Unlock()
+	}
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			schedMu.Lock()
+			running--
+			if peerRunning[peer]--; peerRunning[peer] <= 0 {
+				delete(peerRunning, peer)
+			}
+			dispatch()
+			schedMu.Unlock()
+		})
+	}
+}
+
+// dispatch da turno a las mejores transferencias en espera mientras haya
+// hueco; una cuyo peer está saturado deja pasar a las de otros peers.
+// Se llama con schedMu tomado.
+func dispatch() {
+	for running < MaxTransfers {
+		best := -1
+		for i, w := range waiting {
+			if peerRunning[w.peer] >= MaxPeerTransfers {
+				continue
+			}
+			if best < 0 || w.before(waiting[best]) {
+				best = i
+			}
+		}
+		if best < 0 {
+			return
+		}
+		w := waiting[best]
+		waiting = append(waiting[:best], waiting[best+1:]...)
+		running++
+		peerRunning[w.peer]++
+		close(w.ready)
+	}
+}
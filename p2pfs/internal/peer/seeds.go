@@ -0,0 +1,160 @@
+package peer
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"time"
+)
+
+// SeedsFile contiene direcciones host:puerto de nodos semilla
+var SeedsFile = "config/seeds.json"
+
+// SeedRefreshInterval es cada cuánto se vuelve a consultar a las semillas
+var SeedRefreshInterval = time.Minute
+
+// maxJoinPeers limita el recorrido transitivo de la lista de peers
+const maxJoinPeers = 64
+
+// LoadSeedsFromFile carga la lista de semillas (["host:puerto", ...])
+func LoadSeedsFromFile(filename string) ([]string, error) {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("no se pudo abrir el archivo: %w", err)
+	}
+
+	var seeds []string
+	if err := json.Unmarshal(data, &seeds); err != nil {
+		return nil, fmt.Errorf("no se pudo decodificar el archivo JSON: %w", err)
+	}
+	return seeds, nil
+}
+
+// JoinSeeds envía HELLO a cada semilla y, de forma transitiva, a cada peer
+// que éstas conozcan, fusionando las listas recibidas. Si el nodo aún no
+// tiene ID, adopta el que le asigne la primera semilla.
+// Retorna cuántos peers respondieron.
+func (p *Peer) JoinSeeds(seeds []string) int {
+	selfAddr := p.Addr()
+	visited := map[string]bool{selfAddr: true}
+	queue := append([]string{}, seeds...)
+	joined := 0
+
+	for len(queue) > 0 && len(visited) <= maxJoinPeers {
+		addr := queue[0]
+		queue = queue[1:]
+		if visited[addr] {
+			continue
+		}
+		visited[addr] = true
+
+		res, err := SendHelloAndReceivePeers(addr, HandshakeMessage{From: selfAddr, ID: p.GetID(), Addrs: p.Addrs})
+		if err != nil {
+			fmt.Printf("⚠️ Semilla %s no responde: %v\n", addr, err)
+			continue
+		}
+		joined++
+
+		if p.ClaimID(res.ID) {
+			registerAssignedID(selfAddr, res.ID)
+			fmt.Printf("✅ ID %d asignado por %s\n", res.ID, addr)
+		}
+
+		// Preferir la dirección que el peer anuncia sobre la usada para llegar a él
+		peerAddr := addr
+		if res.From != "" {
+			peerAddr = res.From
+			visited[peerAddr] = true
+		}
+		if info, err := parsePeerAddr(peerAddr); err == nil {
+			registerAssignedID(peerAddr, res.SelfID)
+			info.ID = res.SelfID
+			info.Addrs = res.Addrs
+			p.AddPeer(info)
+		}
+
+		var known []string
+		for _, k := range res.KnownPeers {
+			if info, err := parsePeerAddr(k); err == nil && !p.IsSelf(info) {
+				known = append(known, k)
+			}
+		}
+		MergePeerListsFromStrings(known, p.Peers)
+		queue = append(queue, known...)
+	}
+
+	fmt.Printf("🌱 Unión por semillas: %d peer(s) respondieron\n", joined)
+	return joined
+}
+
+// RejoinSeeds repite periódicamente la unión por semillas para descubrir
+// nodos que se hayan unido a la red desde otras subredes, hasta que se
+// cancela ctx.
+func (p *Peer) RejoinSeeds(ctx context.Context, seeds []string, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		p.JoinSeeds(seeds)
+	}
+}
+
+// handleHello responde a un HELLO recibido por TCP con WELCOME, asignando
+// un ID al emisor si aún no tiene uno y registrándolo como peer.
+func (p *Peer) handleHello(conn net.Conn, data []byte) {
+	var hello HandshakeMessage
+	if err := json.Unmarshal(data, &hello); err != nil {
+		fmt.Println("⚠️ HELLO inválido:", err)
+		return
+	}
+
+	// Nuestro propio ID nunca debe asignarse a otro nodo
+	registerAssignedID(p.Addr(), p.GetID())
+
+	assigned := hello.ID
+	if info, err := parsePeerAddr(hello.From); err == nil {
+		if assigned == 0 {
+			idMutex.Lock()
+			id, exists := assignedIDs[hello.From]
+			if !exists {
+				id = getNextAvailableID()
+				assignedIDs[hello.From] = id
+				fmt.Printf("🆕 Asignando ID %d a %s\n", id, hello.From)
+			}
+			idMutex.Unlock()
+			assigned = id
+		} else {
+			registerAssignedID(hello.From, assigned)
+		}
+		info.ID = assigned
+		info.Addrs = hello.Addrs
+		p.AddPeer(info)
+	}
+
+	var known []string
+	for _, info := range p.Peers.Snapshot() {
+		known = append(known, net.JoinHostPort(info.IP, info.Port))
+	}
+
+	response := HandshakeMessage{
+		Type:       "WELCOME",
+		From:       p.Addr(),
+		ID:         assigned,
+		SelfID:     p.GetID(),
+		Addrs:      p.Addrs,
+		KnownPeers: known,
+	}
+	resBytes, _ := json.Marshal(response)
+	conn.Write(append(resBytes, '\n'))
+}
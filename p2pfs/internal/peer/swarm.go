@@ -0,0 +1,505 @@
+package peer
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"p2pfs/internal/bandwidth"
+	"p2pfs/internal/compress"
+	"p2pfs/internal/fs"
+	logger "p2pfs/internal/log"
+	"p2pfs/internal/message"
+	"p2pfs/internal/share"
+	"p2pfs/internal/state"
+	"p2pfs/internal/utils"
+)
+
+// SwarmMinSize es el tamaño a partir del cual la sincronización descarga
+// un archivo por bloques, repartidos entre los peers que lo tienen
+var SwarmMinSize int64 = 4 * fs.ChunkSize
+
+const (
+	// chunkWorkers son los bloques que se piden a la vez a cada peer, sin
+	// pasar de MaxPeerTransfers; cada uno espera turno en la cola
+	chunkWorkers = 2
+	// maxChunkFailures son los fallos tras los que se deja de usar un peer
+	maxChunkFailures = 3
+)
+
+// chunkCache guarda los hashes de bloque de los archivos servidos mientras
+// no cambian, para no releerlos en cada petición
+var (
+	chunkCacheMu sync.Mutex
+	chunkCache   = make(map[string]chunkEntry)
+)
+
+type chunkEntry struct {
+	size    int64
+	modTime time.Time
+	chunks  []string
+	hash    string
+}
+
+func chunkHashes(path string, info os.FileInfo) (chunkEntry, error) {
+	chunkCacheMu.Lock()
+	e, ok := chunkCache[path]
+	chunkCacheMu.Unlock()
+	if ok && e.size == info.Size() && e.modTime.Equal(info.ModTime()) {
+		return e, nil
+	}
+	chunks, hash, err := fs.ChunkHashes(path)
+	if err != nil {
+		return e, err
+	}
+	e = chunkEntry{size: info.Size(), modTime: info.ModTime(), chunks: chunks, hash: hash}
+	chunkCacheMu.Lock()
+	chunkCache[path] = e
+	chunkCacheMu.Unlock()
+	return e, nil
+}
+
+// servedFile valida una petición de bloques y devuelve la ruta local
+func (p *Peer) servedFile(conn net.Conn, msg message.Message) (string, os.FileInfo, error) {
+	s, _, path, err := p.incomingShare(conn, msg)
+	if err != nil {
+		return "", nil, err
+	}
+	if !s.CanSend() {
+		return "", nil, fmt.Errorf("la carpeta %s es solo de recepción", s.Name)
+	}
+	info, err := os.Lstat(path)
+	if err != nil || !info.Mode().IsRegular() {
+		return "", nil, fmt.Errorf("no se pudo abrir el archivo")
+	}
+	return path, info, nil
+}
+
+// replyError responde a una petición con un mensaje de error
+func (p *Peer) replyError(conn net.Conn, msg message.Message, err error) {
+	data, _ := json.Marshal(message.Message{
+		Type:     "ERROR",
+		From:     strconv.Itoa(p.GetID()),
+		FileName: msg.FileName,
+		Data:     []byte(err.Error()),
+	})
+	conn.Write(data)
+}
+
+// handleChunks responde con el tamaño, los metadatos y los hashes de los
+// bloques de un archivo
+func (p *Peer) handleChunks(conn net.Conn, msg message.Message) {
+	path, info, err := p.servedFile(conn, msg)
+	var e chunkEntry
+	if err == nil {
+		if e, err = chunkHashes(path, info); err != nil {
+			err = fmt.Errorf("no se pudo leer el archivo")
+		}
+	}
+	if err != nil {
+		p.replyError(conn, msg, err)
+		return
+	}
+	data, _ := json.Marshal(message.Message{
+		Type:      "CHUNKS",
+		From:      strconv.Itoa(p.GetID()),
+		FileName:  msg.FileName,
+		Size:      e.size,
+		Chunks:    e.chunks,
+		Hash:      e.hash,
+		Accept:    compress.Supported,
+		Meta:      &fs.Meta{Mode: info.Mode().Perm(), ModTime: info.ModTime()},
+		Timestamp: info.ModTime().Unix(),
+	})
+	conn.Write(data)
+}
+
+// handleRequestChunk envía un bloque de un archivo
+func (p *Peer) handleRequestChunk(conn net.Conn, msg message.Message) {
+	path, _, err := p.servedFile(conn, msg)
+	var data []byte
+	if err == nil {
+		data, err = fs.ReadChunk(path, msg.Index)
+	}
+	if err != nil {
+		p.replyError(conn, msg, err)
+		return
+	}
+	payload, encoding := encodeData(msg.FileName, data, msg.Accept)
+	packet, _ := json.Marshal(message.Message{
+		Type:     "CHUNK",
+		From:     strconv.Itoa(p.GetID()),
+		FileName: msg.FileName,
+		Index:    msg.Index,
+		Data:     payload,
+		Hash:     utils.HashBytes(data),
+		Encoding: encoding,
+		RawSize:  int64(len(data)),
+	})
+	bandwidth.Writer(transfersCtx, conn, remoteHost(conn)).Write(packet)
+}
+
+// swarmSources devuelve addr seguido de los demás peers en línea con los
+// que se comparte s
+func (p *Peer) swarmSources(s *share.Share, addr string) []string {
+	sources := []string{addr}
+	for _, info := range p.Peers.Snapshot() {
+		other := PeerAddr(info)
+		if p.IsSelf(info) || other == addr || !IsPeerOnline(info) || !sharesWith(s, info) {
+			continue
+		}
+		sources = append(sources, other)
+	}
+	return sources
+}
+
+// swarmFetch descarga fileName repartiendo sus bloques entre los peers
+// addrs que tienen la misma versión que addrs[0]. Cada bloque se comprueba
+// con su hash; si falla se pide a otro peer, los peers que fallan varias
+// veces dejan de usarse y, cuando ya no quedan bloques sin pedir, los que
+// siguen en curso se piden también a los peers libres para que uno lento no
+// retrase el final. Si el archivo es pequeño o ningún peer admite bloques
+// se descarga entero de addrs[0]. Quien llama ya ha comprobado que la
+// carpeta acepta el archivo.
+func (p *Peer) swarmFetch(fileName string, addrs []string, force bool) error {
+	s, rel, dest, err := share.Resolve(fileName)
+	if err != nil {
+		return err
+	}
+	primary := addrs[0]
+
+	// Qué versión tiene cada peer
+	manifests := make([]message.Message, len(addrs))
+	var wg sync.WaitGroup
+	for i, addr := range addrs {
+		wg.Add(1)
+		go func(i int, addr string) {
+			defer wg.Done()
+			resp, err := p.roundTrip(addr, message.Message{Type: "CHUNKS", From: strconv.Itoa(p.GetID()), FileName: fileName})
+			if err == nil && resp.Type == "CHUNKS" {
+				manifests[i] = resp
+			}
+		}(i, addr)
+	}
+	wg.Wait()
+
+	ref := manifests[0]
+	expected := int((ref.Size + fs.ChunkSize - 1) / fs.ChunkSize)
+	if ref.Type != "CHUNKS" || ref.Meta == nil || ref.Size < SwarmMinSize || len(ref.Chunks) != expected {
+		release := acquireSlot(Background, ref.Size, primary)
+		defer release()
+		return p.requestRemoteFile(fileName, primary, force)
+	}
+	var sources []string
+	for i, m := range manifests {
+		if m.Type == "CHUNKS" && m.Hash == ref.Hash {
+			sources = append(sources, addrs[i])
+			learnEncodings(addrs[i], m.Accept)
+		}
+	}
+
+	if info, err := os.Lstat(dest); err == nil && !force {
+		if info.ModTime().After(ref.Meta.ModTime) {
+			logger.AppendToLocalLog(logger.Operation{
+				Type:      "TIMESTAMP_CONFLICT",
+				FileName:  fileName,
+				From:      primary,
+				Timestamp: time.Now().Unix(),
+				Message:   "Archivo local más reciente. Descarga omitida.",
+			})
+			return nil
+		}
+	}
+
+	os.MkdirAll(filepath.Dir(dest), 0755)
+	tmp, err := fs.CreateAtomic(dest)
+	if err != nil {
+		return err
+	}
+	if err := tmp.Truncate(ref.Size); err != nil {
+		tmp.Abort()
+		return err
+	}
+
+	tr := trackTransfer("fetch", fileName, strings.Join(sources, ","), ref.Size)
+	workers := chunkWorkers
+	if MaxPeerTransfers < workers {
+		workers = MaxPeerTransfers
+	}
+	sw := newSwarm(ref, sources, workers)
+	stop := make(chan struct{})
+	go func() {
+		select {
+		case <-tr.ctx.Done():
+			sw.abort(ErrCancelled)
+		case <-stop:
+		}
+	}()
+	for _, addr := range sources {
+		for i := 0; i < workers; i++ {
+			go p.chunkWorker(tr, sw, fileName, addr, tmp)
+		}
+	}
+	err = sw.wait()
+	close(stop)
+	if err == nil {
+		if hash, herr := utils.CalculateSHA256(tmp.Name()); herr != nil || hash != ref.Hash {
+			err = fmt.Errorf("el archivo reconstruido no coincide con su hash")
+		}
+	}
+	err = tr.err(err)
+	tr.finish(err)
+	if errors.Is(err, ErrCancelled) || errors.Is(err, ErrShutdown) {
+		tmp.Abort()
+		return err
+	}
+	if err != nil {
+		tmp.Abort()
+		return fmt.Errorf("descarga por bloques de %s: %v", fileName, err)
+	}
+	if err := commitShared(s, rel, dest, tmp, ref.Hash, *ref.Meta); err != nil {
+		return fmt.Errorf("error al guardar archivo: %v", err)
+	}
+	recordReceived(s, rel, dest)
+
+	used := sw.used()
+	logger.AppendToLocalLog(logger.Operation{
+		Type:      "SWARM_RECV",
+		FileName:  fileName,
+		From:      primary,
+		Timestamp: time.Now().Unix(),
+		Message:   fmt.Sprintf("Archivo recibido en %d bloque(s) desde %d peer(s): %s", len(ref.Chunks), len(used), strings.Join(used, ", ")),
+	})
+	fmt.Printf("✅ Archivo %s recibido por bloques desde %s\n", fileName, strings.Join(used, ", "))
+
+	for _, addr := range sources {
+		state.SetFileCacheEntry(CacheKey(p.lookupPeer(addr)), state.FileInfo{Name: fileName, ModTime: ref.Meta.ModTime})
+	}
+	return nil
+}
+
+// chunkWorker pide a addr bloques del enjambre hasta que no quedan, addr
+// falla demasiadas veces o se cancela la transferencia tr. Cada bloque
+// ocupa un turno de la cola con addr, como cualquier otra transferencia.
+func (p *Peer) chunkWorker(tr *tracker, sw *swarm, fileName, addr string, out *fs.AtomicFile) {
+	for {
+		index, ok := sw.next(addr)
+		if !ok {
+			sw.leave(addr)
+			return
+		}
+		// Si se cancela mientras espera turno, fetchChunk falla enseguida
+		release := acquireSlotContext(tr.ctx, Background, fs.ChunkSize, addr)
+		data, err := p.fetchChunk(tr.ctx, fileName, addr, index, sw.chunks[index])
+		release()
+		if err == nil {
+			var written bool
+			if written, err = sw.complete(index, addr, data, out); written {
+				tr.add(int64(len(data)))
+			}
+		}
+		if tr.ctx.Err() != nil {
+			sw.leave(addr)
+			return
+		}
+		if err != nil {
+			fmt.Printf("⚠️ Bloque %d de %s desde %s: %v\n", index, fileName, addr, err)
+			if !sw.fail(index, addr) {
+				sw.leave(addr)
+				return
+			}
+		}
+	}
+}
+
+// fetchChunk descarga y comprueba un bloque
+func (p *Peer) fetchChunk(ctx context.Context, fileName, addr string, index int, hash string) ([]byte, error) {
+	resp, err := p.roundTripContext(ctx, addr, message.Message{
+		Type:     "REQUEST_CHUNK",
+		From:     strconv.Itoa(p.GetID()),
+		FileName: fileName,
+		Index:    index,
+		Accept:   compress.Supported,
+	}, nil)
+	if err != nil {
+		return nil, err
+	}
+	if resp.Type == "ERROR" {
+		return nil, fmt.Errorf("%s", resp.Data)
+	}
+	if resp.Type != "CHUNK" || resp.Index != index {
+		return nil, fmt.Errorf("respuesta inválida")
+	}
+	data, err := decodeData(resp)
+	if err != nil {
+		return nil, err
+	}
+	if utils.HashBytes(data) != hash {
+		return nil, fmt.Errorf("hash incorrecto")
+	}
+	return data, nil
+}
+
+// swarm reparte los bloques de una descarga entre los peers
+type swarm struct {
+	mu        sync.Mutex
+	cond      *sync.Cond
+	chunks    []string
+	pending   []int                   // bloques sin pedir
+	fetching  map[int]map[string]bool // quién está pidiendo cada bloque
+	done      []bool
+	remaining int
+	failures  map[string]int
+	workers   int
+	served    map[string]int // bloques aportados por cada peer
+	err       error
+}
+
+func newSwarm(ref message.Message, sources []string, workers int) *swarm {
+	sw := &swarm{
+		chunks:    ref.Chunks,
+		fetching:  make(map[int]map[string]bool),
+		done:      make([]bool, len(ref.Chunks)),
+		remaining: len(ref.Chunks),
+		failures:  make(map[string]int),
+		workers:   len(sources) * workers,
+		served:    make(map[string]int),
+	}
+	sw.cond = sync.NewCond(&sw.mu)
+	for i := range ref.Chunks {
+		sw.pending = append(sw.pending, i)
+	}
+	return sw
+}
+
+// next devuelve el siguiente bloque que debe pedir addr: uno sin pedir o,
+// si no queda ninguno, uno en curso en otro peer. Espera si no hay nada
+// que hacer y devuelve false al terminar.
+func (sw *swarm) next(addr string) (int, bool) {
+	sw.mu.Lock()
+	defer sw.mu.Unlock()
+	for {
+		if sw.remaining == 0 || sw.err != nil || sw.failures[addr] >= maxChunkFailures {
+			return 0, false
+		}
+		if len(sw.pending) > 0 {
+			index := sw.pending[0]
+			sw.pending = sw.pending[1:]
+			sw.claim(index, addr)
+			return index, true
+		}
+		for index, who := range sw.fetching {
+			if !sw.done[index] && len(who) == 1 && !who[addr] {
+				sw.claim(index, addr)
+				return index, true
+			}
+		}
+		sw.cond.Wait()
+	}
+}
+
+func (sw *swarm) claim(index int, addr string) {
+	if sw.fetching[index] == nil {
+		sw.fetching[index] = make(map[string]bool)
+	}
+	sw.fetching[index][addr] = true
+}
+
+func (sw *swarm) release(index int, addr string) {
+	delete(sw.fetching[index], addr)
+	if len(sw.fetching[index]) == 0 {
+		delete(sw.fetching, index)
+	}
+}
+
+// complete escribe un bloque recibido; si otro peer lo entregó antes se
+// descarta
+func (sw *swarm) complete(index int, addr string, data []byte, out *fs.AtomicFile) (bool, error) {
+	sw.mu.Lock()
+	sw.release(index, addr)
+	if sw.done[index] {
+		sw.mu.Unlock()
+		return false, nil
+	}
+	sw.done[index] = true
+	sw.mu.Unlock()
+
+	_, err := out.WriteAt(data, int64(index)*fs.ChunkSize)
+
+	sw.mu.Lock()
+	defer sw.mu.Unlock()
+	if err != nil {
+		sw.err = err
+	} else {
+		sw.remaining--
+		sw.served[addr]++
+	}
+	sw.cond.Broadcast()
+	return err == nil, err
+}
+
+// fail devuelve un bloque a la cola; false si addr ya no debe usarse
+func (sw *swarm) fail(index int, addr string) bool {
+	sw.mu.Lock()
+	defer sw.mu.Unlock()
+	sw.release(index, addr)
+	if !sw.done[index] && len(sw.fetching[index]) == 0 {
+		sw.pending = append([]int{index}, sw.pending...)
+	}
+	sw.failures[addr]++
+	sw.cond.Broadcast()
+	return sw.failures[addr] < maxChunkFailures
+}
+
+// leave anota que un trabajador terminó; si era el último y faltan
+// bloques, la descarga falla
+func (sw *swarm) leave(addr string) {
+	sw.mu.Lock()
+	defer sw.mu.Unlock()
+	sw.workers--
+	if sw.workers == 0 && sw.remaining > 0 && sw.err == nil {
+		sw.err = fmt.Errorf("ningún peer pudo entregar los %d bloque(s) restantes", sw.remaining)
+	}
+	sw.cond.Broadcast()
+}
+
+// abort hace fallar la descarga con err
+func (sw *swarm) abort(err error) {
+	sw.mu.Lock()
+	defer sw.mu.Unlock()
+	if sw.err == nil {
+		sw.err = err
+	}
+	sw.cond.Broadcast()
+}
+
+// wait espera a que la descarga termine o falle
+func (sw *swarm) wait() error {
+	sw.mu.Lock()
+	defer sw.mu.Unlock()
+	for sw.remaining > 0 && sw.err == nil {
+		sw.cond.Wait()
+	}
+	return sw.err
+}
+
+// used devuelve los peers que aportaron algún bloque
+func (sw *swarm) used() []string {
+	sw.mu.Lock()
+	defer sw.mu.Unlock()
+	var list []string
+	for addr, n := range sw.served {
+		list = append(list, fmt.Sprintf("%s (%d)", addr, n))
+	}
+	sort.Strings(list)
+	return list
+}
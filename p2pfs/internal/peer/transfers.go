@@ -0,0 +1,403 @@
+package peer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"sync"
+	"time"
+
+	"p2pfs/internal/bandwidth"
+	logger "p2pfs/internal/log"
+)
+
+// Estados de una transferencia
+const (
+	TransferRunning   = "running"
+	TransferDone      = "done"
+	TransferFailed    = "failed"
+	TransferCancelled = "cancelled"
+)
+
+// Tipos de evento de transferencia
+const (
+	TransferStarted  = "STARTED"
+	TransferProgress = "PROGRESS"
+	TransferFinished = "FINISHED"
+)
+
+// ErrCancelled indica que el usuario canceló la transferencia
+var ErrCancelled = errors.New("transferencia cancelada")
+
+// ErrShutdown indica que la transferencia se interrumpió porque el nodo se
+// está deteniendo; a diferencia de una cancelación, se reintenta al volver
+var ErrShutdown = errors.New("el nodo se está deteniendo")
+
+// progressEvery es el intervalo mínimo entre eventos de progreso de una
+// misma transferencia
+const progressEvery = 500 * time.Millisecond
+
+// Transfer describe una transferencia con otro peer
+type Transfer struct {
+	ID        int       `json:"id"`
+	Direction string    `json:"direction"` // send, fetch, serve o recv
+	FileName  string    `json:"filename"`
+	Peer      string    `json:"peer"`
+	Size      int64     `json:"size,omitempty"`
+	State     string    `json:"state"`
+	Bytes     int64     `json:"bytes"`           // bytes transferidos
+	Total     int64     `json:"total,omitempty"` // bytes a transferir; 0 si no se sabe
+	Rate      int64     `json:"rate"`            // bytes por segundo
+	ETA       float64   `json:"eta,omitempty"`   // segundos que faltan, si se sabe
+	Error     string    `json:"error,omitempty"`
+	StartedAt time.Time `json:"started_at"`
+}
+
+// TransferEvent notifica el inicio, el avance o el final de una transferencia
+type TransferEvent struct {
+	Type     string   `json:"type"`
+	Transfer Transfer `json:"transfer"`
+}
+
+// tracker sigue una transferencia en curso. Su contexto se cancela con
+// CancelTransfer o con StopTransfers; quien transfiere debe dejar de hacerlo
+// entonces.
+type tracker struct {
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	mu          sync.Mutex
+	t           Transfer
+	windowStart time.Time // ventana para calcular la velocidad
+	windowBytes int64
+	published   time.Time
+}
+
+var (
+	transfersMu     sync.Mutex
+	activeTransfers = make(map[int]*tracker)
+	nextTransferID  int
+
+	// transfersCtx es el contexto del que cuelgan todas las transferencias;
+	// StopTransfers lo cancela al detener el nodo
+	transfersCtx, stopTransfers = context.WithCancel(context.Background())
+
+	transferSubsMu  sync.Mutex
+	transferSubs    = make(map[int]chan TransferEvent)
+	nextTransferSub int
+)
+
+// trackTransfer registra una transferencia de size bytes (0 si no se
+// sabe) y devuelve su tracker; hay que llamar a finish al terminar
+func trackTransfer(direction, fileName, peer string, size int64) *tracker {
+	ctx, cancel := context.WithCancel(transfersCtx)
+	now := time.Now()
+	tr := &tracker{
+		ctx:         ctx,
+		cancel:      cancel,
+		windowStart: now,
+		published:   now,
+		t: Transfer{
+			Direction: direction,
+			FileName:  fileName,
+			Peer:      peer,
+			Size:      size,
+			Total:     size,
+			State:     TransferRunning,
+			StartedAt: now,
+		},
+	}
+
+	transfersMu.Lock()
+	nextTransferID++
+	tr.t.ID = nextTransferID
+	activeTransfers[tr.t.ID] = tr
+	transfersMu.Unlock()
+
+	publishTransfer(TransferEvent{Type: TransferStarted, Transfer: tr.snapshot()})
+	return tr
+}
+
+// describe completa el archivo y su tamaño cuando no se sabían al empezar
+func (tr *tracker) describe(fileName string, size int64) {
+	tr.mu.Lock()
+	tr.t.FileName, tr.t.Size = fileName, size
+	tr.mu.Unlock()
+}
+
+// setTotal fija los bytes que se van a transferir por la red
+func (tr *tracker) setTotal(total int64) {
+	tr.mu.Lock()
+	tr.t.Total = total
+	tr.mu.Unlock()
+}
+
+// restart vuelve a empezar la cuenta para un nuevo intento
+func (tr *tracker) restart() {
+	tr.mu.Lock()
+	tr.t.Bytes = 0
+	tr.windowStart, tr.windowBytes = time.Now(), 0
+	tr.mu.Unlock()
+}
+
+// add anota n bytes más transferidos
+func (tr *tracker) add(n int64) {
+	if n <= 0 {
+		return
+	}
+	now := time.Now()
+	tr.mu.Lock()
+	tr.t.Bytes += n
+	tr.windowBytes += n
+	if elapsed := now.Sub(tr.windowStart); elapsed >= time.Second {
+		tr.t.Rate = int64(float64(tr.windowBytes) / elapsed.Seconds())
+		tr.windowStart, tr.windowBytes = now, 0
+	}
+	publish := now.Sub(tr.published) >= progressEvery
+	if publish {
+		tr.published = now
+	}
+	tr.mu.Unlock()
+
+	if publish {
+		publishTransfer(TransferEvent{Type: TransferProgress, Transfer: tr.snapshot()})
+	}
+}
+
+// snapshot devuelve el estado actual con la velocidad media si aún no hay
+// una ventana completa y el tiempo restante si se conoce el total
+func (tr *tracker) snapshot() Transfer {
+	tr.mu.Lock()
+	defer tr.mu.Unlock()
+	t := tr.t
+	if t.Rate == 0 {
+		if elapsed := time.Since(t.StartedAt).Seconds(); elapsed > 0 {
+			t.Rate = int64(float64(t.Bytes) / elapsed)
+		}
+	}
+	if t.State == TransferRunning && t.Total > 0 && t.Rate > 0 && t.Bytes < t.Total {
+		t.ETA = float64(t.Total-t.Bytes) / float64(t.Rate)
+	}
+	return t
+}
+
+// finish da la transferencia por terminada según err
+func (tr *tracker) finish(err error) {
+	tr.mu.Lock()
+	switch {
+	case tr.ctx.Err() != nil:
+		tr.t.State = TransferCancelled
+		tr.t.Error = tr.err(nil).Error()
+	case err != nil:
+		tr.t.State = TransferFailed
+		tr.t.Error = err.Error()
+	default:
+		tr.t.State = TransferDone
+		if tr.t.Total > tr.t.Bytes {
+			tr.t.Bytes = tr.t.Total
+		}
+	}
+	if elapsed := time.Since(tr.t.StartedAt).Seconds(); elapsed > 0 {
+		tr.t.Rate = int64(float64(tr.t.Bytes) / elapsed)
+	}
+	tr.mu.Unlock()
+	tr.cancel()
+
+	transfersMu.Lock()
+	delete(activeTransfers, tr.t.ID)
+	transfersMu.Unlock()
+	publishTransfer(TransferEvent{Type: TransferFinished, Transfer: tr.snapshot()})
+}
+
+// err devuelve ErrShutdown o ErrCancelled si la transferencia se
+// interrumpió y, si no, err
+func (tr *tracker) err(err error) error {
+	switch {
+	case transfersCtx.Err() != nil && tr.ctx.Err() != nil:
+		return ErrShutdown
+	case tr.ctx.Err() != nil:
+		return ErrCancelled
+	}
+	return err
+}
+
+// writer cuenta lo que se escribe en w como progreso
+func (tr *tracker) writer(w io.Writer) io.Writer {
+	return &progressWriter{w: w, tr: tr}
+}
+
+// reader cuenta lo que se lee de r como progreso
+func (tr *tracker) reader(r io.Reader) io.Reader {
+	return &progressReader{r: r, tr: tr}
+}
+
+type progressWriter struct {
+	w  io.Writer
+	tr *tracker
+}
+
+func (pw *progressWriter) Write(b []byte) (int, error) {
+	n, err := pw.w.Write(b)
+	pw.tr.add(int64(n))
+	return n, err
+}
+
+type progressReader struct {
+	r  io.Reader
+	tr *tracker
+}
+
+func (pr *progressReader) Read(b []byte) (int, error) {
+	n, err := pr.r.Read(b)
+	pr.tr.add(int64(n))
+	return n, err
+}
+
+// closeOnCancel cierra conn (una conexión, un listener...) si ctx se
+// cancela antes de llamar a la función devuelta, lo que corta cualquier
+// lectura, escritura o Accept en curso
+func closeOnCancel(ctx context.Context, conn io.Closer) func() {
+	done := make(chan struct{})
+	go func() {
+		select {
+		case <-ctx.Done():
+			conn.Close()
+		case <-done:
+		}
+	}()
+	return func() { close(done) }
+}
+
+// ActiveTransfers devuelve las transferencias en curso, de la más antigua a la más reciente
+func ActiveTransfers() []Transfer {
+	transfersMu.Lock()
+	list := make([]Transfer, 0, len(activeTransfers))
+	for _, tr := range activeTransfers {
+		list = append(list, tr.snapshot())
+	}
+	transfersMu.Unlock()
+
+	sort.Slice(list, func(i, j int) bool { return list[i].ID < list[j].ID })
+	return list
+}
+
+// CancelTransfer cancela una transferencia en curso
+func CancelTransfer(id int) bool {
+	transfersMu.Lock()
+	tr, ok := activeTransfers[id]
+	transfersMu.Unlock()
+	if ok {
+		tr.cancel()
+	}
+	return ok
+}
+
+// StopTransfers interrumpe todas las transferencias en curso y hace que
+// las que empiecen después fallen con ErrShutdown
+func StopTransfers() {
+	stopTransfers()
+}
+
+// DrainTransfers espera, como mucho timeout, a que terminen las
+// transferencias en curso. Devuelve cuántas quedan.
+func DrainTransfers(timeout time.Duration) int {
+	deadline := time.Now().Add(timeout)
+	for {
+		transfersMu.Lock()
+		n := len(activeTransfers)
+		transfersMu.Unlock()
+		if n == 0 || !time.Now().Before(deadline) {
+			return n
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+}
+
+// SubscribeTransfers entrega los eventos de transferencia por un canal. Si
+// el suscriptor no consume a tiempo, los eventos se descartan en vez de
+// frenar las transferencias. La función retornada cancela la suscripción.
+func SubscribeTransfers() (<-chan TransferEvent, func()) {
+	ch := make(chan TransferEvent, 64)
+
+	transferSubsMu.Lock()
+	id := nextTransferSub
+	nextTransferSub++
+	transferSubs[id] = ch
+	transferSubsMu.Unlock()
+
+	cancel := func() {
+		transferSubsMu.Lock()
+		defer transferSubsMu.Unlock()
+		if _, ok := transferSubs[id]; ok {
+			delete(transferSubs, id)
+			close(ch)
+		}
+	}
+	return ch, cancel
+}
+
+func publishTransfer(ev TransferEvent) {
+	transferSubsMu.Lock()
+	defer transferSubsMu.Unlock()
+	for _, ch := range transferSubs {
+		select {
+		case ch <- ev:
+		default:
+		}
+	}
+}
+
+// LogTransfers muestra en consola el avance de las transferencias largas y
+// anota en el registro las canceladas. Termina cuando se cierra events.
+func LogTransfers(events <-chan TransferEvent) {
+	const (
+		longAfter = 2 * time.Second // solo se informa de las que duran más
+		every     = 5 * time.Second
+	)
+	lastShown := make(map[int]time.Time)
+	for ev := range events {
+		t := ev.Transfer
+		switch ev.Type {
+		case TransferProgress:
+			if time.Since(t.StartedAt) < longAfter || time.Since(lastShown[t.ID]) < every {
+				continue
+			}
+			lastShown[t.ID] = time.Now()
+			fmt.Printf("📊 %s %s: %s\n", t.Direction, t.FileName, DescribeProgress(t))
+
+		case TransferFinished:
+			delete(lastShown, t.ID)
+			switch t.State {
+			case TransferCancelled:
+				fmt.Printf("🚫 %s %s con %s cancelada: %s\n", t.Direction, t.FileName, t.Peer, t.Error)
+				logger.AppendToLocalLog(logger.Operation{
+					Type:      "TRANSFER_CANCELLED",
+					FileName:  t.FileName,
+					From:      t.Peer,
+					Timestamp: time.Now().Unix(),
+					Message:   fmt.Sprintf("%s interrumpida tras %s: %s", t.Direction, bandwidth.FormatBytes(t.Bytes), t.Error),
+				})
+			case TransferDone:
+				if time.Since(t.StartedAt) >= longAfter {
+					fmt.Printf("🏁 %s %s: %s en %s (%s/s)\n", t.Direction, t.FileName, bandwidth.FormatBytes(t.Bytes),
+						time.Since(t.StartedAt).Round(time.Second), bandwidth.FormatBytes(t.Rate))
+				}
+			}
+		}
+	}
+}
+
+// DescribeProgress resume el avance de una transferencia
+func DescribeProgress(t Transfer) string {
+	desc := bandwidth.FormatBytes(t.Bytes)
+	if t.Total > 0 {
+		desc = fmt.Sprintf("%d%% (%s de %s)", t.Bytes*100/t.Total, desc, bandwidth.FormatBytes(t.Total))
+	}
+	desc += fmt.Sprintf(", %s/s", bandwidth.FormatBytes(t.Rate))
+	if t.ETA > 0 {
+		desc += fmt.Sprintf(", quedan %s", time.Duration(t.ETA*float64(time.Second)).Round(time.Second))
+	}
+	return desc
+}
@@ -0,0 +1,70 @@
+// Package placement decide qué nodos deben guardar cada archivo de una
+// carpeta con factor de replicación. Usa hashing de rendezvous: cada nodo
+// recibe para cada archivo una puntuación que solo depende de los dos, así
+// que todos los nodos calculan el mismo orden sin coordinarse y, cuando un
+// nodo entra o sale, solo cambian de sitio los archivos que le tocaban.
+package placement
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"sort"
+)
+
+// Políticas de colocación
+const (
+	// Hash reparte los archivos por igual entre los nodos
+	Hash = "hash"
+	// Space reparte en proporción al espacio libre de cada nodo
+	Space = "space"
+)
+
+// Valid indica si policy es una política conocida; vacío es Hash
+func Valid(policy string) bool {
+	return policy == "" || policy == Hash || policy == Space
+}
+
+// Node es un nodo candidato a guardar archivos
+type Node struct {
+	ID   int
+	Addr string
+	Free int64 // bytes libres; 0 si no se conoce
+	Self bool
+}
+
+// Rank ordena nodes de más a menos adecuado para guardar key
+func Rank(key string, nodes []Node, policy string) []Node {
+	ranked := append([]Node(nil), nodes...)
+	scores := make(map[int]float64, len(ranked))
+	for _, n := range ranked {
+		scores[n.ID] = score(key, n, policy)
+	}
+	sort.SliceStable(ranked, func(i, j int) bool {
+		si, sj := scores[ranked[i].ID], scores[ranked[j].ID]
+		if si != sj {
+			return si > sj
+		}
+		return ranked[i].ID < ranked[j].ID
+	})
+	return ranked
+}
+
+// score es la puntuación de n para key. Con Space es la variante con
+// pesos (peso / -ln(h)), que da a cada nodo una parte de los archivos
+// proporcional a su espacio libre.
+func score(key string, n Node, policy string) float64 {
+	sum := sha256.Sum256([]byte(fmt.Sprintf("%s#%d", key, n.ID)))
+	h := binary.BigEndian.Uint64(sum[:8])
+	if policy != Space {
+		return float64(h)
+	}
+	// h en (0, 1)
+	u := (float64(h>>11) + 0.5) / (1 << 53)
+	weight := float64(n.Free)
+	if weight < 1 {
+		weight = 1
+	}
+	return weight / -math.Log(u)
+}
@@ -0,0 +1,401 @@
+// Package share administra las carpetas compartidas con nombre de un nodo.
+// En la red un archivo se identifica como "carpeta/ruta/relativa".
+package share
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"p2pfs/internal/config"
+	"p2pfs/internal/fs"
+	"p2pfs/internal/ignore"
+	"p2pfs/internal/trash"
+	"p2pfs/internal/versions"
+)
+
+// ErrUnknown indica que la carpeta compartida no existe en este nodo
+var ErrUnknown = errors.New("carpeta compartida desconocida")
+
+// Modos de una carpeta
+const (
+	// SendReceive envía los cambios locales y aplica los remotos
+	SendReceive = "send-receive"
+	// SendOnly hace de la copia local la referencia: los cambios remotos
+	// se ignoran y se pueden revertir
+	SendOnly = "send-only"
+	// ReceiveOnly aplica los cambios remotos; los locales se señalan y
+	// nunca se propagan
+	ReceiveOnly = "receive-only"
+	// Archive aplica los cambios remotos salvo los borrados
+	Archive = "archive"
+)
+
+// modeAliases son los nombres cortos aceptados en la configuración
+var modeAliases = map[string]string{
+	"":        SendReceive,
+	"both":    SendReceive,
+	"send":    SendOnly,
+	"receive": ReceiveOnly,
+}
+
+// Share es una carpeta compartida con nombre
+type Share struct {
+	Name   string
+	Path   string
+	Peers  []string
+	Mode   string
+	Ignore []string
+	Only   []string // subcarpetas que sincroniza este nodo; vacío = todas
+	// Versions decide qué copias anteriores se guardan en .versions
+	Versions versions.Policy
+	// TrashAge es cuánto se guarda en .trash lo que borran otros nodos; 0 = sin papelera
+	TrashAge time.Duration
+	// Symlinks replica los enlaces simbólicos en lugar de seguirlos
+	Symlinks bool
+	// EmptyDirs crea las carpetas vacías de los demás nodos
+	EmptyDirs bool
+	// Replicas es en cuántos nodos debe estar cada archivo; 0 = en todos
+	Replicas int
+	// Placement elige esos nodos (placement.Hash o placement.Space)
+	Placement string
+
+	matcher *ignore.Matcher
+}
+
+var (
+	mu     sync.RWMutex
+	shares []*Share
+)
+
+// Configure reemplaza las carpetas compartidas por las de la configuración
+func Configure(list []config.Share) {
+	var next []*Share
+	for _, c := range list {
+		mode := c.Mode
+		if alias, ok := modeAliases[mode]; ok {
+			mode = alias
+		}
+		s := &Share{
+			Name:   c.Name,
+			Path:   c.Path,
+			Peers:  c.Peers,
+			Mode:   mode,
+			Ignore: c.Ignore,
+
+			Symlinks:  c.Symlinks,
+			EmptyDirs: c.EmptyDirs,
+			Replicas:  c.Replicas,
+			Placement: c.Placement,
+		}
+		if v := c.Versions; v != nil {
+			s.Versions = versions.Policy{Type: v.Type, Keep: v.Keep, MaxAge: time.Duration(v.KeepDays) * 24 * time.Hour}
+			if s.Versions.Type == "" {
+				s.Versions.Type = versions.Simple
+			}
+		}
+		if c.TrashDays != nil {
+			s.TrashAge = time.Duration(*c.TrashDays) * 24 * time.Hour
+		}
+		for _, only := range c.Only {
+			s.Only = append(s.Only, path.Clean(filepath.ToSlash(only)))
+		}
+		// Los patrones ya se validaron al cargar la configuración
+		s.matcher, _ = ignore.NewMatcher(c.Path, append(append([]string{}, ignore.Defaults...), c.Ignore...))
+		next = append(next, s)
+	}
+
+	mu.Lock()
+	shares = next
+	mu.Unlock()
+}
+
+// All devuelve las carpetas compartidas en el orden de la configuración
+func All() []*Share {
+	mu.RLock()
+	defer mu.RUnlock()
+	return append([]*Share(nil), shares...)
+}
+
+// Get busca una carpeta por nombre
+func Get(name string) (*Share, bool) {
+	mu.RLock()
+	defer mu.RUnlock()
+	for _, s := range shares {
+		if s.Name == name {
+			return s, true
+		}
+	}
+	return nil, false
+}
+
+// Default es la primera carpeta configurada; recibe lo que se envía desde
+// fuera de cualquier carpeta compartida
+func Default() (*Share, bool) {
+	mu.RLock()
+	defer mu.RUnlock()
+	if len(shares) == 0 {
+		return nil, false
+	}
+	return shares[0], true
+}
+
+// Split separa "carpeta/ruta" y rechaza rutas que intenten salir de la carpeta
+func Split(name string) (string, string, error) {
+	clean := path.Clean(filepath.ToSlash(name))
+	if clean == "." || clean == ".." || strings.HasPrefix(clean, "/") || strings.HasPrefix(clean, "../") {
+		return "", "", fmt.Errorf("ruta no permitida: %q", name)
+	}
+	shareName, rel, _ := strings.Cut(clean, "/")
+	return shareName, rel, nil
+}
+
+// Resolve traduce "carpeta/ruta" a la carpeta, la ruta relativa y la ruta local
+func Resolve(name string) (*Share, string, string, error) {
+	shareName, rel, err := Split(name)
+	if err != nil {
+		return nil, "", "", err
+	}
+	s, ok := Get(shareName)
+	if !ok {
+		return nil, "", "", fmt.Errorf("%s: %w", shareName, ErrUnknown)
+	}
+	local := s.LocalPath(rel)
+	// Si la carpeta replica enlaces, otros nodos pueden crearlos: se siguen
+	// los que haya en el camino y la ruta debe seguir dentro de la carpeta
+	if s.Symlinks && rel != "" && !fs.Within(s.Path, filepath.Dir(local)) {
+		return nil, "", "", fmt.Errorf("ruta no permitida: %q sale de %s a través de un enlace", name, s.Name)
+	}
+	return s, rel, local, nil
+}
+
+// Locate encuentra la carpeta que contiene una ruta local y la ruta relativa dentro de ella
+func Locate(localPath string) (*Share, string, bool) {
+	abs, err := filepath.Abs(localPath)
+	if err != nil {
+		return nil, "", false
+	}
+	for _, s := range All() {
+		root, err := filepath.Abs(s.Path)
+		if err != nil {
+			continue
+		}
+		rel, err := filepath.Rel(root, abs)
+		if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+			continue
+		}
+		if rel == "." {
+			rel = ""
+		}
+		return s, filepath.ToSlash(rel), true
+	}
+	return nil, "", false
+}
+
+// LocalPath es la ruta en disco de un archivo de la carpeta
+func (s *Share) LocalPath(rel string) string {
+	return filepath.Join(s.Path, filepath.FromSlash(rel))
+}
+
+// Key es el nombre del archivo en la red: "carpeta/ruta"
+func (s *Share) Key(rel string) string {
+	return path.Join(s.Name, rel)
+}
+
+// CanSend indica si la carpeta ofrece sus archivos a otros nodos
+func (s *Share) CanSend() bool { return s.Mode != ReceiveOnly }
+
+// CanReceive indica si la carpeta acepta cambios de otros nodos
+func (s *Share) CanReceive() bool { return s.Mode != SendOnly }
+
+// KeepsDeletes indica si la carpeta conserva lo que otros nodos borran
+func (s *Share) KeepsDeletes() bool { return s.Mode == Archive }
+
+// Allows indica si un nodo participa en la carpeta. Se compara por ID, por
+// host o por host:puerto según cómo esté escrita cada entrada; port vacío
+// (conexiones entrantes) no se compara. El ID lo declara el propio nodo:
+// quien llama debe pasar 0 si no corresponde a un peer registrado con esa
+// dirección.
+func (s *Share) Allows(id int, port string, hosts ...string) bool {
+	if len(s.Peers) == 0 {
+		return true
+	}
+	for _, entry := range s.Peers {
+		if n, err := strconv.Atoi(entry); err == nil {
+			if id != 0 && n == id {
+				return true
+			}
+			continue
+		}
+		host, entryPort, err := net.SplitHostPort(entry)
+		if err != nil {
+			host, entryPort = entry, ""
+		}
+		if entryPort != "" && port != "" && entryPort != port {
+			continue
+		}
+		for _, h := range hosts {
+			if h == host {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Ignored indica si una ruta relativa queda fuera de la sincronización:
+// porque es el almacén de versiones, la papelera o un temporal, porque no está en las subcarpetas
+// elegidas (Only) o porque la excluyen los patrones de Ignore y de los
+// archivos .p2pfsignore
+func (s *Share) Ignored(rel string, isDir bool) bool {
+	if first, _, _ := strings.Cut(rel, "/"); first == versions.Dir || first == trash.Dir {
+		return true
+	}
+	if fs.IsTemp(path.Base(rel)) {
+		return true
+	}
+	if !s.selected(rel, isDir) {
+		return true
+	}
+	return s.matcher != nil && s.matcher.Ignored(rel, isDir)
+}
+
+// selected indica si la ruta está dentro de alguna subcarpeta de Only o es
+// una carpeta que lleva a una de ellas
+func (s *Share) selected(rel string, isDir bool) bool {
+	if len(s.Only) == 0 || rel == "" {
+		return true
+	}
+	for _, dir := range s.Only {
+		if rel == dir || strings.HasPrefix(rel, dir+"/") {
+			return true
+		}
+		if isDir && strings.HasPrefix(dir, rel+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// Tree construye el árbol de la carpeta, sin los archivos ignorados, con el
+// nombre de la carpeta compartida como raíz
+func (s *Share) Tree() (fs.FileNode, error) {
+	tree, err := fs.BuildFileTree(s.Path, fs.TreeOptions{Skip: s.Ignored, Links: s.Symlinks})
+	tree.Name = s.Name
+	tree.IsDir = true
+	return tree, err
+}
+
+// Tree arma un árbol cuya raíz sin nombre contiene una rama por cada carpeta
+// para la que include devuelve true (todas si include es nil)
+func Tree(include func(s *Share) bool) fs.FileNode {
+	root := fs.FileNode{IsDir: true}
+	for _, s := range All() {
+		if include != nil && !include(s) {
+			continue
+		}
+		tree, err := s.Tree()
+		if err != nil {
+			fmt.Printf("⚠️ Error leyendo la carpeta %s: %v\n", s.Name, err)
+		}
+		root.Children = append(root.Children, tree)
+	}
+	return root
+}
+
+// Archive guarda la copia actual de rel como versión antes de
+// sobrescribirla o borrarla
+func (s *Share) Archive(rel string) error {
+	return versions.Archive(s.Path, rel, s.Versions)
+}
+
+// KeepVersion guarda como versión una copia de rel que se va a sustituir
+// con una escritura atómica, dejando el archivo en su sitio
+func (s *Share) KeepVersion(rel string) error {
+	return versions.Keep(s.Path, rel, s.Versions)
+}
+
+// Trash aparta rel a la papelera en lugar de borrarlo; from es el nodo que
+// pidió el borrado
+func (s *Share) Trash(rel, from string) (trash.Item, error) {
+	return trash.Move(s.Path, rel, from)
+}
+
+// RestoreTrash devuelve a su sitio el elemento id de la papelera. Si la
+// ruta se volvió a ocupar, lo que hay se aparta de una vez y, con el
+// elemento ya en su sitio, se guarda como versión aunque la política sea
+// none. Si la recuperación falla todo queda como estaba.
+func (s *Share) RestoreTrash(id string) (trash.Item, error) {
+	item, err := trash.Get(s.Path, id)
+	if err != nil {
+		return item, err
+	}
+	if err := trash.Check(s.Path, item); err != nil {
+		return item, err
+	}
+	dst := s.LocalPath(item.Path)
+	if _, err := os.Lstat(dst); err != nil {
+		return trash.Restore(s.Path, id)
+	}
+
+	aside := trash.Aside(s.Path, id)
+	if err := os.Rename(dst, aside); err != nil {
+		return item, fmt.Errorf("no se pudo apartar %s: %v", item.Path, err)
+	}
+	if _, err := trash.Restore(s.Path, id); err != nil {
+		// Solo se deshace si el elemento no llegó a su sitio
+		if _, statErr := os.Lstat(dst); os.IsNotExist(statErr) {
+			if undoErr := os.Rename(aside, dst); undoErr != nil {
+				return item, fmt.Errorf("%v; la copia actual quedó en %s: %v", err, aside, undoErr)
+			}
+		}
+		return item, err
+	}
+	policy := s.Versions
+	if policy.Type == versions.None {
+		policy.Type = versions.Simple
+	}
+	if err := versions.ArchiveFrom(s.Path, item.Path, aside, policy); err != nil {
+		return item, fmt.Errorf("%v; la copia anterior quedó en %s", err, aside)
+	}
+	return item, os.RemoveAll(aside)
+}
+
+// Prune aplica periódicamente la política de versiones de cada carpeta y
+// vacía de su papelera lo caducado, para que caduquen también las versiones
+// de archivos que ya no cambian. Termina al cancelarse ctx.
+func Prune(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		for _, s := range All() {
+			if n, err := versions.Prune(s.Path, s.Versions); err != nil {
+				fmt.Printf("⚠️ Error al depurar versiones de %s: %v\n", s.Name, err)
+			} else if n > 0 {
+				fmt.Printf("🧹 %d versión(es) caducadas eliminadas de %s\n", n, s.Name)
+			}
+			if s.TrashAge <= 0 {
+				continue
+			}
+			if n, err := trash.Purge(s.Path, s.TrashAge); err != nil {
+				fmt.Printf("⚠️ Error al vaciar la papelera de %s: %v\n", s.Name, err)
+			} else if n > 0 {
+				fmt.Printf("🧹 %d elemento(s) caducados eliminados de la papelera de %s\n", n, s.Name)
+			}
+		}
+	}
+}
@@ -0,0 +1,149 @@
+package share
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"p2pfs/internal/config"
+	"p2pfs/internal/fs"
+	"p2pfs/internal/trash"
+	"p2pfs/internal/versions"
+)
+
+// TestResolveChainedLinks comprueba que dos enlaces que por separado
+// quedan dentro de la carpeta no sirven para salir de ella
+func TestResolveChainedLinks(t *testing.T) {
+	base := t.TempDir()
+	root := filepath.Join(base, "one", "two", "share")
+	if err := os.MkdirAll(filepath.Join(root, "a"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	Configure([]config.Share{{Name: "s", Path: root, Symlinks: true}})
+	defer Configure(nil)
+
+	// a/b -> .. es la raíz de la carpeta
+	if !fs.LinkWithin(root, filepath.Join(root, "a", "b"), "..") {
+		t.Fatal("a/b -> .. debería quedar dentro de la carpeta")
+	}
+	if err := os.Symlink("..", filepath.Join(root, "a", "b")); err != nil {
+		t.Fatal(err)
+	}
+	// a/b/c -> ../.. parece dentro, pero a/b ya es la raíz
+	if !fs.LinkInside("a/b/c", "../..") {
+		t.Fatal("la comprobación textual debería aceptar a/b/c -> ../..")
+	}
+	if fs.LinkWithin(root, filepath.Join(root, "a", "b", "c"), "../..") {
+		t.Fatal("a/b/c -> ../.. sale de la carpeta y se aceptó")
+	}
+	// Aunque el enlace llegue a existir, no se puede usar
+	if err := os.Symlink("../..", filepath.Join(root, "c")); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range []struct {
+		name string
+		ok   bool
+	}{
+		{"s/a/x.txt", true},
+		{"s/a/b/x.txt", true},
+		{"s/a/b/a/x.txt", true},
+		{"s/c", true}, // el propio enlace se puede sustituir o borrar
+		{"s/a/b/c/x.txt", false},
+		{"s/c/share/x.txt", false},
+		{"s/c/two/x.txt", false},
+		{"s/c/two/share/a/x.txt", true}, // vuelve a entrar en la carpeta
+	} {
+		_, _, _, err := Resolve(tc.name)
+		if (err == nil) != tc.ok {
+			t.Errorf("Resolve(%q): error %v, se esperaba aceptado=%v", tc.name, err, tc.ok)
+		}
+	}
+}
+
+// TestResolveBrokenLink comprueba que no se crea nada a través de un
+// enlace roto
+func TestResolveBrokenLink(t *testing.T) {
+	root := t.TempDir()
+	Configure([]config.Share{{Name: "s", Path: root, Symlinks: true}})
+	defer Configure(nil)
+
+	if err := os.Symlink(filepath.Join(t.TempDir(), "missing"), filepath.Join(root, "d")); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, _, err := Resolve("s/d/x.txt"); err == nil {
+		t.Fatal("Resolve aceptó una ruta que pasa por un enlace roto")
+	}
+}
+
+// TestRestoreTrashOverDir comprueba que recuperar una carpeta cuya ruta se
+// volvió a ocupar deja la papelera en su sitio y lo actual como versiones
+func TestRestoreTrashOverDir(t *testing.T) {
+	for _, policy := range []string{versions.Simple, versions.None} {
+		root := t.TempDir()
+		Configure([]config.Share{{Name: "s", Path: root, Versions: &config.Versions{Type: policy}}})
+		s, _ := Get("s")
+
+		write(t, filepath.Join(root, "docs", "a.txt"), "borrado")
+		item, err := s.Trash("docs", "nodo 2")
+		if err != nil {
+			t.Fatal(err)
+		}
+		write(t, filepath.Join(root, "docs", "sub", "b.txt"), "actual")
+
+		if _, err := s.RestoreTrash(item.ID); err != nil {
+			t.Fatalf("%s: %v", policy, err)
+		}
+		if data, err := os.ReadFile(filepath.Join(root, "docs", "a.txt")); err != nil || string(data) != "borrado" {
+			t.Fatalf("%s: docs/a.txt = %q, %v", policy, data, err)
+		}
+		if _, err := os.Stat(filepath.Join(root, "docs", "sub")); !os.IsNotExist(err) {
+			t.Fatalf("%s: docs/sub sigue en la carpeta: %v", policy, err)
+		}
+		if list, err := versions.List(root, "docs/sub/b.txt"); err != nil || len(list) != 1 {
+			t.Fatalf("%s: versiones de docs/sub/b.txt = %v, %v", policy, list, err)
+		}
+		if _, err := os.Stat(trash.Aside(root, item.ID)); !os.IsNotExist(err) {
+			t.Fatalf("%s: la copia apartada no se eliminó: %v", policy, err)
+		}
+	}
+	Configure(nil)
+}
+
+// TestRestoreTrashRollback comprueba que si no se puede recuperar no se
+// toca lo que hay ahora
+func TestRestoreTrashRollback(t *testing.T) {
+	root := t.TempDir()
+	Configure([]config.Share{{Name: "s", Path: root}})
+	defer Configure(nil)
+	s, _ := Get("s")
+
+	write(t, filepath.Join(root, "docs", "a.txt"), "borrado")
+	item, err := s.Trash("docs/a.txt", "nodo 2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// docs pasa a ser un archivo: el elemento ya no cabe en su ruta
+	os.Remove(filepath.Join(root, "docs"))
+	write(t, filepath.Join(root, "docs"), "actual")
+
+	if _, err := s.RestoreTrash(item.ID); err == nil {
+		t.Fatal("se recuperó un elemento cuya carpeta es ahora un archivo")
+	}
+	if data, err := os.ReadFile(filepath.Join(root, "docs")); err != nil || string(data) != "actual" {
+		t.Fatalf("docs = %q, %v", data, err)
+	}
+	if _, err := trash.Get(root, item.ID); err != nil {
+		t.Fatalf("el elemento salió de la papelera: %v", err)
+	}
+}
+
+func write(t *testing.T, p, content string) {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(p, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,381 @@
+// Package snapshot exporta una carpeta compartida a un ZIP autodescriptivo
+// y la importa en otro nodo, para arrancar carpetas grandes sin pasar por la
+// red (p. ej. con un USB). El ZIP contiene:
+//
+//	manifest.json      descripción, hashes y metadatos de todo lo demás
+//	files/<ruta>       contenido de cada archivo
+//	versions/<ruta>    el almacén .versions de la carpeta
+package snapshot
+
+import (
+	"archive/zip"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"io"
+	iofs "io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"p2pfs/internal/fs"
+	"p2pfs/internal/share"
+	"p2pfs/internal/state"
+	"p2pfs/internal/utils"
+	"p2pfs/internal/versions"
+)
+
+// Format es la versión del formato del manifiesto
+const Format = 1
+
+// ManifestName es el nombre del manifiesto dentro del ZIP
+const ManifestName = "manifest.json"
+
+const (
+	filesDir    = "files/"
+	versionsDir = "versions/"
+)
+
+// Manifest describe un snapshot
+type Manifest struct {
+	Format   int       `json:"format"`
+	Share    string    `json:"share"`
+	Mode     string    `json:"mode,omitempty"`
+	Origin   Origin    `json:"origin"`
+	Created  time.Time `json:"created"`
+	Files    []Entry   `json:"files"`
+	Dirs     []Entry   `json:"dirs,omitempty"`
+	Versions []Entry   `json:"versions,omitempty"`
+}
+
+// Origin es el nodo que generó el snapshot
+type Origin struct {
+	ID   int    `json:"id"`
+	IP   string `json:"ip"`
+	Addr string `json:"addr"`
+}
+
+// Entry es un archivo, carpeta o versión del snapshot
+type Entry struct {
+	Path    string      `json:"path"`
+	Size    int64       `json:"size,omitempty"`
+	Hash    string      `json:"sha256,omitempty"`
+	Mode    os.FileMode `json:"mode,omitempty"`
+	ModTime time.Time   `json:"mod_time"`
+	Link    string      `json:"link,omitempty"`
+}
+
+// Result resume una importación
+type Result struct {
+	Share    string `json:"share"`
+	Files    int    `json:"files"`    // archivos escritos
+	Skipped  int    `json:"skipped"`  // ya estaban, ignorados o más recientes aquí
+	Dirs     int    `json:"dirs"`     // carpetas creadas
+	Versions int    `json:"versions"` // versiones añadidas
+}
+
+// Export escribe en w el snapshot de la carpeta s, sin lo ignorado
+func Export(w io.Writer, s *share.Share, origin Origin) error {
+	tree, err := fs.BuildFileTree(s.Path, fs.TreeOptions{Skip: s.Ignored, Links: s.Symlinks})
+	if err != nil {
+		return err
+	}
+
+	zw := zip.NewWriter(w)
+	m := Manifest{Format: Format, Share: s.Name, Mode: s.Mode, Origin: origin, Created: time.Now(), Files: []Entry{}}
+
+	for _, rel := range sortedKeys(fs.FlattenTreePaths(tree)) {
+		e, err := addFile(zw, filesDir+rel, s.LocalPath(rel), s.Symlinks)
+		if err != nil {
+			zw.Close()
+			return fmt.Errorf("%s: %v", s.Key(rel), err)
+		}
+		e.Path = rel
+		m.Files = append(m.Files, e)
+	}
+	dirs := fs.FlattenTreeDirs(tree)
+	for _, rel := range sortedKeys(dirs) {
+		d := dirs[rel]
+		m.Dirs = append(m.Dirs, Entry{Path: rel, Mode: d.Mode, ModTime: d.ModTime})
+	}
+
+	store := filepath.Join(s.Path, versions.Dir)
+	err = filepath.WalkDir(store, func(p string, d iofs.DirEntry, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		if d.IsDir() || fs.IsTemp(d.Name()) {
+			return nil
+		}
+		rel, err := filepath.Rel(store, p)
+		if err != nil {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+		e, err := addFile(zw, versionsDir+rel, p, false)
+		if err != nil {
+			return err
+		}
+		e.Path = rel
+		m.Versions = append(m.Versions, e)
+		return nil
+	})
+	if err != nil {
+		zw.Close()
+		return fmt.Errorf("versiones: %v", err)
+	}
+
+	// El manifiesto va al final: necesita los hashes calculados al copiar
+	mw, err := zw.Create(ManifestName)
+	if err != nil {
+		zw.Close()
+		return err
+	}
+	enc := json.NewEncoder(mw)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(m); err != nil {
+		zw.Close()
+		return err
+	}
+	return zw.Close()
+}
+
+// addFile copia src al ZIP como name y devuelve su entrada sin la ruta.
+// Los enlaces simbólicos (con links) solo se describen en el manifiesto.
+func addFile(zw *zip.Writer, name, src string, links bool) (Entry, error) {
+	meta, info, err := fs.ReadMeta(src, links)
+	if err != nil {
+		return Entry{}, err
+	}
+	e := Entry{Mode: meta.Mode, ModTime: meta.ModTime, Link: meta.Link}
+	if e.Link != "" {
+		return e, nil
+	}
+
+	f, err := os.Open(src)
+	if err != nil {
+		return e, err
+	}
+	defer f.Close()
+
+	header, err := zip.FileInfoHeader(info)
+	if err != nil {
+		return e, err
+	}
+	header.Name = name
+	header.Method = zip.Deflate
+	out, err := zw.CreateHeader(header)
+	if err != nil {
+		return e, err
+	}
+	h := sha256.New()
+	if e.Size, err = io.Copy(io.MultiWriter(out, h), f); err != nil {
+		return e, err
+	}
+	e.Hash = fmt.Sprintf("%x", h.Sum(nil))
+	return e, nil
+}
+
+func sortedKeys(m map[string]fs.FileNode) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// ReadManifest lee el manifiesto de un snapshot
+func ReadManifest(zr *zip.Reader) (Manifest, error) {
+	var m Manifest
+	f, err := zr.Open(ManifestName)
+	if err != nil {
+		return m, fmt.Errorf("no es un snapshot de p2pfs: falta %s", ManifestName)
+	}
+	defer f.Close()
+	if err := json.NewDecoder(f).Decode(&m); err != nil {
+		return m, fmt.Errorf("manifiesto no válido: %v", err)
+	}
+	if m.Format != Format {
+		return m, fmt.Errorf("formato de snapshot %d no soportado", m.Format)
+	}
+	return m, nil
+}
+
+// Import vuelca el snapshot en la carpeta s. Cada archivo se comprueba con
+// su hash antes de escribirlo; lo que ya está igual, lo ignorado y lo que
+// aquí es más reciente se deja como está. Después se siembra el estado: la
+// caché de archivos del nodo de origen, para que al unirse no se descargue
+// de nuevo lo importado, y las versiones recibidas de las carpetas
+// receive-only.
+func Import(zr *zip.Reader, m Manifest, s *share.Share) (Result, error) {
+	res := Result{Share: s.Name}
+	entries := make(map[string]*zip.File, len(zr.File))
+	for _, f := range zr.File {
+		entries[f.Name] = f
+	}
+
+	var imported []state.FileInfo
+	for _, e := range m.Files {
+		if !validPath(e.Path) {
+			return res, fmt.Errorf("ruta no válida en el manifiesto: %q", e.Path)
+		}
+		if s.Ignored(e.Path, false) {
+			res.Skipped++
+			continue
+		}
+		written, err := importFile(s, e, entries[filesDir+e.Path])
+		if err != nil {
+			return res, fmt.Errorf("%s: %v", s.Key(e.Path), err)
+		}
+		if written {
+			res.Files++
+		} else {
+			res.Skipped++
+		}
+		imported = append(imported, state.FileInfo{Name: s.Key(e.Path), ModTime: e.ModTime, Size: e.Size})
+	}
+
+	for _, e := range m.Versions {
+		if !validPath(e.Path) {
+			return res, fmt.Errorf("ruta no válida en el manifiesto: %q", e.Path)
+		}
+		dest := filepath.Join(s.Path, versions.Dir, filepath.FromSlash(e.Path))
+		if _, err := os.Lstat(dest); err == nil {
+			continue
+		}
+		if err := extract(entries[versionsDir+e.Path], e, dest); err != nil {
+			return res, fmt.Errorf("versión %s: %v", e.Path, err)
+		}
+		res.Versions++
+	}
+
+	// Las carpetas al final, de la más profunda a la raíz, para que su
+	// fecha no cambie al crear lo que contienen
+	for i := len(m.Dirs) - 1; i >= 0; i-- {
+		e := m.Dirs[i]
+		if !validPath(e.Path) || s.Ignored(e.Path, true) {
+			continue
+		}
+		local := s.LocalPath(e.Path)
+		if _, err := os.Lstat(local); err != nil {
+			if err := os.MkdirAll(local, 0755); err != nil {
+				return res, err
+			}
+			res.Dirs++
+		}
+		fs.ApplyMeta(local, fs.Meta{Mode: e.Mode, ModTime: e.ModTime})
+	}
+
+	if m.Origin.Addr != "" {
+		state.MergeFileCache(m.Origin.Addr, imported)
+	}
+	if s.Mode == share.ReceiveOnly {
+		var received []state.FileInfo
+		for _, f := range imported {
+			rel := strings.TrimPrefix(f.Name, s.Name+"/")
+			if info, err := os.Stat(s.LocalPath(rel)); err == nil {
+				received = append(received, state.FileInfo{Name: f.Name, ModTime: info.ModTime(), Size: info.Size()})
+			}
+		}
+		state.SetReceivedFiles(received)
+	}
+	return res, nil
+}
+
+// importFile escribe un archivo del snapshot; devuelve false si no hizo falta
+func importFile(s *share.Share, e Entry, zf *zip.File) (bool, error) {
+	local := s.LocalPath(e.Path)
+	meta := fs.Meta{Mode: e.Mode, ModTime: e.ModTime, Link: e.Link}
+	if s.Symlinks && !fs.Within(s.Path, filepath.Dir(local)) {
+		return false, fmt.Errorf("%s sale de %s a través de un enlace", e.Path, s.Name)
+	}
+
+	if e.Link != "" {
+		if !s.Symlinks || !fs.LinkInside(e.Path, e.Link) || !fs.LinkWithin(s.Path, local, e.Link) {
+			return false, nil
+		}
+		if current, err := os.Readlink(local); err == nil && current == e.Link {
+			return false, nil
+		}
+		if _, err := os.Lstat(local); err == nil {
+			return false, nil
+		}
+		os.MkdirAll(filepath.Dir(local), 0755)
+		return true, fs.WriteSymlinkAtomic(local, e.Link)
+	}
+
+	if info, err := os.Lstat(local); err == nil {
+		if info.ModTime().After(e.ModTime) {
+			return false, nil
+		}
+		if hash, err := utils.CalculateSHA256(local); err == nil && hash == e.Hash {
+			fs.ApplyMeta(local, meta)
+			return false, nil
+		}
+		if err := s.KeepVersion(e.Path); err != nil {
+			return false, err
+		}
+	}
+	if err := extract(zf, e, local); err != nil {
+		return false, err
+	}
+	return true, fs.ApplyMeta(local, meta)
+}
+
+// extract comprueba el hash de una entrada del ZIP y la escribe en dest de
+// forma atómica
+func extract(zf *zip.File, e Entry, dest string) error {
+	if zf == nil {
+		return fmt.Errorf("falta en el ZIP")
+	}
+	hash, err := hashEntry(zf)
+	if err != nil {
+		return err
+	}
+	if hash != e.Hash {
+		return fmt.Errorf("el contenido no coincide con el hash del manifiesto")
+	}
+	r, err := zf.Open()
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	if err := os.MkdirAll(filepath.Dir(dest), 0755); err != nil {
+		return err
+	}
+	perm := e.Mode.Perm()
+	if perm == 0 {
+		perm = 0644
+	}
+	if err := fs.CopyAtomic(dest, r, perm); err != nil {
+		return err
+	}
+	return os.Chtimes(dest, time.Now(), e.ModTime)
+}
+
+func hashEntry(zf *zip.File) (string, error) {
+	r, err := zf.Open()
+	if err != nil {
+		return "", err
+	}
+	defer r.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, r); err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%x", h.Sum(nil)), nil
+}
+
+// validPath rechaza rutas absolutas o que salgan de la carpeta
+func validPath(rel string) bool {
+	clean := path.Clean(rel)
+	return rel != "" && clean == rel && clean != "." && clean != ".." &&
+		!path.IsAbs(clean) && !strings.HasPrefix(clean, "../")
+}
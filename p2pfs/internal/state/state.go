@@ -3,17 +3,23 @@ package state
 import (
 	"encoding/json"
 	"fmt"
+	"net"
 	"os"
 	"path/filepath"
 	"sync"
 	"time"
+
+	"p2pfs/internal/fs"
 )
 
 type FileInfo struct {
 	Name    string    `json:"name"`
 	ModTime time.Time `json:"mod_time"`
+	Size    int64     `json:"size,omitempty"`
 }
 
+// PendingTask es una tarea de la antigua cola de reintentos; solo se lee
+// para pasarla a la cola de trabajos (paquete jobs)
 type PendingTask struct {
 	Type      string `json:"type"`
 	FileName  string `json:"filename"`
@@ -27,28 +33,39 @@ type PersistentState struct {
 	LastSync     map[string]int64      `json:"last_sync"`
 	FileCache    map[string][]FileInfo `json:"file_cache"`
 	OnlineStatus map[string]bool       `json:"online_status"`
-	RetryQueue   []PendingTask         `json:"retry_queue"`
+	RetryQueue   []PendingTask         `json:"retry_queue,omitempty"`
+	Received     map[string]FileInfo   `json:"received,omitempty"`
 }
 
 var (
 	StateFile     = "state/state.json"
 	mu            sync.Mutex
 	LastSync      = make(map[string]int64)
+	// FileCache guarda, por host:puerto de cada peer, los archivos vistos
+	// en él
 	FileCache     = make(map[string][]FileInfo)
 	OnlineStatus  = make(map[string]bool)
 	RetryQueue    []PendingTask
+	// Received guarda, por "carpeta/ruta", cómo quedó en disco la última
+	// versión recibida de otro nodo (carpetas receive-only)
+	Received = make(map[string]FileInfo)
 )
 
 // SaveState serializa el estado actual a un archivo JSON.
 func SaveState() error {
 	mu.Lock()
 	defer mu.Unlock()
+	return saveStateLocked()
+}
 
+// saveStateLocked escribe el estado a disco; el llamador debe tener mu
+func saveStateLocked() error {
 	state := PersistentState{
 		LastSync:     LastSync,
 		FileCache:    FileCache,
 		OnlineStatus: OnlineStatus,
 		RetryQueue:   RetryQueue,
+		Received:     Received,
 	}
 
 	data, err := json.MarshalIndent(state, "", "  ")
@@ -61,7 +78,7 @@ func SaveState() error {
 		return err
 	}
 
-	return os.WriteFile(StateFile, data, 0644)
+	return fs.WriteFileAtomic(StateFile, data, 0644)
 }
 
 // LoadState carga el estado desde disco si existe.
@@ -89,42 +106,48 @@ func LoadState() error {
 	if state.FileCache == nil {
 		state.FileCache = make(map[string][]FileInfo)
 	}
+	// Las cachés antiguas, por IP, mezclaban nodos de la misma máquina; se
+	// descartan y se rehacen en la próxima sincronización
+	for key := range state.FileCache {
+		if _, _, err := net.SplitHostPort(key); err != nil {
+			delete(state.FileCache, key)
+		}
+	}
 	if state.OnlineStatus == nil {
 		state.OnlineStatus = make(map[string]bool)
 	}
+	if state.Received == nil {
+		state.Received = make(map[string]FileInfo)
+	}
 
 	LastSync = state.LastSync
 	FileCache = state.FileCache
 	OnlineStatus = state.OnlineStatus
 	RetryQueue = state.RetryQueue
+	Received = state.Received
 	return nil
 }
 
-// AddPendingTask agrega una tarea a la cola de reintentos.
-func AddPendingTask(task PendingTask) {
+// TakeRetryQueue devuelve las tareas de la antigua cola de reintentos y
+// las quita del estado.
+func TakeRetryQueue() []PendingTask {
 	mu.Lock()
 	defer mu.Unlock()
-	RetryQueue = append(RetryQueue, task)
-	SaveState()
-}
-
-// RemovePendingTask elimina una tarea reintentada con éxito.
-func RemovePendingTask(index int) {
-	mu.Lock()
-	defer mu.Unlock()
-	if index >= 0 && index < len(RetryQueue) {
-		RetryQueue = append(RetryQueue[:index], RetryQueue[index+1:]...)
-		SaveState()
+	tasks := RetryQueue
+	if len(tasks) > 0 {
+		RetryQueue = nil
+		saveStateLocked()
 	}
+	return tasks
 }
 
-// UpdateFileCache actualiza la lista de archivos remotos para un peer.
+// UpdateFileCache actualiza la lista de archivos remotos de un peer (host:puerto).
 func UpdateFileCache(peer string, files []FileInfo) {
 	mu.Lock()
 	defer mu.Unlock()
 	FileCache[peer] = files
 	LastSync[peer] = time.Now().Unix()
-	SaveState()
+	saveStateLocked()
 }
 
 // SetOnlineStatus registra el estado actual (conectado/desconectado) de un peer.
@@ -132,6 +155,89 @@ func SetOnlineStatus(peer string, online bool) {
 	mu.Lock()
 	defer mu.Unlock()
 	OnlineStatus[peer] = online
-	SaveState()
+	saveStateLocked()
+}
+
+// GetFileCache retorna una copia de la lista de archivos conocidos de un peer (host:puerto).
+func GetFileCache(peer string) []FileInfo {
+	mu.Lock()
+	defer mu.Unlock()
+	return append([]FileInfo(nil), FileCache[peer]...)
+}
+
+// SetFileCacheEntry agrega o actualiza un archivo en la caché de un peer.
+func SetFileCacheEntry(peer string, file FileInfo) {
+	mu.Lock()
+	defer mu.Unlock()
+	entries := FileCache[peer]
+	for i, f := range entries {
+		if f.Name == file.Name {
+			entries[i] = file
+			saveStateLocked()
+			return
+		}
+	}
+	FileCache[peer] = append(entries, file)
+	saveStateLocked()
+}
+
+// MergeFileCache agrega o actualiza varios archivos en la caché de un peer
+// y guarda el estado una sola vez.
+func MergeFileCache(peer string, files []FileInfo) {
+	mu.Lock()
+	defer mu.Unlock()
+	index := make(map[string]int)
+	entries := FileCache[peer]
+	for i, f := range entries {
+		index[f.Name] = i
+	}
+	for _, file := range files {
+		if i, ok := index[file.Name]; ok {
+			entries[i] = file
+			continue
+		}
+		index[file.Name] = len(entries)
+		entries = append(entries, file)
+	}
+	FileCache[peer] = entries
+	saveStateLocked()
+}
+
+// SetReceived registra la versión de un archivo recibida de otro nodo.
+func SetReceived(file FileInfo) {
+	mu.Lock()
+	defer mu.Unlock()
+	Received[file.Name] = file
+	saveStateLocked()
+}
+
+// SetReceivedFiles registra varias versiones recibidas de una vez.
+func SetReceivedFiles(files []FileInfo) {
+	mu.Lock()
+	defer mu.Unlock()
+	for _, file := range files {
+		Received[file.Name] = file
+	}
+	saveStateLocked()
 }
 
+// ForgetReceived olvida la versión recibida de un archivo.
+func ForgetReceived(name string) {
+	mu.Lock()
+	defer mu.Unlock()
+	if _, ok := Received[name]; ok {
+		delete(Received, name)
+		saveStateLocked()
+	}
+}
+
+// ReceivedFiles retorna una copia de las versiones recibidas.
+func ReceivedFiles() map[string]FileInfo {
+	mu.Lock()
+	defer mu.Unlock()
+	files := make(map[string]FileInfo, len(Received))
+	for name, f := range Received {
+		files[name] = f
+	}
+	return files
+}
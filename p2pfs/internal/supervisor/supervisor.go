@@ -0,0 +1,114 @@
+// Package supervisor arranca y detiene las tareas de fondo de un nodo:
+// listeners, descubrimiento, reintentos, sincronización... Todas reciben el
+// mismo contexto y deben terminar cuando se cancela. Una tarea que entra en
+// pánico se vuelve a arrancar tras una pausa en lugar de tumbar el nodo.
+package supervisor
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+// RestartDelay es la pausa antes de volver a arrancar una tarea que falló
+var RestartDelay = time.Second
+
+// Supervisor agrupa las tareas de fondo de un nodo
+type Supervisor struct {
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+
+	mu      sync.Mutex
+	running map[string]int // tareas en marcha por nombre
+}
+
+// New crea un supervisor cuyas tareas se detienen al cancelar parent o al
+// llamar a Stop
+func New(parent context.Context) *Supervisor {
+	ctx, cancel := context.WithCancel(parent)
+	return &Supervisor{ctx: ctx, cancel: cancel, running: make(map[string]int)}
+}
+
+// Context es el contexto que reciben las tareas; se cancela al detenerlas
+func (s *Supervisor) Context() context.Context {
+	return s.ctx
+}
+
+// Go arranca una tarea. run debe volver cuando se cancele ctx; si vuelve
+// antes, la tarea se da por terminada. Una vez detenido el supervisor no
+// arranca nada.
+func (s *Supervisor) Go(name string, run func(ctx context.Context)) {
+	s.mu.Lock()
+	if s.ctx.Err() != nil {
+		s.mu.Unlock()
+		return
+	}
+	s.running[name]++
+	s.wg.Add(1)
+	s.mu.Unlock()
+
+	go func() {
+		defer s.wg.Done()
+		defer func() {
+			s.mu.Lock()
+			if s.running[name]--; s.running[name] <= 0 {
+				delete(s.running, name)
+			}
+			s.mu.Unlock()
+		}()
+
+		for s.runOnce(name, run) {
+			select {
+			case <-time.After(RestartDelay):
+				fmt.Printf("🔄 Reiniciando %s\n", name)
+			case <-s.ctx.Done():
+				return
+			}
+		}
+	}()
+}
+
+// runOnce ejecuta run e indica si hay que volver a arrancarla porque entró
+// en pánico
+func (s *Supervisor) runOnce(name string, run func(ctx context.Context)) (restart bool) {
+	defer func() {
+		if r := recover(); r != nil {
+			fmt.Printf("💥 %s falló: %v\n", name, r)
+			restart = s.ctx.Err() == nil
+		}
+	}()
+	run(s.ctx)
+	return false
+}
+
+// Stop cancela el contexto de las tareas y espera a que terminen, como
+// mucho timeout. Devuelve los nombres de las que seguían en marcha.
+func (s *Supervisor) Stop(timeout time.Duration) []string {
+	// Con mu, ningún Go a medias añade una tarea que Stop no espere
+	s.mu.Lock()
+	s.cancel()
+	s.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		s.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-time.After(timeout):
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var names []string
+	for name := range s.running {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,212 @@
+// Package trash guarda lo que otros nodos piden borrar en una papelera
+// local de cada carpeta compartida, de donde se puede recuperar hasta que
+// caduca. Cada borrado ocupa <carpeta>/.trash/<id>/<ruta> y su descripción
+// <carpeta>/.trash/<id>.json.
+package trash
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Dir es la carpeta de la papelera dentro de cada carpeta compartida
+const Dir = ".trash"
+
+// stamp es el formato de fecha con el que se nombra cada borrado
+const stamp = "20060102-150405.000"
+
+// Item es un archivo o carpeta borrado por otro nodo
+type Item struct {
+	ID        string    `json:"id"`
+	Path      string    `json:"path"` // ruta relativa a la carpeta compartida
+	From      string    `json:"from"` // nodo que pidió el borrado
+	DeletedAt time.Time `json:"deleted_at"`
+	IsDir     bool      `json:"is_dir"`
+	Size      int64     `json:"size"`
+}
+
+func itemDir(root, id string) string {
+	return filepath.Join(root, Dir, id)
+}
+
+func metaFile(root, id string) string {
+	return filepath.Join(root, Dir, id+".json")
+}
+
+// Move lleva root/rel a la papelera. Si no existe no hace nada.
+func Move(root, rel, from string) (Item, error) {
+	src := filepath.Join(root, filepath.FromSlash(rel))
+	info, err := os.Lstat(src)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return Item{}, nil
+		}
+		return Item{}, err
+	}
+
+	now := time.Now()
+	id := now.Format(stamp)
+	for n := 2; ; n++ {
+		if _, err := os.Stat(metaFile(root, id)); os.IsNotExist(err) {
+			break
+		}
+		id = fmt.Sprintf("%s-%d", now.Format(stamp), n)
+	}
+
+	dst := filepath.Join(itemDir(root, id), filepath.FromSlash(rel))
+	if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+		return Item{}, err
+	}
+	if err := os.Rename(src, dst); err != nil {
+		return Item{}, fmt.Errorf("no se pudo mover %s a la papelera: %v", rel, err)
+	}
+
+	item := Item{ID: id, Path: filepath.ToSlash(rel), From: from, DeletedAt: now, IsDir: info.IsDir(), Size: size(dst)}
+	data, _ := json.MarshalIndent(item, "", "  ")
+	if err := os.WriteFile(metaFile(root, id), data, 0644); err != nil {
+		return item, err
+	}
+	return item, nil
+}
+
+// size suma el tamaño de los archivos bajo p
+func size(p string) int64 {
+	var total int64
+	filepath.WalkDir(p, func(_ string, d fs.DirEntry, err error) error {
+		if err == nil && !d.IsDir() {
+			if info, err := d.Info(); err == nil {
+				total += info.Size()
+			}
+		}
+		return nil
+	})
+	return total
+}
+
+// List devuelve lo que hay en la papelera de root, lo más reciente primero
+func List(root string) ([]Item, error) {
+	entries, err := os.ReadDir(filepath.Join(root, Dir))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return []Item{}, nil
+		}
+		return nil, err
+	}
+
+	items := []Item{}
+	for _, e := range entries {
+		if e.IsDir() || !strings.HasSuffix(e.Name(), ".json") {
+			continue
+		}
+		item, err := Get(root, strings.TrimSuffix(e.Name(), ".json"))
+		if err != nil {
+			continue
+		}
+		items = append(items, item)
+	}
+	sort.Slice(items, func(i, j int) bool { return items[i].DeletedAt.After(items[j].DeletedAt) })
+	return items, nil
+}
+
+// Get devuelve un elemento de la papelera
+func Get(root, id string) (Item, error) {
+	var item Item
+	if id == "" || strings.ContainsAny(id, `/\`) || strings.HasPrefix(id, ".") {
+		return item, fmt.Errorf("elemento no válido %q", id)
+	}
+	data, err := os.ReadFile(metaFile(root, id))
+	if err != nil {
+		return item, fmt.Errorf("elemento %s de la papelera: %w", id, os.ErrNotExist)
+	}
+	if err := json.Unmarshal(data, &item); err != nil {
+		return item, err
+	}
+	return item, nil
+}
+
+// Restore devuelve un elemento a su sitio y lo saca de la papelera. El
+// destino no debe existir. Lo restaurado queda con la fecha actual para
+// que se replique como un cambio normal.
+func Restore(root, id string) (Item, error) {
+	item, err := Get(root, id)
+	if err != nil {
+		return item, err
+	}
+	src := filepath.Join(itemDir(root, id), filepath.FromSlash(item.Path))
+	dst := filepath.Join(root, filepath.FromSlash(item.Path))
+	if _, err := os.Lstat(dst); err == nil {
+		return item, fmt.Errorf("%s ya existe", item.Path)
+	}
+	if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+		return item, err
+	}
+	if err := os.Rename(src, dst); err != nil {
+		return item, err
+	}
+
+	now := time.Now()
+	filepath.WalkDir(dst, func(p string, d fs.DirEntry, err error) error {
+		if err == nil {
+			os.Chtimes(p, now, now)
+		}
+		return nil
+	})
+	return item, remove(root, id)
+}
+
+// Check comprueba, sin tocar nada, que el elemento se puede devolver a su
+// sitio: que su contenido sigue en la papelera y que ninguna de las
+// carpetas de su ruta es ahora un archivo
+func Check(root string, item Item) error {
+	src := filepath.Join(itemDir(root, item.ID), filepath.FromSlash(item.Path))
+	if _, err := os.Lstat(src); err != nil {
+		return fmt.Errorf("el contenido de %s ya no está en la papelera: %v", item.ID, err)
+	}
+	dst := filepath.Join(root, filepath.FromSlash(item.Path))
+	for dir := filepath.Dir(dst); len(dir) > len(root); dir = filepath.Dir(dir) {
+		if info, err := os.Lstat(dir); err == nil && !info.IsDir() {
+			return fmt.Errorf("%s no es una carpeta", dir)
+		}
+	}
+	return nil
+}
+
+// Aside es dónde se aparta, mientras se recupera el elemento id, lo que
+// ocupa ahora su ruta
+func Aside(root, id string) string {
+	return filepath.Join(root, Dir, "."+id+".current")
+}
+
+// remove borra un elemento de la papelera definitivamente
+func remove(root, id string) error {
+	if err := os.RemoveAll(itemDir(root, id)); err != nil {
+		return err
+	}
+	return os.Remove(metaFile(root, id))
+}
+
+// Purge borra lo que lleva en la papelera más de maxAge y devuelve cuántos
+// elementos eliminó
+func Purge(root string, maxAge time.Duration) (int, error) {
+	items, err := List(root)
+	if err != nil {
+		return 0, err
+	}
+	removed := 0
+	for _, item := range items {
+		if time.Since(item.DeletedAt) <= maxAge {
+			continue
+		}
+		if err := remove(root, item.ID); err != nil {
+			return removed, err
+		}
+		removed++
+	}
+	return removed, nil
+}
@@ -22,3 +22,8 @@ func CalculateSHA256(path string) (string, error) {
     return fmt.Sprintf("%x", h.Sum(nil)), nil
 }
 
+
+// HashBytes calcula el SHA-256 de un contenido ya cargado en memoria
+func HashBytes(data []byte) string {
+    return fmt.Sprintf("%x", sha256.Sum256(data))
+}
@@ -2,11 +2,12 @@ package utils
 
 import (
     "archive/zip"
-    "io"
     "os"
     "path/filepath"
     "strings"
     "fmt"
+
+    "p2pfs/internal/fs"
 )
 
 // UnzipFile descomprime un archivo zip a la carpeta destino.
@@ -34,19 +35,12 @@ func UnzipFile(zipPath, destDir string) error {
             return err
         }
 
-        outFile, err := os.OpenFile(fpath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, f.Mode())
-        if err != nil {
-            return err
-        }
-
         rc, err := f.Open()
         if err != nil {
             return err
         }
 
-        _, err = io.Copy(outFile, rc)
-
-        outFile.Close()
+        err = fs.CopyAtomic(fpath, rc, f.Mode().Perm())
         rc.Close()
 
         if err != nil {
@@ -15,16 +15,31 @@ func ZipFolder(sourceDir, targetZip string) error {
     }
     defer zipfile.Close()
 
-    writer := zip.NewWriter(zipfile)
-    defer writer.Close()
+    return ZipTo(zipfile, sourceDir, nil)
+}
+
+// ZipTo escribe en w un ZIP con el directorio source, omitiendo lo que skip
+// rechace (rel es la ruta dentro de source, con "/"); las carpetas omitidas
+// no se recorren.
+func ZipTo(w io.Writer, sourceDir string, skip func(rel string, isDir bool) bool) error {
+    writer := zip.NewWriter(w)
 
     baseDir := filepath.Dir(sourceDir)
 
-    return filepath.Walk(sourceDir, func(path string, info os.FileInfo, err error) error {
+    err := filepath.Walk(sourceDir, func(path string, info os.FileInfo, err error) error {
         if err != nil {
             return err
         }
 
+        if rel, err := filepath.Rel(sourceDir, path); err == nil && rel != "." && skip != nil {
+            if skip(filepath.ToSlash(rel), info.IsDir()) {
+                if info.IsDir() {
+                    return filepath.SkipDir
+                }
+                return nil
+            }
+        }
+
         // Nombre relativo del archivo dentro del ZIP
         relPath, err := filepath.Rel(baseDir, path)
         if err != nil {
@@ -53,6 +68,16 @@ func ZipFolder(sourceDir, targetZip string) error {
             return err
         }
 
+        // Los enlaces simbólicos se guardan como enlaces, con su destino
+        if info.Mode()&os.ModeSymlink != 0 {
+            target, err := os.Readlink(path)
+            if err != nil {
+                return err
+            }
+            _, err = io.WriteString(writerEntry, target)
+            return err
+        }
+
         if !info.IsDir() {
             file, err := os.Open(path)
             if err != nil {
@@ -65,5 +90,10 @@ func ZipFolder(sourceDir, targetZip string) error {
 
         return nil
     })
+    if err != nil {
+        writer.Close()
+        return err
+    }
+    return writer.Close()
 }
 
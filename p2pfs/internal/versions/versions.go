@@ -0,0 +1,409 @@
+// Package versions conserva las versiones anteriores de los archivos de una
+// carpeta compartida cuando se sobrescriben o se borran. Cada versión se
+// guarda en <carpeta>/.versions/<ruta>/<nombre>~<fecha><ext>.
+package versions
+
+import (
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Dir es la carpeta, dentro de cada carpeta compartida, donde se guardan
+// las versiones
+const Dir = ".versions"
+
+// stamp es el formato de fecha que identifica una versión
+const stamp = "20060102-150405.000"
+
+// Tipos de política
+const (
+	Simple    = "simple"
+	Staggered = "staggered"
+	None      = "none"
+)
+
+// Policy decide cuántas versiones se conservan
+type Policy struct {
+	Type   string        // simple, staggered o none
+	Keep   int           // versiones por archivo; 0 = sin límite
+	MaxAge time.Duration // antigüedad máxima; 0 = sin límite
+}
+
+// Version es una copia anterior de un archivo
+type Version struct {
+	ID   string    `json:"id"`
+	Time time.Time `json:"time"`
+	Size int64     `json:"size"`
+}
+
+// staggeredSteps son los tramos de la política escalonada: hasta cada
+// antigüedad se guarda como mucho una versión por intervalo
+var staggeredSteps = []struct {
+	until, every time.Duration
+}{
+	{time.Hour, 30 * time.Second},
+	{24 * time.Hour, time.Hour},
+	{30 * 24 * time.Hour, 24 * time.Hour},
+}
+
+// staggeredLast es el intervalo a partir del último tramo
+const staggeredLast = 7 * 24 * time.Hour
+
+// versionID identifica la versión creada en t; n > 1 distingue las que
+// coinciden en el mismo milisegundo
+func versionID(t time.Time, n int) string {
+	if n <= 1 {
+		return t.Format(stamp)
+	}
+	return fmt.Sprintf("%s-%d", t.Format(stamp), n)
+}
+
+// parseID devuelve la fecha de una versión y la longitud de su ID al
+// principio de s
+func parseID(s string) (time.Time, int, bool) {
+	if len(s) < len(stamp) {
+		return time.Time{}, 0, false
+	}
+	t, err := time.ParseInLocation(stamp, s[:len(stamp)], time.Local)
+	if err != nil {
+		return time.Time{}, 0, false
+	}
+	n := len(stamp)
+	if n < len(s) && s[n] == '-' {
+		digits := n + 1
+		for digits < len(s) && s[digits] >= '0' && s[digits] <= '9' {
+			digits++
+		}
+		if digits > n+1 {
+			n = digits
+		}
+	}
+	return t, n, true
+}
+
+// newer ordena las versiones de la más reciente a la más antigua; en el
+// mismo milisegundo, la de sufijo mayor es la posterior
+func newer(ti time.Time, idi string, tj time.Time, idj string) bool {
+	if !ti.Equal(tj) {
+		return ti.After(tj)
+	}
+	if len(idi) != len(idj) {
+		return len(idi) > len(idj)
+	}
+	return idi > idj
+}
+
+// versionName es el nombre de la versión id de base
+func versionName(base, id string) string {
+	ext := filepath.Ext(base)
+	return strings.TrimSuffix(base, ext) + "~" + id + ext
+}
+
+// parseName reconoce el nombre de una versión de base y devuelve su ID y su fecha
+func parseName(base, name string) (string, time.Time, bool) {
+	ext := filepath.Ext(base)
+	prefix := strings.TrimSuffix(base, ext) + "~"
+	if !strings.HasPrefix(name, prefix) || !strings.HasSuffix(name, ext) {
+		return "", time.Time{}, false
+	}
+	id := name[len(prefix) : len(name)-len(ext)]
+	t, n, ok := parseID(id)
+	if !ok || n != len(id) {
+		return "", time.Time{}, false
+	}
+	return id, t, true
+}
+
+// splitName separa el nombre de una versión en el del archivo original y su fecha
+func splitName(name string) (string, time.Time, bool) {
+	i := strings.LastIndex(name, "~")
+	if i < 0 {
+		return "", time.Time{}, false
+	}
+	t, n, ok := parseID(name[i+1:])
+	if !ok {
+		return "", time.Time{}, false
+	}
+	return name[:i] + name[i+1+n:], t, true
+}
+
+// storeDir es la carpeta de versiones de los archivos de la carpeta relDir
+func storeDir(root, relDir string) string {
+	return filepath.Join(root, Dir, filepath.FromSlash(relDir))
+}
+
+// Archive mueve el archivo root/rel (o, si es una carpeta, cada archivo que
+// contiene) al almacén de versiones antes de que se sobrescriba o se borre.
+// Si no existe o la política es None no hace nada.
+func Archive(root, rel string, policy Policy) error {
+	return archive(root, rel, policy, false)
+}
+
+// Keep guarda como versión una copia de root/rel sin quitarla de su sitio,
+// para sustituirla después con un rename sin que el archivo llegue a faltar
+func Keep(root, rel string, policy Policy) error {
+	return archive(root, rel, policy, true)
+}
+
+// ArchiveFrom guarda como versiones de root/rel el archivo o carpeta src,
+// que ya se apartó de su sitio
+func ArchiveFrom(root, rel, src string, policy Policy) error {
+	return archivePath(root, rel, src, policy, false)
+}
+
+func archive(root, rel string, policy Policy, keep bool) error {
+	return archivePath(root, rel, filepath.Join(root, filepath.FromSlash(rel)), policy, keep)
+}
+
+func archivePath(root, rel, src string, policy Policy, keep bool) error {
+	if policy.Type == None {
+		return nil
+	}
+	info, err := os.Lstat(src)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+
+	if !info.IsDir() {
+		return archiveFile(root, rel, src, policy, keep)
+	}
+	return filepath.WalkDir(src, func(p string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		sub, err := filepath.Rel(src, p)
+		if err != nil {
+			return err
+		}
+		return archiveFile(root, path.Join(rel, filepath.ToSlash(sub)), p, policy, keep)
+	})
+}
+
+func archiveFile(root, rel, src string, policy Policy, keep bool) error {
+	dir := storeDir(root, path.Dir(rel))
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	place := moveExclusive
+	if keep {
+		place = linkOrCopy
+	}
+	// Dos versiones en el mismo milisegundo no se pisan: la segunda lleva sufijo
+	now := time.Now()
+	for n := 1; ; n++ {
+		err := place(src, filepath.Join(dir, versionName(path.Base(rel), versionID(now, n))))
+		if os.IsExist(err) {
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("no se pudo guardar la versión de %s: %v", rel, err)
+		}
+		break
+	}
+	prune(dir, path.Base(rel), policy, now)
+	return nil
+}
+
+// moveExclusive mueve src a dst, que no debe existir
+func moveExclusive(src, dst string) error {
+	f, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+	if err != nil {
+		return err
+	}
+	f.Close()
+	if err := os.Rename(src, dst); err != nil {
+		os.Remove(dst)
+		return err
+	}
+	return nil
+}
+
+// linkOrCopy enlaza dst con src o, si el sistema no lo permite, lo copia;
+// dst no debe existir
+func linkOrCopy(src, dst string) error {
+	err := os.Link(src, dst)
+	if err == nil || os.IsExist(err) {
+		return err
+	}
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		os.Remove(dst)
+		return err
+	}
+	return out.Close()
+}
+
+// List devuelve las versiones guardadas de root/rel, de la más reciente a
+// la más antigua
+func List(root, rel string) ([]Version, error) {
+	dir := storeDir(root, path.Dir(rel))
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return []Version{}, nil
+		}
+		return nil, err
+	}
+
+	base := path.Base(rel)
+	list := []Version{}
+	for _, e := range entries {
+		id, t, ok := parseName(base, e.Name())
+		if !ok || e.IsDir() {
+			continue
+		}
+		v := Version{ID: id, Time: t}
+		if info, err := e.Info(); err == nil {
+			v.Size = info.Size()
+		}
+		list = append(list, v)
+	}
+	sort.Slice(list, func(i, j int) bool { return newer(list[i].Time, list[i].ID, list[j].Time, list[j].ID) })
+	return list, nil
+}
+
+// Restore vuelve a poner la versión id de root/rel en su sitio. La copia
+// actual, si existe, pasa a ser una versión más. El archivo restaurado
+// queda con la fecha actual para que se replique como un cambio normal.
+func Restore(root, rel, id string, policy Policy) error {
+	if _, n, ok := parseID(id); !ok || n != len(id) {
+		return fmt.Errorf("versión no válida %q", id)
+	}
+	dir := storeDir(root, path.Dir(rel))
+	src := filepath.Join(dir, versionName(path.Base(rel), id))
+	if _, err := os.Stat(src); err != nil {
+		return fmt.Errorf("versión %s de %s: %w", id, rel, os.ErrNotExist)
+	}
+
+	// Se aparta la versión para que la depuración al guardar la copia
+	// actual no la elimine
+	pending := filepath.Join(dir, "."+path.Base(rel)+".restore")
+	if err := os.Rename(src, pending); err != nil {
+		return err
+	}
+
+	dst := filepath.Join(root, filepath.FromSlash(rel))
+	if policy.Type == None {
+		policy.Type = Simple
+	}
+	if err := Archive(root, rel, policy); err != nil {
+		os.Rename(pending, src)
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+		os.Rename(pending, src)
+		return err
+	}
+	if err := os.Rename(pending, dst); err != nil {
+		os.Rename(pending, src)
+		return err
+	}
+	now := time.Now()
+	return os.Chtimes(dst, now, now)
+}
+
+// Prune aplica la política a todo el almacén de root y devuelve cuántas
+// versiones se eliminaron
+func Prune(root string, policy Policy) (int, error) {
+	store := filepath.Join(root, Dir)
+	removed := 0
+	now := time.Now()
+	err := filepath.WalkDir(store, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		if !d.IsDir() {
+			return nil
+		}
+		entries, err := os.ReadDir(p)
+		if err != nil {
+			return nil
+		}
+		bases := make(map[string]bool)
+		for _, e := range entries {
+			if base, _, ok := splitName(e.Name()); ok && !e.IsDir() {
+				bases[base] = true
+			}
+		}
+		for base := range bases {
+			removed += prune(p, base, policy, now)
+		}
+		return nil
+	})
+	return removed, err
+}
+
+// prune elimina de dir las versiones de base que la política no conserva
+func prune(dir, base string, policy Policy, now time.Time) int {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return 0
+	}
+	type found struct {
+		name string
+		t    time.Time
+	}
+	var list []found
+	for _, e := range entries {
+		if _, t, ok := parseName(base, e.Name()); ok && !e.IsDir() {
+			list = append(list, found{e.Name(), t})
+		}
+	}
+	sort.Slice(list, func(i, j int) bool { return newer(list[i].t, list[i].name, list[j].t, list[j].name) })
+
+	removed := 0
+	var lastKept time.Time
+	kept := 0
+	for _, v := range list {
+		t := v.t
+		age := now.Sub(t)
+		keep := policy.MaxAge <= 0 || age <= policy.MaxAge
+		if keep && policy.Type == Staggered && !lastKept.IsZero() {
+			keep = lastKept.Sub(t) >= staggeredInterval(age)
+		}
+		if keep && policy.Keep > 0 && kept >= policy.Keep {
+			keep = false
+		}
+		if keep {
+			lastKept = t
+			kept++
+			continue
+		}
+		if os.Remove(filepath.Join(dir, v.name)) == nil {
+			removed++
+		}
+	}
+	return removed
+}
+
+// staggeredInterval es la separación mínima entre versiones de esa antigüedad
+func staggeredInterval(age time.Duration) time.Duration {
+	for _, s := range staggeredSteps {
+		if age < s.until {
+			return s.every
+		}
+	}
+	return staggeredLast
+}
@@ -0,0 +1,79 @@
+package versions
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// TestSameMillisecond comprueba que las versiones guardadas en el mismo
+// milisegundo no se pisan y que todas se pueden listar y restaurar
+func TestSameMillisecond(t *testing.T) {
+	root := t.TempDir()
+	file := filepath.Join(root, "a.txt")
+	policy := Policy{Type: Simple}
+
+	const n = 5
+	for i := 0; i < n; i++ {
+		if err := os.WriteFile(file, []byte(fmt.Sprint(i)), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err := Archive(root, "a.txt", policy); err != nil {
+			t.Fatal(err)
+		}
+	}
+	list, err := List(root, "a.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(list) != n {
+		t.Fatalf("%d versiones, se esperaban %d: %v", len(list), n, list)
+	}
+
+	seen := make(map[string]bool)
+	for _, v := range list {
+		data, err := os.ReadFile(filepath.Join(root, Dir, versionName("a.txt", v.ID)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		seen[string(data)] = true
+	}
+	if len(seen) != n {
+		t.Fatalf("se perdieron versiones: %v", seen)
+	}
+
+	if err := Restore(root, "a.txt", list[len(list)-1].ID, policy); err != nil {
+		t.Fatal(err)
+	}
+	if data, _ := os.ReadFile(file); string(data) != "0" {
+		t.Fatalf("restaurado %q, se esperaba la primera versión", data)
+	}
+}
+
+// TestParseName comprueba los nombres de versión con y sin sufijo
+func TestParseName(t *testing.T) {
+	now := time.Date(2026, 10, 19, 10, 15, 0, 123e6, time.Local)
+	for _, tc := range []struct {
+		base, id string
+	}{
+		{"a.txt", versionID(now, 1)},
+		{"a.txt", versionID(now, 2)},
+		{"a.tar.gz", versionID(now, 12)},
+		{"Makefile", versionID(now, 3)},
+	} {
+		name := versionName(tc.base, tc.id)
+		id, got, ok := parseName(tc.base, name)
+		if !ok || id != tc.id || !got.Equal(now) {
+			t.Errorf("parseName(%q, %q) = %q, %v, %v", tc.base, name, id, got, ok)
+		}
+		base, got, ok := splitName(name)
+		if !ok || base != tc.base || !got.Equal(now) {
+			t.Errorf("splitName(%q) = %q, %v, %v", name, base, got, ok)
+		}
+	}
+	if _, _, ok := parseName("a.txt", "a~20261019-101500.123-x.txt"); ok {
+		t.Error("se aceptó un sufijo que no es un número")
+	}
+}